@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const MethodAPIKey = "api_key"
+
+// LegacyAPIKey is the shared key clients sent before authentication was
+// configurable. It is accepted only when no config is given, as an editor.
+const LegacyAPIKey = "November 10, 2009"
+
+// DefaultSubject is the principal the legacy key authenticates as, and the
+// owner of data created before owners existed.
+const DefaultSubject = "default"
+
+type APIKey struct {
+	Subject string   `json:"subject"`
+	SHA256  string   `json:"sha256"`
+	Roles   []string `json:"roles"`
+	Tenant  string   `json:"tenant"`
+}
+
+// APIKeys authenticates the raw Authorization header, optionally prefixed
+// with "ApiKey ", against keys stored only as SHA-256 hashes.
+type APIKeys struct {
+	byHash map[string]Principal
+}
+
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func NewAPIKeys(keys []APIKey) (*APIKeys, error) {
+	a := &APIKeys{byHash: map[string]Principal{}}
+	for _, k := range keys {
+		hash := strings.ToLower(k.SHA256)
+		if raw, err := hex.DecodeString(hash); err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("api key %q: sha256 must be 64 hex characters", k.Subject)
+		}
+		if k.Subject == "" {
+			return nil, fmt.Errorf("api key %s: missing subject", hash)
+		}
+		a.byHash[hash] = Principal{Subject: k.Subject, Roles: k.Roles, Method: MethodAPIKey, Tenant: k.Tenant}
+	}
+	return a, nil
+}
+
+func LegacyAPIKeys() *APIKeys {
+	a, _ := NewAPIKeys([]APIKey{{Subject: DefaultSubject, SHA256: HashAPIKey(LegacyAPIKey), Roles: []string{RoleEditor}}})
+	return a
+}
+
+func (a *APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" || strings.HasPrefix(header, "Bearer ") {
+		return Principal{}, ErrNoCredentials
+	}
+	key := strings.TrimPrefix(header, "ApiKey ")
+	// only hashes are kept, so the lookup never compares secrets directly
+	p, ok := a.byHash[HashAPIKey(key)]
+	if !ok {
+		return Principal{}, ErrInvalidCredentials
+	}
+	return p, nil
+}
@@ -0,0 +1,68 @@
+//go:build unit
+
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithAuthorization(header string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if header != "" {
+		req.Header.Set("Authorization", header)
+	}
+	return req
+}
+
+func TestAPIKeys(t *testing.T) {
+	keys, err := NewAPIKeys([]APIKey{
+		{Subject: "reporting", SHA256: HashAPIKey("s3cret-reporting"), Roles: []string{"admin"}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	tests := []struct {
+		testname string
+		header   string
+		want     Principal
+		wantErr  error
+	}{
+		{"Raw key", "s3cret-reporting", Principal{Subject: "reporting", Roles: []string{"admin"}, Method: MethodAPIKey}, nil},
+		{"ApiKey scheme", "ApiKey s3cret-reporting", Principal{Subject: "reporting", Roles: []string{"admin"}, Method: MethodAPIKey}, nil},
+		{"Unknown key", "HELLO", Principal{}, ErrInvalidCredentials},
+		{"No header", "", Principal{}, ErrNoCredentials},
+		{"Bearer token is left to JWT", "Bearer abc", Principal{}, ErrNoCredentials},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := keys.Authenticate(requestWithAuthorization(tt.header))
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewAPIKeysRejectsBadHash(t *testing.T) {
+	_, err := NewAPIKeys([]APIKey{{Subject: "reporting", SHA256: "s3cret-reporting"}})
+	assert.Error(t, err)
+}
+
+func TestLegacyAPIKeys(t *testing.T) {
+	got, err := LegacyAPIKeys().Authenticate(requestWithAuthorization(LegacyAPIKey))
+	assert.NoError(t, err)
+	assert.Equal(t, "default", got.Subject)
+}
+
+func TestChain(t *testing.T) {
+	chain := Chain{LegacyAPIKeys(), &JWTVerifier{}}
+	_, err := chain.Authenticate(requestWithAuthorization(""))
+	assert.ErrorIs(t, err, ErrNoCredentials)
+	_, err = chain.Authenticate(requestWithAuthorization("Bearer not.a.jwt"))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = chain.Authenticate(requestWithAuthorization(LegacyAPIKey))
+	assert.NoError(t, err)
+}
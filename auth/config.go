@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config is the JSON document named by AUTH_CONFIG, for example
+//
+//	{
+//		"api_keys": [{"subject": "reporting", "sha256": "<hex>", "roles": ["admin"]}],
+//		"jwt": {"jwks_file": "jwks.json", "issuer": "https://id.example.com", "audience": "expenses", "leeway": "30s"}
+//	}
+//
+// jwks_file is resolved relative to the config file.
+type Config struct {
+	APIKeys []APIKey   `json:"api_keys"`
+	JWT     *JWTConfig `json:"jwt"`
+}
+
+type JWTConfig struct {
+	JWKSFile string `json:"jwks_file"`
+	Issuer   string `json:"issuer"`
+	Audience string `json:"audience"`
+	Leeway   string `json:"leeway"`
+}
+
+func LoadConfig(path string) (Authenticator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := Config{}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("auth config %s: %w", path, err)
+	}
+	return cfg.Authenticator(filepath.Dir(path))
+}
+
+// Authenticator builds the chain described by cfg, resolving relative file
+// names against dir.
+func (cfg Config) Authenticator(dir string) (Authenticator, error) {
+	chain := Chain{}
+	if len(cfg.APIKeys) > 0 {
+		keys, err := NewAPIKeys(cfg.APIKeys)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if cfg.JWT != nil {
+		jwksFile := cfg.JWT.JWKSFile
+		if !filepath.IsAbs(jwksFile) {
+			jwksFile = filepath.Join(dir, jwksFile)
+		}
+		keys, err := LoadJWKS(jwksFile)
+		if err != nil {
+			return nil, fmt.Errorf("jwks %s: %w", jwksFile, err)
+		}
+		v := &JWTVerifier{Keys: keys, Issuer: cfg.JWT.Issuer, Audience: cfg.JWT.Audience}
+		if cfg.JWT.Leeway != "" {
+			if v.Leeway, err = time.ParseDuration(cfg.JWT.Leeway); err != nil {
+				return nil, fmt.Errorf("jwt leeway: %w", err)
+			}
+		}
+		chain = append(chain, v)
+	}
+	if len(chain) == 0 {
+		return nil, fmt.Errorf("auth config has neither api_keys nor jwt")
+	}
+	return chain, nil
+}
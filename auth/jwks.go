@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	K   string `json:"k"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// verificationKey is either an HMAC secret (kty "oct") or an RSA public key.
+type verificationKey struct {
+	kid    string
+	alg    string
+	secret []byte
+	rsa    *rsa.PublicKey
+}
+
+// JWKS is a set of keys read from a local JSON Web Key Set (RFC 7517).
+type JWKS struct {
+	keys []verificationKey
+}
+
+func ParseJWKS(data []byte) (JWKS, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return JWKS{}, err
+	}
+	jwks := JWKS{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key := verificationKey{kid: k.Kid, alg: k.Alg}
+		switch k.Kty {
+		case "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(k.K)
+			if err != nil || len(secret) == 0 {
+				return JWKS{}, fmt.Errorf("jwk %q: invalid k", k.Kid)
+			}
+			key.secret = secret
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil || len(n) == 0 || len(e) == 0 || len(e) > 4 {
+				return JWKS{}, fmt.Errorf("jwk %q: invalid n or e", k.Kid)
+			}
+			key.rsa = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		default:
+			continue
+		}
+		jwks.keys = append(jwks.keys, key)
+	}
+	if len(jwks.keys) == 0 {
+		return JWKS{}, fmt.Errorf("jwks has no usable signing keys")
+	}
+	return jwks, nil
+}
+
+func LoadJWKS(path string) (JWKS, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return JWKS{}, err
+	}
+	return ParseJWKS(data)
+}
+
+// key finds the key for kid that can verify alg. The key type must match
+// the algorithm so an RSA public key is never used as an HMAC secret.
+func (s JWKS) key(kid, alg string) (verificationKey, bool) {
+	for _, k := range s.keys {
+		if kid != "" && k.kid != kid {
+			continue
+		}
+		if k.alg != "" && k.alg != alg {
+			continue
+		}
+		if (alg == "HS256" && k.secret != nil) || (alg == "RS256" && k.rsa != nil) {
+			return k, true
+		}
+	}
+	return verificationKey{}, false
+}
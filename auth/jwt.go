@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const MethodJWT = "jwt"
+
+// audience accepts the aud claim as a single string or an array.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+type jwtClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+	Roles     []string `json:"roles"`
+	Tenant    string   `json:"tenant"`
+}
+
+// JWTVerifier authenticates "Bearer" tokens signed with HS256 or RS256 by a
+// key in Keys. Tokens must carry exp and, when configured, match Issuer and
+// Audience.
+type JWTVerifier struct {
+	Keys     JWKS
+	Issuer   string
+	Audience string
+	Leeway   time.Duration
+	Now      func() time.Time
+}
+
+func (v *JWTVerifier) Authenticate(r *http.Request) (Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return Principal{}, ErrNoCredentials
+	}
+	claims, err := v.verify(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	if err != nil {
+		return Principal{}, err
+	}
+	return Principal{Subject: claims.Subject, Roles: claims.Roles, Method: MethodJWT, Tenant: claims.Tenant}, nil
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (v *JWTVerifier) verify(token string) (jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	key, ok := v.Keys.key(header.Kid, header.Alg)
+	if !ok {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	if !verifySignature(header.Alg, key, signed, sig) {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+
+	claims := jwtClaims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	if claims.ExpiresAt == nil || !now.Before(numericDate(*claims.ExpiresAt).Add(v.Leeway)) {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	if claims.NotBefore != nil && now.Add(v.Leeway).Before(numericDate(*claims.NotBefore)) {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	if v.Audience != "" && !claims.Audience.contains(v.Audience) {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	if claims.Subject == "" {
+		return jwtClaims{}, ErrInvalidCredentials
+	}
+	return claims, nil
+}
+
+func verifySignature(alg string, key verificationKey, signed, sig []byte) bool {
+	digest := sha256.Sum256(signed)
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.secret)
+		mac.Write(signed)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case "RS256":
+		return rsa.VerifyPKCS1v15(key.rsa, crypto.SHA256, digest[:], sig) == nil
+	}
+	return false
+}
+
+func numericDate(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+func (a audience) contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,166 @@
+//go:build unit
+
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	testNow    = time.Date(2022, 12, 1, 9, 30, 0, 0, time.UTC)
+	testSecret = []byte("a-very-long-test-secret-for-hs256")
+)
+
+func b64(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func signToken(t *testing.T, alg, kid string, claims map[string]interface{}, rsaKey *rsa.PrivateKey, secret []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := b64(header) + "." + b64(payload)
+	var sig []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signed + "." + b64(sig)
+}
+
+func testJWKS(t *testing.T, rsaKey *rsa.PrivateKey) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "alg": "RS256", "use": "sig",
+				"n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+			{"kty": "oct", "kid": "hmac-1", "alg": "HS256", "k": b64(testSecret)},
+		},
+	})
+	return data
+}
+
+func TestJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	keys, err := ParseJWKS(testJWKS(t, rsaKey))
+	if !assert.NoError(t, err) {
+		return
+	}
+	v := &JWTVerifier{Keys: keys, Issuer: "https://id.example.com", Audience: "expenses", Now: func() time.Time { return testNow }}
+	claims := func(overrides map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://id.example.com",
+			"sub":   "user-42",
+			"aud":   []string{"expenses", "other"},
+			"exp":   testNow.Add(time.Hour).Unix(),
+			"roles": []string{"admin"},
+		}
+		for k, val := range overrides {
+			if val == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = val
+		}
+		return c
+	}
+
+	tests := []struct {
+		testname string
+		token    string
+		wantErr  bool
+	}{
+		{"RS256 token", signToken(t, "RS256", "rsa-1", claims(nil), rsaKey, nil), false},
+		{"HS256 token with string audience", signToken(t, "HS256", "hmac-1", claims(map[string]interface{}{"aud": "expenses"}), nil, testSecret), false},
+		{"Token without kid picks matching key type", signToken(t, "HS256", "", claims(nil), nil, testSecret), false},
+		{"Expired token", signToken(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": testNow.Add(-time.Minute).Unix()}), rsaKey, nil), true},
+		{"Token without exp", signToken(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": nil}), rsaKey, nil), true},
+		{"Token not yet valid", signToken(t, "RS256", "rsa-1", claims(map[string]interface{}{"nbf": testNow.Add(time.Minute).Unix()}), rsaKey, nil), true},
+		{"Wrong issuer", signToken(t, "RS256", "rsa-1", claims(map[string]interface{}{"iss": "https://evil.example.com"}), rsaKey, nil), true},
+		{"Wrong audience", signToken(t, "RS256", "rsa-1", claims(map[string]interface{}{"aud": "billing"}), rsaKey, nil), true},
+		{"Signed by unknown key", signToken(t, "RS256", "rsa-1", claims(nil), otherKey, nil), true},
+		{"Wrong HMAC secret", signToken(t, "HS256", "hmac-1", claims(nil), nil, []byte("guess")), true},
+		{"HS256 using the RSA kid is refused", signToken(t, "HS256", "rsa-1", claims(nil), nil, testSecret), true},
+		{"alg none is refused", signToken(t, "none", "", claims(nil), nil, nil), true},
+		{"Malformed token", "not.a.jwt", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := v.Authenticate(requestWithAuthorization("Bearer " + tt.token))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidCredentials)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, Principal{Subject: "user-42", Roles: []string{"admin"}, Method: MethodJWT}, got)
+			}
+		})
+	}
+
+	t.Run("Leeway accepts recently expired token", func(t *testing.T) {
+		lenient := *v
+		lenient.Leeway = 2 * time.Minute
+		token := signToken(t, "RS256", "rsa-1", claims(map[string]interface{}{"exp": testNow.Add(-time.Minute).Unix()}), rsaKey, nil)
+		_, err := lenient.Authenticate(requestWithAuthorization("Bearer " + token))
+		assert.NoError(t, err)
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "jwks.json"), testJWKS(t, rsaKey), 0o600)
+	config := fmt.Sprintf(`{
+		"api_keys": [{"subject": "reporting", "sha256": %q}],
+		"jwt": {"jwks_file": "jwks.json", "audience": "expenses", "leeway": "30s"}
+	}`, HashAPIKey("s3cret-reporting"))
+	path := filepath.Join(dir, "auth.json")
+	os.WriteFile(path, []byte(config), 0o600)
+
+	a, err := LoadConfig(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+	p, err := a.Authenticate(requestWithAuthorization("s3cret-reporting"))
+	assert.NoError(t, err)
+	assert.Equal(t, "reporting", p.Subject)
+
+	token := signToken(t, "RS256", "rsa-1", map[string]interface{}{"sub": "user-42", "aud": "expenses", "exp": time.Now().Add(time.Hour).Unix()}, rsaKey, nil)
+	p, err = a.Authenticate(requestWithAuthorization("Bearer " + token))
+	assert.NoError(t, err)
+	assert.Equal(t, "user-42", p.Subject)
+
+	_, err = a.Authenticate(requestWithAuthorization(LegacyAPIKey))
+	assert.ErrorIs(t, err, ErrInvalidCredentials)
+
+	os.WriteFile(path, []byte(`{"jwt": {"jwks_file": "missing.json"}}`), 0o600)
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+}
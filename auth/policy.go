@@ -0,0 +1,159 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	RoleViewer   = "viewer"
+	RoleEditor   = "editor"
+	RoleApprover = "approver"
+)
+
+const (
+	PermReadExpenses    = "expenses:read"
+	PermWriteExpenses   = "expenses:write"
+	PermApproveExpenses = "expenses:approve"
+	// PermManageRates changes the exchange rates every user's conversions
+	// use, so only admins have it by default.
+	PermManageRates = "rates:manage"
+	// PermAll grants every permission.
+	PermAll = "*"
+)
+
+// Rule requires Permission for requests matching Method and the echo route
+// Path, e.g. "/expenses/:id".
+type Rule struct {
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	Permission string `json:"permission"`
+}
+
+// Policy is the JSON document named by RBAC_POLICY, for example
+//
+//	{
+//		"roles": {"viewer": ["expenses:read"], "admin": ["*"]},
+//		"routes": [{"method": "GET", "path": "/expenses/:id", "permission": "expenses:read"}]
+//	}
+type Policy struct {
+	Roles  map[string][]string `json:"roles"`
+	Routes []Rule              `json:"routes"`
+}
+
+func DefaultPolicy() *Policy {
+	return &Policy{
+		Roles: map[string][]string{
+			RoleViewer:   {PermReadExpenses},
+			RoleEditor:   {PermReadExpenses, PermWriteExpenses},
+			RoleApprover: {PermReadExpenses, PermApproveExpenses},
+			RoleAdmin:    {PermAll},
+		},
+		Routes: []Rule{
+			{"GET", "/expenses", PermReadExpenses},
+			{"GET", "/expenses/search", PermReadExpenses},
+			{"GET", "/expenses/export", PermReadExpenses},
+			{"GET", "/expenses/summary", PermReadExpenses},
+			{"GET", "/expenses/:id", PermReadExpenses},
+			{"POST", "/expenses", PermWriteExpenses},
+			{"POST", "/expenses/import", PermWriteExpenses},
+			{"POST", "/expenses/import/statement", PermWriteExpenses},
+			{"PUT", "/expenses/:id", PermWriteExpenses},
+			{"PATCH", "/expenses/:id", PermWriteExpenses},
+			{"DELETE", "/expenses/:id", PermWriteExpenses},
+			{"POST", "/expenses/:id/restore", PermWriteExpenses},
+			{"POST", "/expenses/:id/submit", PermWriteExpenses},
+			{"POST", "/expenses/:id/approve", PermApproveExpenses},
+			{"POST", "/expenses/:id/reject", PermApproveExpenses},
+			{"POST", "/expenses/:id/reimburse", PermApproveExpenses},
+			{"POST", "/expenses/:id/attachments", PermWriteExpenses},
+			{"GET", "/expenses/:id/attachments", PermReadExpenses},
+			{"GET", "/expenses/:id/attachments/:attachment_id", PermReadExpenses},
+			{"DELETE", "/expenses/:id/attachments/:attachment_id", PermWriteExpenses},
+			{"PUT", "/expenses/:id/split", PermWriteExpenses},
+			{"GET", "/expenses/:id/split", PermReadExpenses},
+			{"DELETE", "/expenses/:id/split", PermWriteExpenses},
+			{"GET", "/balances", PermReadExpenses},
+			{"POST", "/budgets", PermWriteExpenses},
+			{"GET", "/budgets", PermReadExpenses},
+			{"GET", "/budgets/:id", PermReadExpenses},
+			{"PUT", "/budgets/:id", PermWriteExpenses},
+			{"DELETE", "/budgets/:id", PermWriteExpenses},
+			{"GET", "/budgets/:id/status", PermReadExpenses},
+			{"POST", "/recurring-expenses", PermWriteExpenses},
+			{"GET", "/recurring-expenses", PermReadExpenses},
+			{"GET", "/recurring-expenses/:id", PermReadExpenses},
+			{"PUT", "/recurring-expenses/:id", PermWriteExpenses},
+			{"DELETE", "/recurring-expenses/:id", PermWriteExpenses},
+			{"POST", "/accounts", PermWriteExpenses},
+			{"GET", "/accounts", PermReadExpenses},
+			{"GET", "/accounts/:id", PermReadExpenses},
+			{"PUT", "/accounts/:id", PermWriteExpenses},
+			{"DELETE", "/accounts/:id", PermWriteExpenses},
+			{"GET", "/accounts/:id/statement", PermReadExpenses},
+			{"POST", "/transactions", PermWriteExpenses},
+			{"GET", "/transactions", PermReadExpenses},
+			{"GET", "/transactions/flow", PermReadExpenses},
+			{"GET", "/transactions/:id", PermReadExpenses},
+			{"PUT", "/transactions/:id", PermWriteExpenses},
+			{"DELETE", "/transactions/:id", PermWriteExpenses},
+			{"POST", "/rates", PermManageRates},
+			{"GET", "/rates", PermReadExpenses},
+		},
+	}
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("rbac policy %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("rbac policy %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// Validate rejects incomplete or duplicate rules, which would otherwise
+// leave a route denied or ambiguous without notice.
+func (p *Policy) Validate() error {
+	seen := map[string]bool{}
+	for _, r := range p.Routes {
+		if r.Method == "" || r.Path == "" || r.Permission == "" {
+			return fmt.Errorf("route %s %s: method, path and permission are required", r.Method, r.Path)
+		}
+		if seen[r.Method+" "+r.Path] {
+			return fmt.Errorf("route %s %s: listed twice", r.Method, r.Path)
+		}
+		seen[r.Method+" "+r.Path] = true
+	}
+	return nil
+}
+
+// Required returns the permission a route needs, and false when the policy
+// does not list it.
+func (p *Policy) Required(method, path string) (string, bool) {
+	for _, r := range p.Routes {
+		if r.Method == method && r.Path == path {
+			return r.Permission, true
+		}
+	}
+	return "", false
+}
+
+// Allows reports whether any of the principal's roles grants permission.
+func (p *Policy) Allows(pr Principal, permission string) bool {
+	for _, role := range pr.Roles {
+		for _, granted := range p.Roles[role] {
+			if granted == permission || granted == PermAll {
+				return true
+			}
+		}
+	}
+	return false
+}
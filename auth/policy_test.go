@@ -0,0 +1,91 @@
+//go:build unit
+
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	p := DefaultPolicy()
+	tests := []struct {
+		testname string
+		roles    []string
+		method   string
+		path     string
+		want     bool
+	}{
+		{"Viewer may read", []string{RoleViewer}, "GET", "/expenses/:id", true},
+		{"Viewer may search", []string{RoleViewer}, "GET", "/expenses/search", true},
+		{"Viewer may not create", []string{RoleViewer}, "POST", "/expenses", false},
+		{"Editor may update", []string{RoleEditor}, "PUT", "/expenses/:id", true},
+		{"Editor may delete", []string{RoleEditor}, "DELETE", "/expenses/:id", true},
+		{"Approver may not edit", []string{RoleApprover}, "PATCH", "/expenses/:id", false},
+		{"Admin may do anything", []string{RoleAdmin}, "POST", "/expenses/:id/restore", true},
+		{"Editor may not manage rates", []string{RoleEditor}, "POST", "/rates", false},
+		{"Editor may read rates", []string{RoleEditor}, "GET", "/rates", true},
+		{"Admin may manage rates", []string{RoleAdmin}, "POST", "/rates", true},
+		{"Roles combine", []string{RoleApprover, RoleEditor}, "POST", "/expenses", true},
+		{"No roles grant nothing", nil, "GET", "/expenses", false},
+		{"Unknown role grants nothing", []string{"auditor"}, "GET", "/expenses", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			required, ok := p.Required(tt.method, tt.path)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, p.Allows(Principal{Subject: "alice", Roles: tt.roles}, required))
+		})
+	}
+
+	t.Run("Unlisted route is not required", func(t *testing.T) {
+		_, ok := p.Required("GET", "/admin")
+		assert.False(t, ok)
+	})
+	t.Run("Legacy key is an editor", func(t *testing.T) {
+		got, _ := LegacyAPIKeys().Authenticate(requestWithAuthorization(LegacyAPIKey))
+		assert.True(t, p.Allows(got, PermWriteExpenses))
+		assert.False(t, p.Allows(got, PermApproveExpenses))
+		assert.False(t, p.Allows(got, PermManageRates))
+	})
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rbac.json")
+
+	os.WriteFile(path, []byte(`{
+		"roles": {"auditor": ["expenses:read"]},
+		"routes": [{"method": "GET", "path": "/expenses", "permission": "expenses:read"}]
+	}`), 0o600)
+	p, err := LoadPolicy(path)
+	if assert.NoError(t, err) {
+		required, ok := p.Required("GET", "/expenses")
+		assert.True(t, ok)
+		assert.True(t, p.Allows(Principal{Roles: []string{"auditor"}}, required))
+		_, ok = p.Required("POST", "/expenses")
+		assert.False(t, ok)
+	}
+
+	invalidTests := []struct {
+		testname string
+		config   string
+	}{
+		{"Malformed JSON", `{"roles": `},
+		{"Route without permission", `{"routes": [{"method": "GET", "path": "/expenses"}]}`},
+		{"Route listed twice", `{"routes": [
+			{"method": "GET", "path": "/expenses", "permission": "expenses:read"},
+			{"method": "GET", "path": "/expenses", "permission": "expenses:write"}
+		]}`},
+	}
+	for _, tt := range invalidTests {
+		t.Run(tt.testname, func(t *testing.T) {
+			os.WriteFile(path, []byte(tt.config), 0o600)
+			_, err := LoadPolicy(path)
+			assert.Error(t, err)
+		})
+	}
+}
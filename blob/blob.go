@@ -0,0 +1,46 @@
+// Package blob stores opaque objects, such as receipt images, under string
+// keys.
+package blob
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns ErrNotFound for a key that was never put or was deleted.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete succeeds for missing keys too.
+	Delete(ctx context.Context, key string) error
+}
+
+// ValidKey accepts slash separated keys whose segments are made of letters,
+// digits, '-', '_' and '.', without "." or ".." segments, so every store can
+// map them to paths or URLs unescaped.
+func ValidKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, seg := range strings.Split(key, "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			return false
+		}
+		for _, r := range seg {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func errInvalidKey(key string) error {
+	return errors.New("invalid blob key " + key)
+}
@@ -11,15 +11,19 @@ import (
 var once sync.Once
 
 type DB struct {
-	Database *sql.DB
+	Database  *sql.DB
+	Isolation Isolation
 }
 
 var dbInstance *DB
 
 func initDB() *DB {
 	database, _ := sql.Open("postgres", os.Getenv("DATABASE_URL"))
+	// an invalid TENANT_ISOLATION is rejected by the server at startup
+	isolation, _ := ParseIsolation(os.Getenv("TENANT_ISOLATION"))
 	dbInstance = &DB{
-		Database: database,
+		Database:  database,
+		Isolation: isolation,
 	}
 	return dbInstance
 }
@@ -0,0 +1,263 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key held while migrating so
+// replicas starting together apply each migration once.
+const migrationLockID = 2565_0001
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// LoadMigrations reads <version>_<name>.up.sql and .down.sql pairs from fsys
+// in version order.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		base := path.Base(file)
+		direction := path.Ext(strings.TrimSuffix(base, ".sql"))
+		if direction != ".up" && direction != ".down" {
+			return nil, fmt.Errorf("migration %s: want .up.sql or .down.sql", base)
+		}
+		versionName := strings.SplitN(strings.TrimSuffix(base, direction+".sql"), "_", 2)
+		version, err := strconv.Atoi(versionName[0])
+		if err != nil || len(versionName) != 2 {
+			return nil, fmt.Errorf("migration %s: want <version>_<name>", base)
+		}
+		body, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: versionName[1]}
+			byVersion[version] = m
+		}
+		if m.Name != versionName[1] {
+			return nil, fmt.Errorf("migration %d: names %s and %s differ", version, m.Name, versionName[1])
+		}
+		if direction == ".up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d: missing up file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func Migrations() ([]Migration, error) {
+	sub, err := fs.Sub(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	return LoadMigrations(sub)
+}
+
+// withMigrationLock runs fn on a single connection holding the migration
+// advisory lock, after making sure schema_migrations exists. A non-empty
+// schema is created if needed and used as the connection's search_path.
+func (d *DB) withMigrationLock(ctx context.Context, schema string, fn func(conn *sql.Conn, applied map[int]bool) error) error {
+	conn, err := d.Database.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	if schema != "" {
+		if _, err := conn.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+pq.QuoteIdentifier(schema)); err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "SET search_path TO "+pq.QuoteIdentifier(schema)); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), "RESET search_path")
+	}
+
+	createTb := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+	if _, err := conn.ExecContext(ctx, createTb); err != nil {
+		return err
+	}
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return fn(conn, applied)
+}
+
+func runInTx(ctx context.Context, conn *sql.Conn, statements ...func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, stmt := range statements {
+		if err := stmt(tx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Migrate applies every pending embedded migration, each in its own
+// transaction.
+func (d *DB) Migrate(ctx context.Context) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	return d.MigrateUp(ctx, migrations)
+}
+
+// MigrateUp migrates the public schema and, with IsolationSchema, every
+// provisioned tenant schema.
+func (d *DB) MigrateUp(ctx context.Context, migrations []Migration) error {
+	if err := d.migrateSchemaUp(ctx, "", migrations); err != nil {
+		return err
+	}
+	schemas, err := d.tenantSchemas(ctx)
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		if err := d.migrateSchemaUp(ctx, schema, migrations); err != nil {
+			return fmt.Errorf("schema %s: %w", schema, err)
+		}
+	}
+	return nil
+}
+
+func (d *DB) migrateSchemaUp(ctx context.Context, schema string, migrations []Migration) error {
+	return d.withMigrationLock(ctx, schema, func(conn *sql.Conn, applied map[int]bool) error {
+		for _, m := range migrations {
+			if applied[m.Version] {
+				continue
+			}
+			m := m
+			err := runInTx(ctx, conn,
+				func(tx *sql.Tx) error {
+					_, err := tx.ExecContext(ctx, m.Up)
+					return err
+				},
+				func(tx *sql.Tx) error {
+					_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name)
+					return err
+				})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the latest steps applied migrations in every tenant
+// schema and then in public.
+func (d *DB) MigrateDown(ctx context.Context, migrations []Migration, steps int) error {
+	schemas, err := d.tenantSchemas(ctx)
+	if err != nil {
+		return err
+	}
+	for _, schema := range schemas {
+		if err := d.migrateSchemaDown(ctx, schema, migrations, steps); err != nil {
+			return fmt.Errorf("schema %s: %w", schema, err)
+		}
+	}
+	return d.migrateSchemaDown(ctx, "", migrations, steps)
+}
+
+func (d *DB) migrateSchemaDown(ctx context.Context, schema string, migrations []Migration, steps int) error {
+	return d.withMigrationLock(ctx, schema, func(conn *sql.Conn, applied map[int]bool) error {
+		for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+			m := migrations[i]
+			if !applied[m.Version] {
+				continue
+			}
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s: missing down file", m.Version, m.Name)
+			}
+			err := runInTx(ctx, conn,
+				func(tx *sql.Tx) error {
+					_, err := tx.ExecContext(ctx, m.Down)
+					return err
+				},
+				func(tx *sql.Tx) error {
+					_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version=$1", m.Version)
+					return err
+				})
+			if err != nil {
+				return fmt.Errorf("migration %d_%s: %w", m.Version, m.Name, err)
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+func (d *DB) MigrationStatus(ctx context.Context, migrations []Migration) ([]MigrationStatus, error) {
+	status := []MigrationStatus{}
+	err := d.withMigrationLock(ctx, "", func(conn *sql.Conn, applied map[int]bool) error {
+		for _, m := range migrations {
+			status = append(status, MigrationStatus{Migration: m, Applied: applied[m.Version]})
+		}
+		return nil
+	})
+	return status, err
+}
@@ -0,0 +1,166 @@
+//go:build unit
+
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func expectMigrationLock(mock sqlmock.Sqlmock, applied ...int) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(driver.ResultNoRows)
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(driver.ResultNoRows)
+	rows := sqlmock.NewRows([]string{"version"})
+	for _, v := range applied {
+		rows.AddRow(v)
+	}
+	mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(rows)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	t.Run("Load Migrations in version order", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0002_add_note.up.sql":   {Data: []byte("ALTER TABLE t ADD COLUMN note TEXT;")},
+			"0002_add_note.down.sql": {Data: []byte("ALTER TABLE t DROP COLUMN note;")},
+			"0001_create.up.sql":     {Data: []byte("CREATE TABLE t (id INT);")},
+		}
+		migrations, err := LoadMigrations(fsys)
+		if assert.NoError(t, err) {
+			assert.Equal(t, []Migration{
+				{Version: 1, Name: "create", Up: "CREATE TABLE t (id INT);"},
+				{Version: 2, Name: "add_note", Up: "ALTER TABLE t ADD COLUMN note TEXT;", Down: "ALTER TABLE t DROP COLUMN note;"},
+			}, migrations)
+		}
+	})
+
+	invalidTests := []struct {
+		testname string
+		fsys     fstest.MapFS
+	}{
+		{"Migration without direction", fstest.MapFS{"0001_create.sql": {}}},
+		{"Migration without version", fstest.MapFS{"create.up.sql": {}}},
+		{"Migration without up file", fstest.MapFS{"0001_create.down.sql": {Data: []byte("DROP TABLE t;")}}},
+		{"Migration with mismatched names", fstest.MapFS{
+			"0001_create.up.sql":  {Data: []byte("CREATE TABLE t (id INT);")},
+			"0001_other.down.sql": {Data: []byte("DROP TABLE t;")},
+		}},
+	}
+	for _, tt := range invalidTests {
+		t.Run(tt.testname, func(t *testing.T) {
+			_, err := LoadMigrations(tt.fsys)
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("Embedded migrations load", func(t *testing.T) {
+		migrations, err := Migrations()
+		if assert.NoError(t, err) {
+			assert.Equal(t, 1, migrations[0].Version)
+			for i, m := range migrations {
+				assert.NotEmpty(t, m.Down, m.Name)
+				if i > 0 {
+					assert.Less(t, migrations[i-1].Version, m.Version)
+				}
+			}
+		}
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "create", Up: "CREATE TABLE t (id INT);", Down: "DROP TABLE t;"},
+		{Version: 2, Name: "add_note", Up: "ALTER TABLE t ADD COLUMN note TEXT;", Down: "ALTER TABLE t DROP COLUMN note;"},
+	}
+
+	t.Run("Migrate Up applies only pending migrations under lock", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		expectMigrationLock(mock, 1)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migrations[1].Up)).WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(2, "add_note").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(driver.ResultNoRows)
+
+		d := &DB{Database: db}
+		err = d.MigrateUp(context.Background(), migrations)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Migrate Up rolls back failed migration", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		expectMigrationLock(mock)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migrations[0].Up)).WillReturnError(assert.AnError)
+		mock.ExpectRollback()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(driver.ResultNoRows)
+
+		d := &DB{Database: db}
+		err = d.MigrateUp(context.Background(), migrations)
+
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Migrate Up fails when lock can not be taken", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectExec("SELECT pg_advisory_lock").WillReturnError(assert.AnError)
+
+		d := &DB{Database: db}
+		err = d.MigrateUp(context.Background(), migrations)
+
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+
+	t.Run("Migrate Down reverts latest applied migration", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		expectMigrationLock(mock, 1, 2)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migrations[1].Down)).WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(driver.ResultNoRows)
+
+		d := &DB{Database: db}
+		err = d.MigrateDown(context.Background(), migrations, 1)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Migration Status reports applied and pending", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		expectMigrationLock(mock, 1)
+		mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(driver.ResultNoRows)
+
+		d := &DB{Database: db}
+		status, err := d.MigrationStatus(context.Background(), migrations)
+
+		if assert.NoError(t, err) {
+			assert.True(t, status[0].Applied)
+			assert.False(t, status[1].Applied)
+		}
+	})
+}
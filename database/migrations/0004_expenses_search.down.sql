@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS expenses_search_vector_idx;
+ALTER TABLE expenses DROP COLUMN IF EXISTS search_vector;
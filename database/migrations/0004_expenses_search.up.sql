@@ -0,0 +1,3 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS search_vector TSVECTOR
+	GENERATED ALWAYS AS (to_tsvector('simple', coalesce(title,'') || ' ' || coalesce(note,''))) STORED;
+CREATE INDEX IF NOT EXISTS expenses_search_vector_idx ON expenses USING GIN (search_vector);
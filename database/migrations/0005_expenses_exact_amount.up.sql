@@ -0,0 +1,2 @@
+ALTER TABLE expenses ALTER COLUMN amount TYPE NUMERIC(19,4) USING round(amount::numeric, 4);
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT 'THB';
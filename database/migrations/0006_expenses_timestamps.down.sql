@@ -0,0 +1,2 @@
+ALTER TABLE expenses DROP COLUMN IF EXISTS updated_at;
+ALTER TABLE expenses DROP COLUMN IF EXISTS spent_at;
@@ -0,0 +1,6 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS spent_at TIMESTAMPTZ;
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ;
+UPDATE expenses SET spent_at = created_at WHERE spent_at IS NULL;
+UPDATE expenses SET updated_at = created_at WHERE updated_at IS NULL;
+ALTER TABLE expenses ALTER COLUMN spent_at SET DEFAULT now(), ALTER COLUMN spent_at SET NOT NULL;
+ALTER TABLE expenses ALTER COLUMN updated_at SET DEFAULT now(), ALTER COLUMN updated_at SET NOT NULL;
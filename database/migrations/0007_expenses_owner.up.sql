@@ -0,0 +1,2 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS owner_id TEXT NOT NULL DEFAULT 'default';
+CREATE INDEX IF NOT EXISTS expenses_owner_id_idx ON expenses (owner_id, id);
@@ -0,0 +1,5 @@
+DROP POLICY IF EXISTS expenses_tenant_isolation ON expenses;
+ALTER TABLE expenses NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE expenses DISABLE ROW LEVEL SECURITY;
+DROP INDEX IF EXISTS expenses_tenant_id_idx;
+ALTER TABLE expenses DROP COLUMN IF EXISTS tenant_id;
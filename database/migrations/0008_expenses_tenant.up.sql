@@ -0,0 +1,10 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL
+	DEFAULT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default');
+CREATE INDEX IF NOT EXISTS expenses_tenant_id_idx ON expenses (tenant_id, id);
+ALTER TABLE expenses ENABLE ROW LEVEL SECURITY;
+ALTER TABLE expenses FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS expenses_tenant_isolation ON expenses;
+-- without app.tenant_id (no isolation, background jobs) every row is visible
+CREATE POLICY expenses_tenant_isolation ON expenses
+	USING (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id))
+	WITH CHECK (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id));
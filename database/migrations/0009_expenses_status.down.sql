@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS expenses_status_idx;
+ALTER TABLE expenses DROP COLUMN IF EXISTS status_reason;
+ALTER TABLE expenses DROP COLUMN IF EXISTS status;
@@ -0,0 +1,4 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'draft'
+	CHECK (status IN ('draft', 'submitted', 'approved', 'rejected', 'reimbursed'));
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS status_reason TEXT NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS expenses_status_idx ON expenses (status, id);
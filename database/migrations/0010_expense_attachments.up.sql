@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS expense_attachments (
+	id SERIAL PRIMARY KEY,
+	expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+	filename TEXT NOT NULL,
+	content_type TEXT NOT NULL,
+	size BIGINT NOT NULL,
+	sha256 TEXT NOT NULL,
+	storage_key TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	tenant_id TEXT NOT NULL DEFAULT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default')
+);
+CREATE INDEX IF NOT EXISTS expense_attachments_expense_id_idx ON expense_attachments (expense_id, id);
+ALTER TABLE expense_attachments ENABLE ROW LEVEL SECURITY;
+ALTER TABLE expense_attachments FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS expense_attachments_tenant_isolation ON expense_attachments;
+CREATE POLICY expense_attachments_tenant_isolation ON expense_attachments
+	USING (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id))
+	WITH CHECK (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id));
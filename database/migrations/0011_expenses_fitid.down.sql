@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS expenses_fitid_idx;
+ALTER TABLE expenses DROP COLUMN IF EXISTS fitid;
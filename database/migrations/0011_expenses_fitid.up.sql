@@ -0,0 +1,4 @@
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS fitid TEXT;
+-- a bank transaction is imported once per owner, even after it is deleted
+CREATE UNIQUE INDEX IF NOT EXISTS expenses_fitid_idx ON expenses (tenant_id, owner_id, fitid)
+	WHERE fitid IS NOT NULL;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS budgets (
+	id SERIAL PRIMARY KEY,
+	owner_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	tags TEXT[] NOT NULL,
+	period TEXT NOT NULL CHECK (period IN ('week', 'month', 'quarter', 'year')),
+	amount_limit NUMERIC(19,4) NOT NULL CHECK (amount_limit > 0),
+	currency TEXT NOT NULL,
+	threshold INT NOT NULL DEFAULT 100,
+	alerted_period TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	tenant_id TEXT NOT NULL DEFAULT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default')
+);
+CREATE INDEX IF NOT EXISTS budgets_owner_id_idx ON budgets (owner_id, id);
+ALTER TABLE budgets ENABLE ROW LEVEL SECURITY;
+ALTER TABLE budgets FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS budgets_tenant_isolation ON budgets;
+CREATE POLICY budgets_tenant_isolation ON budgets
+	USING (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id))
+	WITH CHECK (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id));
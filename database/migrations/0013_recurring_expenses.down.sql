@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS expenses_recurring_idx;
+ALTER TABLE expenses DROP COLUMN IF EXISTS recurring_id;
+DROP TABLE IF EXISTS recurring_expenses;
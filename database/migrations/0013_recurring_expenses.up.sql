@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS recurring_expenses (
+	id SERIAL PRIMARY KEY,
+	owner_id TEXT NOT NULL,
+	title TEXT NOT NULL,
+	amount NUMERIC(19,4) NOT NULL,
+	note TEXT NOT NULL,
+	tags TEXT[] NOT NULL,
+	currency TEXT NOT NULL,
+	frequency TEXT NOT NULL CHECK (frequency IN ('daily', 'weekly', 'monthly', 'yearly')),
+	repeat_interval INT NOT NULL DEFAULT 1 CHECK (repeat_interval > 0),
+	start_at TIMESTAMPTZ NOT NULL,
+	until TIMESTAMPTZ,
+	occurrences INT NOT NULL DEFAULT 0,
+	next_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	tenant_id TEXT NOT NULL DEFAULT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default')
+);
+CREATE INDEX IF NOT EXISTS recurring_expenses_owner_id_idx ON recurring_expenses (owner_id, id);
+CREATE INDEX IF NOT EXISTS recurring_expenses_next_at_idx ON recurring_expenses (next_at) WHERE next_at IS NOT NULL;
+ALTER TABLE recurring_expenses ENABLE ROW LEVEL SECURITY;
+ALTER TABLE recurring_expenses FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS recurring_expenses_tenant_isolation ON recurring_expenses;
+CREATE POLICY recurring_expenses_tenant_isolation ON recurring_expenses
+	USING (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id))
+	WITH CHECK (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id));
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS recurring_id INT REFERENCES recurring_expenses (id) ON DELETE SET NULL;
+-- an occurrence is materialized once, however many schedulers run
+CREATE UNIQUE INDEX IF NOT EXISTS expenses_recurring_idx ON expenses (recurring_id, spent_at)
+	WHERE recurring_id IS NOT NULL;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS expense_shares (
+	expense_id INT NOT NULL REFERENCES expenses (id) ON DELETE CASCADE,
+	participant TEXT NOT NULL,
+	method TEXT NOT NULL CHECK (method IN ('equal', 'percent', 'exact')),
+	percent NUMERIC(7,4),
+	amount NUMERIC(19,4) NOT NULL,
+	position INT NOT NULL,
+	tenant_id TEXT NOT NULL DEFAULT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default'),
+	PRIMARY KEY (expense_id, participant)
+);
+CREATE INDEX IF NOT EXISTS expense_shares_participant_idx ON expense_shares (participant, expense_id);
+ALTER TABLE expense_shares ENABLE ROW LEVEL SECURITY;
+ALTER TABLE expense_shares FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS expense_shares_tenant_isolation ON expense_shares;
+CREATE POLICY expense_shares_tenant_isolation ON expense_shares
+	USING (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id))
+	WITH CHECK (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id));
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS expenses_account_id_idx;
+ALTER TABLE expenses DROP COLUMN IF EXISTS account_id;
+DROP TABLE IF EXISTS accounts;
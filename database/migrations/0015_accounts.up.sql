@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS accounts (
+	id SERIAL PRIMARY KEY,
+	owner_id TEXT NOT NULL,
+	name TEXT NOT NULL,
+	type TEXT NOT NULL CHECK (type IN ('cash', 'credit_card', 'bank', 'e_wallet')),
+	currency TEXT NOT NULL,
+	opening_balance NUMERIC(19,4) NOT NULL DEFAULT 0,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	tenant_id TEXT NOT NULL DEFAULT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default')
+);
+CREATE INDEX IF NOT EXISTS accounts_owner_id_idx ON accounts (owner_id, id);
+ALTER TABLE accounts ENABLE ROW LEVEL SECURITY;
+ALTER TABLE accounts FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS accounts_tenant_isolation ON accounts;
+CREATE POLICY accounts_tenant_isolation ON accounts
+	USING (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id))
+	WITH CHECK (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id));
+-- an account with expenses, even deleted ones, can't be removed
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS account_id INT REFERENCES accounts (id);
+CREATE INDEX IF NOT EXISTS expenses_account_id_idx ON expenses (account_id, spent_at, id)
+	WHERE account_id IS NOT NULL;
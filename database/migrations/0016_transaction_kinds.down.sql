@@ -0,0 +1,5 @@
+DELETE FROM expenses WHERE kind <> 'expense';
+DROP INDEX IF EXISTS expenses_to_account_id_idx;
+ALTER TABLE expenses DROP CONSTRAINT IF EXISTS expenses_transfer_accounts;
+ALTER TABLE expenses DROP COLUMN IF EXISTS to_account_id;
+ALTER TABLE expenses DROP COLUMN IF EXISTS kind;
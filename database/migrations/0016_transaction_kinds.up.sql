@@ -0,0 +1,10 @@
+-- income and transfers share the expenses table, told apart by kind; the
+-- expenses API only ever sees kind 'expense'
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'expense'
+	CHECK (kind IN ('expense', 'income', 'transfer'));
+ALTER TABLE expenses ADD COLUMN IF NOT EXISTS to_account_id INT REFERENCES accounts (id);
+ALTER TABLE expenses DROP CONSTRAINT IF EXISTS expenses_transfer_accounts;
+ALTER TABLE expenses ADD CONSTRAINT expenses_transfer_accounts
+	CHECK ((kind = 'transfer') = (account_id IS NOT NULL AND to_account_id IS NOT NULL AND account_id <> to_account_id));
+CREATE INDEX IF NOT EXISTS expenses_to_account_id_idx ON expenses (to_account_id, spent_at, id)
+	WHERE to_account_id IS NOT NULL;
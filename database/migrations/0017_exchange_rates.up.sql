@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS exchange_rates (
+	base TEXT NOT NULL,
+	quote TEXT NOT NULL CHECK (quote <> base),
+	rate_date DATE NOT NULL,
+	rate NUMERIC(19,8) NOT NULL CHECK (rate > 0),
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	tenant_id TEXT NOT NULL DEFAULT COALESCE(NULLIF(current_setting('app.tenant_id', true), ''), 'default'),
+	PRIMARY KEY (tenant_id, base, quote, rate_date)
+);
+ALTER TABLE exchange_rates ENABLE ROW LEVEL SECURITY;
+ALTER TABLE exchange_rates FORCE ROW LEVEL SECURITY;
+DROP POLICY IF EXISTS exchange_rates_tenant_isolation ON exchange_rates;
+CREATE POLICY exchange_rates_tenant_isolation ON exchange_rates
+	USING (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id))
+	WITH CHECK (COALESCE(current_setting('app.tenant_id', true), '') IN ('', tenant_id));
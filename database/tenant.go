@@ -0,0 +1,198 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+
+	"github.com/lib/pq"
+)
+
+// Isolation selects how tenants sharing one deployment are kept apart.
+type Isolation string
+
+const (
+	// IsolationNone ignores tenants entirely.
+	IsolationNone Isolation = ""
+	// IsolationRLS keeps every tenant in the same tables and lets row-level
+	// security policies filter on the app.tenant_id setting.
+	IsolationRLS Isolation = "rls"
+	// IsolationSchema gives every tenant its own schema, selected through
+	// search_path.
+	IsolationSchema Isolation = "schema"
+)
+
+// DefaultTenant owns the data that existed before tenants, and in
+// IsolationSchema it lives in the public schema.
+const DefaultTenant = "default"
+
+var tenantPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_]{0,39}$`)
+
+func ParseIsolation(s string) (Isolation, error) {
+	switch i := Isolation(s); i {
+	case IsolationNone, IsolationRLS, IsolationSchema:
+		return i, nil
+	}
+	return IsolationNone, fmt.Errorf("unknown tenant isolation %q (want rls or schema)", s)
+}
+
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+func TenantSchema(tenant string) string {
+	if tenant == DefaultTenant {
+		return "public"
+	}
+	return "tenant_" + tenant
+}
+
+type tenantKey struct{}
+
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+func TenantFrom(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// Querier is what *sql.DB and *sql.Tx have in common.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// InTenant runs fn against the tenant in ctx. With isolation on, fn runs in
+// a transaction whose tenant setting or search_path is set locally, so it is
+// never left behind on a pooled connection. Otherwise fn gets the pool.
+func (d *DB) InTenant(ctx context.Context, fn func(q Querier) error) error {
+	if _, ok := TenantFrom(ctx); !ok || d.Isolation == IsolationNone {
+		return fn(d.Database)
+	}
+	return d.InTenantTx(ctx, func(tx *sql.Tx) error {
+		return fn(tx)
+	})
+}
+
+// InTenantTx is InTenant for work that needs a transaction whatever the
+// isolation, such as reading rows and deleting them together.
+func (d *DB) InTenantTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tenant, isolated := TenantFrom(ctx)
+	isolated = isolated && d.Isolation != IsolationNone
+	if isolated && !ValidTenant(tenant) {
+		return fmt.Errorf("invalid tenant %q", tenant)
+	}
+	tx, err := d.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if isolated && d.Isolation == IsolationSchema {
+		_, err = tx.ExecContext(ctx, "SELECT set_config('search_path', $1, true)", pq.QuoteIdentifier(TenantSchema(tenant)))
+	} else if isolated {
+		_, err = tx.ExecContext(ctx, "SELECT set_config('app.tenant_id', $1, true)", tenant)
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func ensureTenantsTable(ctx context.Context, q Querier) error {
+	createTb := `
+	CREATE TABLE IF NOT EXISTS public.tenants (
+		id TEXT PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);`
+	_, err := q.ExecContext(ctx, createTb)
+	return err
+}
+
+func (d *DB) Tenants(ctx context.Context) ([]string, error) {
+	if err := ensureTenantsTable(ctx, d.Database); err != nil {
+		return nil, err
+	}
+	rows, err := d.Database.QueryContext(ctx, "SELECT id FROM public.tenants ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tenants := []string{}
+	for rows.Next() {
+		var tenant string
+		if err := rows.Scan(&tenant); err != nil {
+			return nil, err
+		}
+		tenants = append(tenants, tenant)
+	}
+	return tenants, rows.Err()
+}
+
+// ProvisionTenant registers tenant and, with IsolationSchema, creates its
+// schema and migrates it.
+func (d *DB) ProvisionTenant(ctx context.Context, tenant string, migrations []Migration) error {
+	if !ValidTenant(tenant) {
+		return fmt.Errorf("invalid tenant %q (want lower case letters, digits and _)", tenant)
+	}
+	if err := ensureTenantsTable(ctx, d.Database); err != nil {
+		return err
+	}
+	if _, err := d.Database.ExecContext(ctx, "INSERT INTO public.tenants (id) VALUES ($1) ON CONFLICT DO NOTHING", tenant); err != nil {
+		return err
+	}
+	if d.Isolation != IsolationSchema || tenant == DefaultTenant {
+		return nil
+	}
+	return d.migrateSchemaUp(ctx, TenantSchema(tenant), migrations)
+}
+
+// ForEachTenant calls fn once per schema holding tenant data: for every
+// provisioned tenant with IsolationSchema, and once with ctx otherwise.
+func (d *DB) ForEachTenant(ctx context.Context, fn func(ctx context.Context) error) error {
+	if d.Isolation != IsolationSchema {
+		return fn(ctx)
+	}
+	tenants, err := d.Tenants(ctx)
+	if err != nil {
+		return err
+	}
+	if err := fn(WithTenant(ctx, DefaultTenant)); err != nil {
+		return err
+	}
+	for _, tenant := range tenants {
+		if tenant == DefaultTenant {
+			continue
+		}
+		if err := fn(WithTenant(ctx, tenant)); err != nil {
+			return fmt.Errorf("tenant %s: %w", tenant, err)
+		}
+	}
+	return nil
+}
+
+// tenantSchemas lists the schemas besides public that migrations must reach.
+func (d *DB) tenantSchemas(ctx context.Context) ([]string, error) {
+	if d.Isolation != IsolationSchema {
+		return nil, nil
+	}
+	tenants, err := d.Tenants(ctx)
+	if err != nil {
+		return nil, err
+	}
+	schemas := []string{}
+	for _, tenant := range tenants {
+		if tenant != DefaultTenant {
+			schemas = append(schemas, TenantSchema(tenant))
+		}
+	}
+	return schemas, nil
+}
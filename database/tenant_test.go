@@ -0,0 +1,202 @@
+//go:build unit
+
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIsolation(t *testing.T) {
+	tests := []struct {
+		value string
+		want  Isolation
+		ok    bool
+	}{
+		{"", IsolationNone, true},
+		{"rls", IsolationRLS, true},
+		{"schema", IsolationSchema, true},
+		{"database", IsolationNone, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseIsolation(tt.value)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.ok, err == nil)
+		})
+	}
+}
+
+func TestTenantNames(t *testing.T) {
+	assert.True(t, ValidTenant("acme_2"))
+	assert.False(t, ValidTenant(""))
+	assert.False(t, ValidTenant("Acme"))
+	assert.False(t, ValidTenant("_acme"))
+	assert.False(t, ValidTenant(`acme"; DROP TABLE expenses;--`))
+	assert.Equal(t, "public", TenantSchema(DefaultTenant))
+	assert.Equal(t, "tenant_acme", TenantSchema("acme"))
+}
+
+func TestInTenant(t *testing.T) {
+	ctx := WithTenant(context.Background(), "acme")
+
+	t.Run("No isolation uses the pool", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectExec("DELETE FROM expenses").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		d := &DB{Database: db}
+		err = d.InTenant(ctx, func(q Querier) error {
+			_, err := q.ExecContext(ctx, "DELETE FROM expenses")
+			return err
+		})
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	settingTests := []struct {
+		isolation Isolation
+		setting   string
+		value     string
+	}{
+		{IsolationRLS, "app.tenant_id", "acme"},
+		{IsolationSchema, "search_path", `"tenant_acme"`},
+	}
+	for _, tt := range settingTests {
+		t.Run(string(tt.isolation)+" sets tenant locally in a transaction", func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			mock.ExpectBegin()
+			mock.ExpectExec(regexp.QuoteMeta("SELECT set_config('" + tt.setting + "', $1, true)")).WithArgs(tt.value).WillReturnResult(driver.ResultNoRows)
+			mock.ExpectExec("DELETE FROM expenses").WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+
+			d := &DB{Database: db, Isolation: tt.isolation}
+			err = d.InTenant(ctx, func(q Querier) error {
+				_, err := q.ExecContext(ctx, "DELETE FROM expenses")
+				return err
+			})
+
+			assert.NoError(t, err)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+
+	t.Run("Failure rolls back", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectBegin()
+		mock.ExpectExec("set_config").WillReturnResult(driver.ResultNoRows)
+		mock.ExpectRollback()
+
+		d := &DB{Database: db, Isolation: IsolationRLS}
+		err = d.InTenant(ctx, func(q Querier) error {
+			return assert.AnError
+		})
+
+		assert.ErrorIs(t, err, assert.AnError)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid tenant is refused", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		d := &DB{Database: db, Isolation: IsolationSchema}
+		called := false
+		err = d.InTenant(WithTenant(context.Background(), "public; --"), func(q Querier) error {
+			called = true
+			return nil
+		})
+
+		assert.Error(t, err)
+		assert.False(t, called)
+	})
+}
+
+func TestProvisionTenant(t *testing.T) {
+	migrations := []Migration{
+		{Version: 1, Name: "create", Up: "CREATE TABLE t (id INT);", Down: "DROP TABLE t;"},
+	}
+
+	t.Run("Schema isolation migrates the tenant schema", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.tenants").WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec("INSERT INTO public.tenants").WithArgs("acme").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec(regexp.QuoteMeta(`CREATE SCHEMA IF NOT EXISTS "tenant_acme"`)).WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec(regexp.QuoteMeta(`SET search_path TO "tenant_acme"`)).WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(driver.ResultNoRows)
+		mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(sqlmock.NewRows([]string{"version"}))
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta(migrations[0].Up)).WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec("INSERT INTO schema_migrations").WithArgs(1, "create").WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+		mock.ExpectExec("RESET search_path").WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(driver.ResultNoRows)
+
+		d := &DB{Database: db, Isolation: IsolationSchema}
+		err = d.ProvisionTenant(context.Background(), "acme", migrations)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Row level security only registers the tenant", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.tenants").WillReturnResult(driver.ResultNoRows)
+		mock.ExpectExec("INSERT INTO public.tenants").WithArgs("acme").WillReturnResult(sqlmock.NewResult(0, 1))
+
+		d := &DB{Database: db, Isolation: IsolationRLS}
+		err = d.ProvisionTenant(context.Background(), "acme", migrations)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Invalid tenant name", func(t *testing.T) {
+		d := &DB{Isolation: IsolationSchema}
+		err := d.ProvisionTenant(context.Background(), "Acme", migrations)
+
+		assert.Error(t, err)
+	})
+}
+
+func TestForEachTenant(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.tenants").WillReturnResult(driver.ResultNoRows)
+	mock.ExpectQuery("SELECT id FROM public.tenants").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("acme").AddRow(DefaultTenant))
+
+	d := &DB{Database: db, Isolation: IsolationSchema}
+	visited := []string{}
+	err = d.ForEachTenant(context.Background(), func(ctx context.Context) error {
+		tenant, _ := TenantFrom(ctx)
+		visited = append(visited, tenant)
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{DefaultTenant, "acme"}, visited)
+}
@@ -0,0 +1,233 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	AccountCash       = "cash"
+	AccountCreditCard = "credit_card"
+	AccountBank       = "bank"
+	AccountEWallet    = "e_wallet"
+)
+
+// ErrAccountInUse is returned when deleting, or changing the currency of, an
+// account expenses still reference.
+var ErrAccountInUse = errors.New("account has expenses")
+
+// Account is a funding source expenses are paid from. Balance is set by the
+// store: the opening balance plus the income and transfers into the account
+// less the expenses and transfers out of it, leaving out deleted ones.
+type Account struct {
+	ID             int          `json:"id"`
+	OwnerID        string       `json:"owner_id"`
+	Name           string       `json:"name"`
+	Type           string       `json:"type"`
+	Currency       string       `json:"currency"`
+	OpeningBalance money.Amount `json:"opening_balance"`
+	Balance        money.Amount `json:"balance"`
+	CreatedAt      time.Time    `json:"created_at"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// AccountEntry is a transaction on a statement with the account balance
+// right after it.
+type AccountEntry struct {
+	Expense Expense      `json:"expense"`
+	Balance money.Amount `json:"balance"`
+}
+
+// AccountStatement lists the transactions into or out of an account in
+// Spent, in spent_at order. OpeningBalance is the balance before the first of them.
+type AccountStatement struct {
+	Account        Account        `json:"account"`
+	From           *time.Time     `json:"from"`
+	To             *time.Time     `json:"to"`
+	OpeningBalance money.Amount   `json:"opening_balance"`
+	ClosingBalance money.Amount   `json:"closing_balance"`
+	Entries        []AccountEntry `json:"entries"`
+}
+
+// AccountStore keeps accounts. Like expenses, callers only see their own
+// unless they are admins or approvers.
+type AccountStore interface {
+	InsertAccount(ctx context.Context, a *Account) error
+	SelectAccountByID(ctx context.Context, id int, a *Account) error
+	SelectAccounts(ctx context.Context) ([]Account, error)
+	// UpdateAccountByID and DeleteAccountByID return ErrAccountInUse when
+	// changing the currency of, or deleting, an account expenses reference.
+	UpdateAccountByID(ctx context.Context, id int, a *Account) error
+	DeleteAccountByID(ctx context.Context, id int) error
+	// SelectAccountStatement fills in st.Account and the statement of the
+	// transactions spent in spent.
+	SelectAccountStatement(ctx context.Context, id int, spent TimeRange, st *AccountStatement) error
+}
+
+// balanceChange is what transaction t changes the balance of the account by:
+// income and transfers in add to it, expenses and transfers out take from
+// it.
+func (t Expense) balanceChange(account int) money.Amount {
+	if t.Kind == KindIncome || sameID(t.ToAccountID, account) {
+		return t.Amount
+	}
+	return t.Amount.Neg()
+}
+
+func sameID(id *int, want int) bool {
+	return id != nil && *id == want
+}
+
+func isAccountType(t string) bool {
+	switch t {
+	case AccountCash, AccountCreditCard, AccountBank, AccountEWallet:
+		return true
+	}
+	return false
+}
+
+// checkAccount answers 400 unless the accounts ex is paid from, or
+// transferred to, are ones the caller can see in the same currency.
+func (h Handler) checkAccount(c echo.Context, ex Expense) (bool, error) {
+	if h.Accounts == nil {
+		return false, nil
+	}
+	for _, id := range []*int{ex.AccountID, ex.ToAccountID} {
+		if id == nil {
+			continue
+		}
+		a := Account{}
+		err := h.Accounts.SelectAccountByID(c.Request().Context(), *id, &a)
+		if err != nil && err.Error() == sql.ErrNoRows.Error() {
+			return true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid account"})
+		}
+		if err != nil {
+			return true, c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+		}
+		if a.Currency != ex.Currency {
+			return true, c.JSON(http.StatusBadRequest, Err{Msg: "Account currency does not match"})
+		}
+	}
+	return false, nil
+}
+
+func bindAccount(c echo.Context, a *Account) (bool, error) {
+	if err := c.Bind(a); err != nil {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	invalid := func(msg string) (bool, error) {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	a.Name = strings.TrimSpace(a.Name)
+	if a.Name == "" {
+		return invalid("Invalid request body")
+	}
+	a.Type = strings.ToLower(a.Type)
+	if !isAccountType(a.Type) {
+		return invalid("Invalid account type")
+	}
+	a.Currency = money.NormalizeCurrency(a.Currency)
+	if !money.IsCurrency(a.Currency) {
+		return invalid("Invalid currency")
+	}
+	if !a.OpeningBalance.FitsCurrency(a.Currency) {
+		return invalid("Invalid opening balance")
+	}
+	return false, nil
+}
+
+func returnAccount(err error, c echo.Context, status int, a Account) error {
+	if errors.Is(err, ErrAccountInUse) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Account has expenses"})
+	}
+	if err != nil && err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Account not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(status, a)
+}
+
+func (h Handler) CreateAccountHandler(c echo.Context) error {
+	a := Account{}
+	if ifErr, respErr := bindAccount(c, &a); ifErr {
+		return respErr
+	}
+	err := h.Accounts.InsertAccount(c.Request().Context(), &a)
+	return returnAccount(err, c, http.StatusCreated, a)
+}
+
+func (h Handler) GetAccountsHandler(c echo.Context) error {
+	accounts, err := h.Accounts.SelectAccounts(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, accounts)
+}
+
+func (h Handler) GetAccountByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	a := Account{}
+	err := h.Accounts.SelectAccountByID(c.Request().Context(), intVar, &a)
+	return returnAccount(err, c, http.StatusOK, a)
+}
+
+// UpdateAccountByIDHandler renames an account or changes its type or
+// opening balance. Its currency is fixed once expenses are paid from it.
+func (h Handler) UpdateAccountByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	a := Account{}
+	if ifErr, respErr := bindAccount(c, &a); ifErr {
+		return respErr
+	}
+	err := h.Accounts.UpdateAccountByID(c.Request().Context(), intVar, &a)
+	return returnAccount(err, c, http.StatusOK, a)
+}
+
+func (h Handler) DeleteAccountByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	err := h.Accounts.DeleteAccountByID(c.Request().Context(), intVar)
+	if err != nil {
+		return returnAccount(err, c, 0, Account{})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetAccountStatementHandler lists the transactions of an account with the
+// running balance, optionally only those spent in [from, to).
+func (h Handler) GetAccountStatementHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	spent := TimeRange{}
+	var ok bool
+	if spent.From, ok = parseDateParam(c.QueryParam("from")); !ok {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid from"})
+	}
+	if spent.To, ok = parseDateParam(c.QueryParam("to")); !ok {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid to"})
+	}
+	st := AccountStatement{}
+	if err := h.Accounts.SelectAccountStatement(c.Request().Context(), intVar, spent, &st); err != nil {
+		return returnAccount(err, c, 0, Account{})
+	}
+	return c.JSON(http.StatusOK, st)
+}
@@ -0,0 +1,221 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccounts(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{Storage: store, Accounts: store}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	bob := auth.Principal{Subject: "bob", Roles: []string{auth.RoleEditor}}
+	call := func(p auth.Principal, method, target, body string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, handler(c))
+		return rec
+	}
+	spend := func(p auth.Principal, amount, currency, spentAt string, accountID int) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{
+			"title": "taxi", "amount": amount, "note": "n", "tags": []string{"travel"}, "currency": currency,
+			"spent_at": spentAt, "account_id": accountID,
+		})
+		return call(p, http.MethodPost, "/expenses", string(body), h.CreateExpenseHandler, "")
+	}
+
+	a := Account{}
+	t.Run("Create Account Return HTTP Status Created", func(t *testing.T) {
+		rec := call(alice, http.MethodPost, "/accounts", `{"name":" Wallet ","type":"Cash","currency":"thb","opening_balance":"1000"}`, h.CreateAccountHandler, "")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		json.Unmarshal(rec.Body.Bytes(), &a)
+		assert.Equal(t, "alice", a.OwnerID)
+		assert.Equal(t, "Wallet", a.Name)
+		assert.Equal(t, AccountCash, a.Type)
+		assert.Equal(t, "THB", a.Currency)
+		assert.Equal(t, money.FromInt(1000), a.Balance)
+	})
+	id := strconv.Itoa(a.ID)
+
+	t.Run("Invalid Account Return HTTP Status Bad Request", func(t *testing.T) {
+		for body, want := range map[string]string{
+			`{"type":"cash","currency":"THB"}`:                                     "Invalid request body",
+			`{"name":"x","type":"savings","currency":"THB"}`:                       "Invalid account type",
+			`{"name":"x","type":"bank","currency":"XYZ"}`:                          "Invalid currency",
+			`{"name":"x","type":"bank","currency":"JPY","opening_balance":"10.5"}`: "Invalid opening balance",
+		} {
+			rec := call(alice, http.MethodPost, "/accounts", body, h.CreateAccountHandler, "")
+			got := Err{}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+			assert.Equal(t, want, got.Msg, body)
+		}
+	})
+
+	t.Run("Expense paid from an account it can't use Return HTTP Status Bad Request", func(t *testing.T) {
+		for _, tt := range []struct {
+			p        auth.Principal
+			currency string
+			want     string
+		}{
+			{alice, "USD", "Account currency does not match"},
+			{bob, "THB", "Invalid account"},
+		} {
+			rec := spend(tt.p, "10", tt.currency, "2023-01-01T00:00:00Z", a.ID)
+			got := Err{}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, tt.want, got.Msg)
+		}
+	})
+
+	t.Run("Account Statement has running balances", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, spend(alice, "100", "THB", "2023-01-05T00:00:00Z", a.ID).Code)
+		assert.Equal(t, http.StatusCreated, spend(alice, "50.25", "THB", "2023-02-10T00:00:00Z", a.ID).Code)
+		assert.Equal(t, http.StatusCreated, spend(alice, "20", "THB", "2023-02-01T00:00:00Z", a.ID).Code)
+
+		rec := call(alice, http.MethodGet, "/accounts/"+id+"/statement?from=2023-02-01", "", h.GetAccountStatementHandler, id)
+		st := AccountStatement{}
+		json.Unmarshal(rec.Body.Bytes(), &st)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, money.MustParse("829.75"), st.Account.Balance)
+		assert.Equal(t, money.FromInt(900), st.OpeningBalance)
+		assert.Equal(t, money.MustParse("829.75"), st.ClosingBalance)
+		if assert.Equal(t, 2, len(st.Entries)) {
+			assert.Equal(t, money.FromInt(20), st.Entries[0].Expense.Amount)
+			assert.Equal(t, money.FromInt(880), st.Entries[0].Balance)
+			assert.Equal(t, money.MustParse("829.75"), st.Entries[1].Balance)
+		}
+
+		rec = call(alice, http.MethodGet, "/expenses?account_id="+id, "", h.GetAllExpensesHandler, "")
+		expenses := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &expenses)
+		assert.Equal(t, 3, len(expenses))
+	})
+
+	t.Run("Rejected expenses leave the balance and statement", func(t *testing.T) {
+		rec := spend(alice, "300", "THB", "2023-02-15T00:00:00Z", a.ID)
+		rejected := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &rejected)
+		for _, action := range []string{"submit", "reject"} {
+			tr, _ := NewTransition(action, "no receipt")
+			assert.NoError(t, store.TransitionExpenseByID(context.Background(), rejected.ID, tr, &Expense{}))
+		}
+
+		rec = call(alice, http.MethodGet, "/accounts/"+id+"/statement?from=2023-02-01", "", h.GetAccountStatementHandler, id)
+		st := AccountStatement{}
+		json.Unmarshal(rec.Body.Bytes(), &st)
+		assert.Equal(t, money.MustParse("829.75"), st.Account.Balance)
+		assert.Equal(t, money.MustParse("829.75"), st.ClosingBalance)
+		assert.Equal(t, 2, len(st.Entries))
+	})
+
+	t.Run("Accounts of other owners Return HTTP Status Not Found", func(t *testing.T) {
+		rec := call(bob, http.MethodGet, "/accounts/"+id, "", h.GetAccountByIDHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = call(bob, http.MethodGet, "/accounts", "", h.GetAccountsHandler, "")
+		assert.JSONEq(t, "[]", rec.Body.String())
+	})
+
+	t.Run("Account with expenses keeps its currency and can't be deleted", func(t *testing.T) {
+		rec := call(alice, http.MethodPut, "/accounts/"+id, `{"name":"Wallet","type":"cash","currency":"USD","opening_balance":"1000"}`, h.UpdateAccountByIDHandler, id)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		rec = call(alice, http.MethodPut, "/accounts/"+id, `{"name":"Pocket","type":"cash","currency":"THB","opening_balance":"2000"}`, h.UpdateAccountByIDHandler, id)
+		got := Account{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "Pocket", got.Name)
+		assert.Equal(t, money.MustParse("1829.75"), got.Balance)
+		rec = call(alice, http.MethodDelete, "/accounts/"+id, "", h.DeleteAccountByIDHandler, id)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Delete unused Account Return HTTP Status No Content", func(t *testing.T) {
+		rec := call(alice, http.MethodPost, "/accounts", `{"name":"Card","type":"credit_card","currency":"THB"}`, h.CreateAccountHandler, "")
+		card := Account{}
+		json.Unmarshal(rec.Body.Bytes(), &card)
+		cardID := strconv.Itoa(card.ID)
+		rec = call(alice, http.MethodDelete, "/accounts/"+cardID, "", h.DeleteAccountByIDHandler, cardID)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		rec = call(alice, http.MethodDelete, "/accounts/"+cardID, "", h.DeleteAccountByIDHandler, cardID)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestPostgresAccountStatement(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	from := time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM accounts WHERE id=\\$1").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "name", "type", "currency", "opening_balance", "balance", "created_at", "updated_at"}).
+			AddRow(2, "default", "Wallet", AccountCash, "THB", "1000", "830", testTime, testTime))
+	delta := "SUM\\(CASE WHEN kind='income' OR to_account_id=\\$%d THEN amount ELSE -amount END\\)"
+	mock.ExpectQuery("SELECT COALESCE\\("+fmt.Sprintf(delta, 1)+",0\\) FROM expenses WHERE \\(account_id=\\$1 OR to_account_id=\\$1\\) AND deleted_at IS NULL AND status <> 'rejected' AND spent_at < \\$2").
+		WithArgs(2, from).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("-100"))
+	mock.ExpectQuery("SELECT (.+), "+fmt.Sprintf(delta, 4)+" OVER \\(ORDER BY spent_at, id\\) FROM expenses"+
+		" WHERE deleted_at IS NULL AND kind = ANY\\(\\$1\\) AND \\(account_id = \\$2 OR to_account_id = \\$2\\) AND spent_at >= \\$3 AND status <> 'rejected' ORDER BY spent_at, id").
+		WithArgs(pq.Array(transactionKinds), 2, from, 2).
+		WillReturnRows(sqlmock.NewRows(append(strings.Split(transactionColumns, ","), "sum")).
+			AddRow(4, "default", "taxi", "20", "n", "{travel}", "THB", testTime, testTime, testTime, "draft", "", 2, KindExpense, nil, "-20").
+			AddRow(5, "default", "salary", "500", "n", "{work}", "THB", testTime, testTime, testTime, "draft", "", 2, KindIncome, nil, "480").
+			AddRow(6, "default", "savings", "550", "n", "{bank}", "THB", testTime, testTime, testTime, "draft", "", 2, KindTransfer, 3, "-70"))
+
+	st := AccountStatement{}
+	err = s.SelectAccountStatement(context.Background(), 2, TimeRange{From: &from}, &st)
+	if assert.NoError(t, err) {
+		assert.Equal(t, money.FromInt(900), st.OpeningBalance)
+		assert.Equal(t, money.FromInt(830), st.ClosingBalance)
+		if assert.Equal(t, 3, len(st.Entries)) {
+			assert.Equal(t, money.FromInt(880), st.Entries[0].Balance)
+			assert.Equal(t, 2, *st.Entries[0].Expense.AccountID)
+			assert.Equal(t, money.FromInt(1380), st.Entries[1].Balance)
+			assert.Equal(t, KindTransfer, st.Entries[2].Expense.Kind)
+			assert.Equal(t, 3, *st.Entries[2].Expense.ToAccountID)
+		}
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresDeleteAccountInUse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+
+	mock.ExpectExec("DELETE FROM accounts WHERE id=\\$1 AND NOT EXISTS \\(SELECT 1 FROM expenses WHERE \\(account_id=\\$1 OR to_account_id=\\$1\\)\\)").
+		WithArgs(2).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id FROM accounts WHERE id=\\$1").WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+
+	assert.ErrorIs(t, s.DeleteAccountByID(context.Background(), 2), ErrAccountInUse)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,274 @@
+package expense
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Temwalker/assessment/blob"
+	"github.com/Temwalker/assessment/database"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultMaxAttachmentSize bounds an uploaded receipt when
+// Handler.MaxAttachmentSize is zero.
+const DefaultMaxAttachmentSize = 10 << 20
+
+// attachmentTypes are the sniffed content types a receipt may have.
+var attachmentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// Attachment is the metadata kept for a blob attached to an expense.
+type Attachment struct {
+	ID          int       `json:"id"`
+	ExpenseID   int       `json:"expense_id"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	StorageKey  string    `json:"-"`
+}
+
+// AttachmentStore keeps attachment metadata. Every method sees only
+// attachments of expenses the caller may see, and inserts and deletes fail
+// with ErrExpenseLocked once the expense is approved.
+type AttachmentStore interface {
+	InsertAttachment(ctx context.Context, a *Attachment) error
+	SelectAttachments(ctx context.Context, expenseID int) ([]Attachment, error)
+	SelectAttachmentByID(ctx context.Context, expenseID, id int, a *Attachment) error
+	DeleteAttachmentByID(ctx context.Context, expenseID, id int, a *Attachment) error
+}
+
+// sniffContentType ignores the type the client declared and trusts the
+// content alone.
+func sniffContentType(data []byte) (string, bool) {
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	return contentType, attachmentTypes[contentType]
+}
+
+// attachmentKey is unique per upload, so a blob is never overwritten.
+func attachmentKey(ctx context.Context, expenseID int) (string, error) {
+	tenant, ok := database.TenantFrom(ctx)
+	if !ok {
+		tenant = database.DefaultTenant
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return tenant + "/" + strconv.Itoa(expenseID) + "/" + hex.EncodeToString(random), nil
+}
+
+// cleanFilename keeps only the base name of what the client sent.
+func cleanFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		return "attachment"
+	}
+	return name
+}
+
+func getAttachmentIDParam(c echo.Context) (int, bool, error) {
+	id, err := strconv.Atoi(c.Param("attachment_id"))
+	if err != nil {
+		return 0, true, c.JSON(http.StatusBadRequest, Err{Msg: "Attachment ID is not numeric"})
+	}
+	return id, false, nil
+}
+
+func returnAttachmentError(err error, c echo.Context) error {
+	if errors.Is(err, ErrExpenseLocked) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	if err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Attachment not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+}
+
+// visibleExpense answers 404 unless the caller may see the expense.
+func (h Handler) visibleExpense(c echo.Context, id int, ex *Expense) (bool, error) {
+	err := h.Storage.SelectExpenseByID(c.Request().Context(), id, ex)
+	if err != nil && err.Error() == sql.ErrNoRows.Error() {
+		return true, c.JSON(http.StatusNotFound, Err{Msg: "Expense not found"})
+	}
+	if err != nil {
+		return true, c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return false, nil
+}
+
+func (h Handler) maxAttachmentSize() int64 {
+	if h.MaxAttachmentSize > 0 {
+		return h.MaxAttachmentSize
+	}
+	return DefaultMaxAttachmentSize
+}
+
+// readUpload reads the "file" form field, refusing files over max bytes.
+func readUpload(c echo.Context, max int64) (string, []byte, bool, error) {
+	tooLarge := func() error {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Msg: "Attachment is too large"})
+	}
+	// leave room for the multipart framing around the file
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, max+1<<20)
+	fh, err := c.FormFile("file")
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return "", nil, true, tooLarge()
+		}
+		return "", nil, true, c.JSON(http.StatusBadRequest, Err{Msg: "Missing file"})
+	}
+	if fh.Size > max {
+		return "", nil, true, tooLarge()
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return "", nil, true, c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, max+1))
+	if err != nil {
+		return "", nil, true, c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	if int64(len(data)) > max {
+		return "", nil, true, tooLarge()
+	}
+	return cleanFilename(fh.Filename), data, false, nil
+}
+
+func (h Handler) CreateAttachmentHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ex := Expense{}
+	if ifErr, respErr := h.visibleExpense(c, intVar, &ex); ifErr {
+		return respErr
+	}
+	if isLocked(ex.Status) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	filename, data, ifErr, respErr := readUpload(c, h.maxAttachmentSize())
+	if ifErr {
+		return respErr
+	}
+	contentType, ok := sniffContentType(data)
+	if !ok {
+		return c.JSON(http.StatusUnsupportedMediaType, Err{Msg: "Unsupported attachment type"})
+	}
+
+	ctx := c.Request().Context()
+	sum := sha256.Sum256(data)
+	a := Attachment{
+		ExpenseID:   intVar,
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+	key, err := attachmentKey(ctx, intVar)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	a.StorageKey = key
+	if err := h.Blobs.Put(ctx, key, data, contentType); err != nil {
+		c.Logger().Error("can't store attachment : ", err)
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	if err := h.Attachments.InsertAttachment(ctx, &a); err != nil {
+		h.Blobs.Delete(context.Background(), key)
+		return returnAttachmentError(err, c)
+	}
+	return c.JSON(http.StatusCreated, a)
+}
+
+func (h Handler) GetAttachmentsHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ex := Expense{}
+	if ifErr, respErr := h.visibleExpense(c, intVar, &ex); ifErr {
+		return respErr
+	}
+	attachments, err := h.Attachments.SelectAttachments(c.Request().Context(), intVar)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, attachments)
+}
+
+func (h Handler) DownloadAttachmentHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	attachmentID, ifErr, respErr := getAttachmentIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ctx := c.Request().Context()
+	a := Attachment{}
+	if err := h.Attachments.SelectAttachmentByID(ctx, intVar, attachmentID, &a); err != nil {
+		return returnAttachmentError(err, c)
+	}
+	r, err := h.Blobs.Get(ctx, a.StorageKey)
+	if errors.Is(err, blob.ErrNotFound) {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Attachment not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	defer r.Close()
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename}))
+	header.Set(echo.HeaderContentLength, strconv.FormatInt(a.Size, 10))
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set("ETag", `"`+a.SHA256+`"`)
+	return c.Stream(http.StatusOK, a.ContentType, r)
+}
+
+func (h Handler) DeleteAttachmentHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	attachmentID, ifErr, respErr := getAttachmentIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ctx := c.Request().Context()
+	a := Attachment{}
+	if err := h.Attachments.DeleteAttachmentByID(ctx, intVar, attachmentID, &a); err != nil {
+		return returnAttachmentError(err, c)
+	}
+	// the metadata is gone, so a blob left behind is only wasted space
+	if err := h.Blobs.Delete(ctx, a.StorageKey); err != nil {
+		c.Logger().Error("can't delete attachment blob : ", err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,236 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/blob"
+	"github.com/Temwalker/assessment/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+var pngReceipt = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+
+func uploadBody(t *testing.T, filename string, data []byte) (string, *bytes.Buffer) {
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	part, err := w.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	w.Close()
+	return w.FormDataContentType(), body
+}
+
+func TestAttachments(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{
+		Storage:           store,
+		Attachments:       store,
+		Blobs:             blob.NewLocalStore(t.TempDir()),
+		MaxAttachmentSize: 1024,
+	}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	bob := auth.Principal{Subject: "bob", Roles: []string{auth.RoleEditor}}
+	call := func(p auth.Principal, method, contentType string, body *bytes.Buffer, handler echo.HandlerFunc, ids ...string) *httptest.ResponseRecorder {
+		if body == nil {
+			body = &bytes.Buffer{}
+		}
+		req := httptest.NewRequest(method, "/expenses", body)
+		req.Header.Add(echo.HeaderContentType, contentType)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id/attachments/:attachment_id")
+		c.SetParamNames("id", "attachment_id")
+		c.SetParamValues(append(ids, "")[:2]...)
+		assert.NoError(t, handler(c))
+		return rec
+	}
+	upload := func(p auth.Principal, expenseID, filename string, data []byte) *httptest.ResponseRecorder {
+		contentType, body := uploadBody(t, filename, data)
+		return call(p, http.MethodPost, contentType, body, h.CreateAttachmentHandler, expenseID)
+	}
+
+	ex := Expense{Title: "taxi", Note: "client visit", Tags: []string{"travel"}, Currency: "THB"}
+	ctx := auth.WithPrincipal(httptest.NewRequest(http.MethodGet, "/", nil).Context(), alice)
+	store.InsertExpense(ctx, &ex)
+	id := strconv.Itoa(ex.ID)
+
+	created := Attachment{}
+	t.Run("Upload sniffs the type and stores the checksum", func(t *testing.T) {
+		rec := upload(alice, id, `C:\receipts\taxi.png`, pngReceipt)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		sum := sha256.Sum256(pngReceipt)
+		assert.Equal(t, "taxi.png", created.Filename)
+		assert.Equal(t, "image/png", created.ContentType)
+		assert.Equal(t, int64(len(pngReceipt)), created.Size)
+		assert.Equal(t, hex.EncodeToString(sum[:]), created.SHA256)
+		assert.NotContains(t, rec.Body.String(), "storage_key")
+	})
+
+	t.Run("List attachments", func(t *testing.T) {
+		rec := call(alice, http.MethodGet, "", nil, h.GetAttachmentsHandler, id)
+		got := []Attachment{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Equal(t, 1, len(got)) {
+			assert.Equal(t, created.ID, got[0].ID)
+		}
+	})
+
+	t.Run("Download returns the stored bytes", func(t *testing.T) {
+		rec := call(alice, http.MethodGet, "", nil, h.DownloadAttachmentHandler, id, strconv.Itoa(created.ID))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "image/png", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename=taxi.png`, rec.Header().Get(echo.HeaderContentDisposition))
+		assert.Equal(t, `"`+created.SHA256+`"`, rec.Header().Get("ETag"))
+		assert.Equal(t, pngReceipt, rec.Body.Bytes())
+	})
+
+	t.Run("Another user's expense Return HTTP Status Not Found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, upload(bob, id, "taxi.png", pngReceipt).Code)
+		assert.Equal(t, http.StatusNotFound, call(bob, http.MethodGet, "", nil, h.GetAttachmentsHandler, id).Code)
+		assert.Equal(t, http.StatusNotFound, call(bob, http.MethodGet, "", nil, h.DownloadAttachmentHandler, id, strconv.Itoa(created.ID)).Code)
+		assert.Equal(t, http.StatusNotFound, call(bob, http.MethodDelete, "", nil, h.DeleteAttachmentHandler, id, strconv.Itoa(created.ID)).Code)
+	})
+
+	t.Run("Unsupported type Return HTTP Status Unsupported Media Type", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnsupportedMediaType, upload(alice, id, "receipt.png", []byte("<html><script>alert(1)</script>")).Code)
+	})
+
+	t.Run("Oversized file Return HTTP Status Request Entity Too Large", func(t *testing.T) {
+		big := append(append([]byte{}, pngReceipt...), bytes.Repeat([]byte{0}, 1024)...)
+		assert.Equal(t, http.StatusRequestEntityTooLarge, upload(alice, id, "big.png", big).Code)
+	})
+
+	t.Run("Missing file Return HTTP Status Bad Request", func(t *testing.T) {
+		rec := call(alice, http.MethodPost, echo.MIMEApplicationJSON, bytes.NewBufferString(`{}`), h.CreateAttachmentHandler, id)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Delete removes metadata and blob", func(t *testing.T) {
+		rec := upload(alice, id, "second.pdf", []byte("%PDF-1.4\n%receipt"))
+		second := Attachment{}
+		json.Unmarshal(rec.Body.Bytes(), &second)
+		assert.Equal(t, "application/pdf", second.ContentType)
+
+		rec = call(alice, http.MethodDelete, "", nil, h.DeleteAttachmentHandler, id, strconv.Itoa(second.ID))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		a := Attachment{}
+		assert.Error(t, store.SelectAttachmentByID(ctx, ex.ID, second.ID, &a))
+		rec = call(alice, http.MethodDelete, "", nil, h.DeleteAttachmentHandler, id, strconv.Itoa(second.ID))
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Approved expense attachments can not change", func(t *testing.T) {
+		for _, action := range []string{"submit", "approve"} {
+			tr, _ := NewTransition(action, "")
+			store.TransitionExpenseByID(ctx, ex.ID, tr, &Expense{})
+		}
+		assert.Equal(t, http.StatusConflict, upload(alice, id, "late.png", pngReceipt).Code)
+		rec := call(alice, http.MethodDelete, "", nil, h.DeleteAttachmentHandler, id, strconv.Itoa(created.ID))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		rec = call(alice, http.MethodGet, "", nil, h.DownloadAttachmentHandler, id, strconv.Itoa(created.ID))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Purge deletes the files of purged expenses", func(t *testing.T) {
+		draft := Expense{Title: "hotel", Note: "client visit", Tags: []string{"travel"}, Currency: "THB"}
+		store.InsertExpense(ctx, &draft)
+		rec := upload(alice, strconv.Itoa(draft.ID), "hotel.png", pngReceipt)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		a := Attachment{}
+		json.Unmarshal(rec.Body.Bytes(), &a)
+		assert.NoError(t, store.SelectAttachmentByID(ctx, draft.ID, a.ID, &a))
+		assert.NoError(t, store.DeleteExpenseByID(ctx, draft.ID))
+
+		purged, err := NewPurger(store, h.Blobs, time.Hour).PurgeOnce(context.Background(), time.Now().Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+		_, err = h.Blobs.Get(context.Background(), a.StorageKey)
+		assert.ErrorIs(t, err, blob.ErrNotFound)
+		kept := Attachment{}
+		assert.NoError(t, store.SelectAttachmentByID(ctx, ex.ID, created.ID, &kept))
+		r, err := h.Blobs.Get(context.Background(), kept.StorageKey)
+		if assert.NoError(t, err) {
+			r.Close()
+		}
+	})
+}
+
+func TestCleanFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"receipt.jpg", "receipt.jpg"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\me\scan.pdf`, "scan.pdf"},
+		{"bad\"name\r\n.png", "badname.png"},
+		{"", "attachment"},
+		{"/", "attachment"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, cleanFilename(tt.name))
+		})
+	}
+}
+
+func TestPostgresAttachments(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	ctx := auth.WithPrincipal(httptest.NewRequest(http.MethodGet, "/", nil).Context(), auth.Principal{Subject: "alice"})
+
+	mock.ExpectQuery("INSERT INTO expense_attachments (.+) SELECT id,\\$2,\\$3,\\$4,\\$5,\\$6 FROM expenses WHERE id=\\$1 AND deleted_at IS NULL AND status NOT IN \\('approved','reimbursed'\\) AND owner_id=\\$7").
+		WithArgs(1, "taxi.png", "image/png", int64(9), "abc", "default/1/key", "alice").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery("SELECT status FROM expenses WHERE id=\\$1 AND deleted_at IS NULL AND owner_id=\\$2").
+		WithArgs(1, "alice").WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(StatusApproved))
+	a := Attachment{ExpenseID: 1, Filename: "taxi.png", ContentType: "image/png", Size: 9, SHA256: "abc", StorageKey: "default/1/key"}
+	assert.ErrorIs(t, s.InsertAttachment(ctx, &a), ErrExpenseLocked)
+
+	mock.ExpectQuery("SELECT (.+) FROM expense_attachments WHERE expense_id=\\$1 AND expense_id IN \\(SELECT id FROM expenses WHERE id=\\$1 AND deleted_at IS NULL AND owner_id=\\$2 AND kind='expense'\\) ORDER BY id").
+		WithArgs(1, "alice").
+		WillReturnRows(sqlmock.NewRows(strings.Split(attachmentColumns, ",")).AddRow(3, 1, "taxi.png", "image/png", 9, "abc", "default/1/key", testTime))
+	got, err := s.SelectAttachments(ctx, 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []Attachment{{ID: 3, ExpenseID: 1, Filename: "taxi.png", ContentType: "image/png", Size: 9, SHA256: "abc", StorageKey: "default/1/key", CreatedAt: testTime}}, got)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT a.storage_key FROM expense_attachments a JOIN expenses e ON e.id=a.expense_id\\s+WHERE e.deleted_at IS NOT NULL AND e.deleted_at < \\$1").
+		WithArgs(testTime).WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow("default/1/key"))
+	mock.ExpectExec("DELETE FROM expenses WHERE deleted_at IS NOT NULL AND deleted_at < \\$1").
+		WithArgs(testTime).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	purged, keys, err := s.PurgeDeletedExpenses(database.WithTenant(ctx, "default"), testTime)
+	if assert.NoError(t, err) {
+		assert.Equal(t, int64(1), purged)
+		assert.Equal(t, []string{"default/1/key"}, keys)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
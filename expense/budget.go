@@ -0,0 +1,327 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	PeriodWeek    = "week"
+	PeriodMonth   = "month"
+	PeriodQuarter = "quarter"
+	PeriodYear    = "year"
+)
+
+// DefaultBudgetThreshold alerts once a budget is fully spent.
+const DefaultBudgetThreshold = 100
+
+// Budget limits what its owner spends in Currency on expenses tagged with
+// any of Tags, per calendar Period in UTC. Threshold is the percentage of
+// Limit at which an alert is raised.
+type Budget struct {
+	ID        int          `json:"id"`
+	OwnerID   string       `json:"owner_id"`
+	Name      string       `json:"name"`
+	Tags      []string     `json:"tags"`
+	Period    string       `json:"period"`
+	Limit     money.Amount `json:"limit"`
+	Currency  string       `json:"currency"`
+	Threshold int          `json:"threshold"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+type BudgetStatus struct {
+	BudgetID    int          `json:"budget_id"`
+	Period      string       `json:"period"`
+	From        time.Time    `json:"from"`
+	To          time.Time    `json:"to"`
+	Limit       money.Amount `json:"limit"`
+	Spent       money.Amount `json:"spent"`
+	Remaining   money.Amount `json:"remaining"`
+	Count       int          `json:"count"`
+	UsedPercent float64      `json:"used_percent"`
+	Alert       bool         `json:"alert"`
+	Over        bool         `json:"over"`
+}
+
+// BudgetStore keeps budgets. Like expenses, callers only see their own
+// unless they are admins or approvers.
+type BudgetStore interface {
+	InsertBudget(ctx context.Context, b *Budget) error
+	SelectBudgetByID(ctx context.Context, id int, b *Budget) error
+	SelectBudgets(ctx context.Context) ([]Budget, error)
+	// UpdateBudgetByID also forgets the period b last alerted for.
+	UpdateBudgetByID(ctx context.Context, id int, b *Budget) error
+	DeleteBudgetByID(ctx context.Context, id int) error
+	// SpentInBudget totals the expenses of b's owner that b counts and were
+	// spent in [from, to).
+	SpentInBudget(ctx context.Context, b Budget, from, to time.Time) (money.Amount, int, error)
+	// MarkBudgetAlerted records that budget id alerted for period, returning
+	// false when it already had, so an alert is raised once per period even
+	// with several replicas.
+	MarkBudgetAlerted(ctx context.Context, id int, period string) (bool, error)
+}
+
+// BudgetAlert is emitted when an expense pushes a budget past its
+// threshold.
+type BudgetAlert struct {
+	Budget    Budget       `json:"budget"`
+	Status    BudgetStatus `json:"status"`
+	ExpenseID int          `json:"expense_id"`
+}
+
+type AlertSink interface {
+	BudgetAlert(ctx context.Context, a BudgetAlert) error
+}
+
+// LogAlerts writes every alert to the standard logger as JSON.
+type LogAlerts struct{}
+
+func (LogAlerts) BudgetAlert(ctx context.Context, a BudgetAlert) error {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	log.Println("budget alert :", string(data))
+	return nil
+}
+
+// periodRange is the UTC calendar period containing t and its name, such as
+// 2023-01 for a month or 2023-Q1 for a quarter. Weeks start on Monday and
+// are named by that date.
+func periodRange(period string, t time.Time) (time.Time, time.Time, string) {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case PeriodWeek:
+		from := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return from, from.AddDate(0, 0, 7), from.Format("2006-01-02")
+	case PeriodQuarter:
+		q := (int(t.Month()) - 1) / 3
+		from := time.Date(t.Year(), time.Month(q*3+1), 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 3, 0), t.Format("2006") + "-Q" + strconv.Itoa(q+1)
+	case PeriodYear:
+		from := time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(1, 0, 0), t.Format("2006")
+	}
+	from := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return from, from.AddDate(0, 1, 0), t.Format("2006-01")
+}
+
+func isPeriod(period string) bool {
+	switch period {
+	case PeriodWeek, PeriodMonth, PeriodQuarter, PeriodYear:
+		return true
+	}
+	return false
+}
+
+// counts reports whether b counts ex towards its spending. Rejected expenses
+// are never paid, so they do not.
+func (b Budget) counts(ex Expense) bool {
+	if ex.OwnerID != b.OwnerID || ex.Currency != b.Currency || ex.Status == StatusRejected {
+		return false
+	}
+	for _, tag := range b.Tags {
+		if hasTag(ex.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func newBudgetStatus(b Budget, from, to time.Time, period string, spent money.Amount, count int) BudgetStatus {
+	s := BudgetStatus{
+		BudgetID:  b.ID,
+		Period:    period,
+		From:      from,
+		To:        to,
+		Limit:     b.Limit,
+		Spent:     spent,
+		Remaining: b.Limit.Sub(spent),
+		Count:     count,
+		Over:      spent.Cmp(b.Limit) > 0,
+	}
+	used := new(big.Rat).SetFrac(big.NewInt(spent.Units()), big.NewInt(b.Limit.Units()))
+	used.Mul(used, big.NewRat(100, 1))
+	f, _ := used.Float64()
+	s.UsedPercent = math.Round(f*100) / 100
+	s.Alert = used.Cmp(big.NewRat(int64(b.Threshold), 1)) >= 0
+	return s
+}
+
+func (h Handler) budgetStatus(ctx context.Context, b Budget, at time.Time) (BudgetStatus, error) {
+	from, to, period := periodRange(b.Period, at)
+	spent, count, err := h.Budgets.SpentInBudget(ctx, b, from, to)
+	if err != nil {
+		return BudgetStatus{}, err
+	}
+	return newBudgetStatus(b, from, to, period, spent, count), nil
+}
+
+// checkBudgets raises an alert for every budget ex has just pushed past its
+// threshold. The expense is saved already, so failures are only logged.
+func (h Handler) checkBudgets(c echo.Context, ex Expense) {
+	if h.Budgets == nil || h.Alerts == nil {
+		return
+	}
+	ctx := c.Request().Context()
+	budgets, err := h.Budgets.SelectBudgets(ctx)
+	if err != nil {
+		c.Logger().Error("can't check budgets : ", err)
+		return
+	}
+	for _, b := range budgets {
+		if !b.counts(ex) {
+			continue
+		}
+		status, err := h.budgetStatus(ctx, b, ex.SpentAt)
+		if err != nil {
+			c.Logger().Error("can't check budget ", b.ID, " : ", err)
+			continue
+		}
+		if !status.Alert {
+			continue
+		}
+		first, err := h.Budgets.MarkBudgetAlerted(ctx, b.ID, status.Period)
+		if err != nil || !first {
+			continue
+		}
+		if err := h.Alerts.BudgetAlert(ctx, BudgetAlert{Budget: b, Status: status, ExpenseID: ex.ID}); err != nil {
+			c.Logger().Error("can't emit budget alert : ", err)
+		}
+	}
+}
+
+func bindBudget(c echo.Context, b *Budget) (bool, error) {
+	if err := c.Bind(b); err != nil {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	invalid := func(msg string) (bool, error) {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	b.Name = strings.TrimSpace(b.Name)
+	b.Tags = parseTags(b.Tags)
+	if b.Name == "" || len(b.Tags) == 0 {
+		return invalid("Invalid request body")
+	}
+	if b.Period == "" {
+		b.Period = PeriodMonth
+	}
+	if !isPeriod(b.Period) {
+		return invalid("Invalid period")
+	}
+	b.Currency = money.NormalizeCurrency(b.Currency)
+	if !money.IsCurrency(b.Currency) {
+		return invalid("Invalid currency")
+	}
+	if b.Limit.Sign() <= 0 || !b.Limit.FitsCurrency(b.Currency) {
+		return invalid("Invalid limit")
+	}
+	if b.Threshold == 0 {
+		b.Threshold = DefaultBudgetThreshold
+	}
+	if b.Threshold < 1 || b.Threshold > 1000 {
+		return invalid("Invalid threshold")
+	}
+	return false, nil
+}
+
+func returnBudget(err error, c echo.Context, status int, b Budget) error {
+	if err != nil && err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Budget not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(status, b)
+}
+
+func (h Handler) CreateBudgetHandler(c echo.Context) error {
+	b := Budget{}
+	if ifErr, respErr := bindBudget(c, &b); ifErr {
+		return respErr
+	}
+	err := h.Budgets.InsertBudget(c.Request().Context(), &b)
+	return returnBudget(err, c, http.StatusCreated, b)
+}
+
+func (h Handler) GetBudgetsHandler(c echo.Context) error {
+	budgets, err := h.Budgets.SelectBudgets(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, budgets)
+}
+
+func (h Handler) GetBudgetByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	b := Budget{}
+	err := h.Budgets.SelectBudgetByID(c.Request().Context(), intVar, &b)
+	return returnBudget(err, c, http.StatusOK, b)
+}
+
+func (h Handler) UpdateBudgetByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	b := Budget{}
+	if ifErr, respErr := bindBudget(c, &b); ifErr {
+		return respErr
+	}
+	err := h.Budgets.UpdateBudgetByID(c.Request().Context(), intVar, &b)
+	return returnBudget(err, c, http.StatusOK, b)
+}
+
+func (h Handler) DeleteBudgetByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	err := h.Budgets.DeleteBudgetByID(c.Request().Context(), intVar)
+	if err != nil {
+		return returnBudget(err, c, 0, Budget{})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetBudgetStatusHandler reports the budget's current period, or the period
+// containing ?at=.
+func (h Handler) GetBudgetStatusHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	at := time.Now()
+	if t, ok := parseDateParam(c.QueryParam("at")); !ok {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid at"})
+	} else if t != nil {
+		at = *t
+	}
+	ctx := c.Request().Context()
+	b := Budget{}
+	if err := h.Budgets.SelectBudgetByID(ctx, intVar, &b); err != nil {
+		return returnBudget(err, c, 0, b)
+	}
+	status, err := h.budgetStatus(ctx, b, at)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, status)
+}
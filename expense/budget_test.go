@@ -0,0 +1,219 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingAlerts struct {
+	alerts []BudgetAlert
+}
+
+func (r *recordingAlerts) BudgetAlert(ctx context.Context, a BudgetAlert) error {
+	r.alerts = append(r.alerts, a)
+	return nil
+}
+
+func TestPeriodRange(t *testing.T) {
+	at := time.Date(2023, 2, 15, 23, 30, 0, 0, time.FixedZone("ICT", -7*60*60))
+	tests := []struct {
+		period   string
+		from, to time.Time
+		key      string
+	}{
+		{PeriodWeek, time.Date(2023, 2, 13, 0, 0, 0, 0, time.UTC), time.Date(2023, 2, 20, 0, 0, 0, 0, time.UTC), "2023-02-13"},
+		{PeriodMonth, time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC), "2023-02"},
+		{PeriodQuarter, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC), "2023-Q1"},
+		{PeriodYear, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), "2023"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.period, func(t *testing.T) {
+			from, to, key := periodRange(tt.period, at)
+			assert.Equal(t, tt.from, from)
+			assert.Equal(t, tt.to, to)
+			assert.Equal(t, tt.key, key)
+		})
+	}
+}
+
+func TestBudgets(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	alerts := &recordingAlerts{}
+	h := Handler{Storage: store, Budgets: store, Alerts: alerts}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	bob := auth.Principal{Subject: "bob", Roles: []string{auth.RoleEditor}}
+	call := func(p auth.Principal, method, target, body string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, handler(c))
+		return rec
+	}
+	spend := func(amount, spentAt string, tags ...string) {
+		body, _ := json.Marshal(map[string]interface{}{
+			"title": "taxi", "amount": amount, "note": "n", "tags": tags, "currency": "THB", "spent_at": spentAt,
+		})
+		rec := call(alice, http.MethodPost, "/expenses", string(body), h.CreateExpenseHandler, "")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+
+	b := Budget{}
+	t.Run("Create Budget Return HTTP Status Created", func(t *testing.T) {
+		rec := call(alice, http.MethodPost, "/budgets", `{"name":"Travel","tags":["travel"," taxi"],"limit":"1000","currency":"thb","threshold":80}`, h.CreateBudgetHandler, "")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		json.Unmarshal(rec.Body.Bytes(), &b)
+		assert.Equal(t, "alice", b.OwnerID)
+		assert.Equal(t, PeriodMonth, b.Period)
+		assert.Equal(t, "THB", b.Currency)
+		assert.Equal(t, []string{"travel", "taxi"}, b.Tags)
+		assert.Equal(t, 80, b.Threshold)
+	})
+	id := strconv.Itoa(b.ID)
+
+	t.Run("Invalid Budget Return HTTP Status Bad Request", func(t *testing.T) {
+		for body, want := range map[string]string{
+			`{"tags":["travel"],"limit":"1","currency":"THB"}`:                             "Invalid request body",
+			`{"name":"x","tags":["travel"],"limit":"1","currency":"THB","period":"day"}`:   "Invalid period",
+			`{"name":"x","tags":["travel"],"limit":"1","currency":"XYZ"}`:                  "Invalid currency",
+			`{"name":"x","tags":["travel"],"limit":"0","currency":"THB"}`:                  "Invalid limit",
+			`{"name":"x","tags":["travel"],"limit":"1","currency":"THB","threshold":2000}`: "Invalid threshold",
+		} {
+			rec := call(alice, http.MethodPost, "/budgets", body, h.CreateBudgetHandler, "")
+			got := Err{}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+			assert.Equal(t, want, got.Msg, body)
+		}
+	})
+
+	t.Run("Alert once when spending crosses the threshold", func(t *testing.T) {
+		spend("500", "2023-01-05T10:00:00Z", "travel")
+		spend("900", "2023-01-05T10:00:00Z", "food")
+		assert.Empty(t, alerts.alerts)
+		spend("350", "2023-01-10T10:00:00Z", "taxi")
+		if assert.Equal(t, 1, len(alerts.alerts)) {
+			assert.Equal(t, b.ID, alerts.alerts[0].Budget.ID)
+			assert.Equal(t, "2023-01", alerts.alerts[0].Status.Period)
+			assert.Equal(t, money.MustParse("850"), alerts.alerts[0].Status.Spent)
+		}
+		spend("300", "2023-01-20T10:00:00Z", "travel")
+		assert.Equal(t, 1, len(alerts.alerts))
+		spend("900", "2023-02-01T10:00:00Z", "travel")
+		assert.Equal(t, 2, len(alerts.alerts))
+	})
+
+	t.Run("Get Budget Status Return HTTP Status OK", func(t *testing.T) {
+		rec := call(alice, http.MethodGet, "/budgets/"+id+"/status?at=2023-01-31", "", h.GetBudgetStatusHandler, id)
+		got := BudgetStatus{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "2023-01", got.Period)
+		assert.Equal(t, 3, got.Count)
+		assert.Equal(t, money.MustParse("1150"), got.Spent)
+		assert.Equal(t, money.MustParse("-150"), got.Remaining)
+		assert.Equal(t, 115.0, got.UsedPercent)
+		assert.True(t, got.Alert)
+		assert.True(t, got.Over)
+
+		rec = call(alice, http.MethodGet, "/budgets/"+id+"/status?at=jan", "", h.GetBudgetStatusHandler, id)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Rejected expense leaves the budget unchanged", func(t *testing.T) {
+		rec := call(alice, http.MethodPost, "/expenses", `{"title":"taxi","amount":"400","note":"n","tags":["travel"],"currency":"THB","spent_at":"2023-02-10T10:00:00Z"}`, h.CreateExpenseHandler, "")
+		created := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &created)
+		expenseID := strconv.Itoa(created.ID)
+		finance := auth.Principal{Subject: "finance", Roles: []string{auth.RoleApprover}}
+		assert.Equal(t, http.StatusOK, call(alice, http.MethodPost, "/expenses/"+expenseID+"/submit", "", h.SubmitExpenseHandler, expenseID).Code)
+		assert.Equal(t, http.StatusOK, call(finance, http.MethodPost, "/expenses/"+expenseID+"/reject", `{"reason":"duplicate"}`, h.RejectExpenseHandler, expenseID).Code)
+
+		rec = call(alice, http.MethodGet, "/budgets/"+id+"/status?at=2023-02-28", "", h.GetBudgetStatusHandler, id)
+		got := BudgetStatus{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, 1, got.Count)
+		assert.Equal(t, money.MustParse("900"), got.Spent)
+	})
+
+	t.Run("Budgets of other owners Return HTTP Status Not Found", func(t *testing.T) {
+		rec := call(bob, http.MethodGet, "/budgets/"+id, "", h.GetBudgetByIDHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = call(bob, http.MethodGet, "/budgets", "", h.GetBudgetsHandler, "")
+		assert.JSONEq(t, "[]", rec.Body.String())
+	})
+
+	t.Run("Update Budget resets the alert", func(t *testing.T) {
+		rec := call(alice, http.MethodPut, "/budgets/"+id, `{"name":"Travel","tags":["travel"],"limit":"2000","currency":"THB","period":"year","threshold":85}`, h.UpdateBudgetByIDHandler, id)
+		got := Budget{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, PeriodYear, got.Period)
+		assert.Equal(t, b.CreatedAt.Unix(), got.CreatedAt.Unix())
+
+		spend("1", "2023-03-01T10:00:00Z", "travel")
+		if assert.Equal(t, 3, len(alerts.alerts)) {
+			assert.Equal(t, "2023", alerts.alerts[2].Status.Period)
+		}
+	})
+
+	t.Run("Delete Budget Return HTTP Status No Content", func(t *testing.T) {
+		rec := call(alice, http.MethodDelete, "/budgets/"+id, "", h.DeleteBudgetByIDHandler, id)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		rec = call(alice, http.MethodDelete, "/budgets/"+id, "", h.DeleteBudgetByIDHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestPostgresBudgets(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	ctx := context.Background()
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	b := Budget{ID: 1, OwnerID: "alice", Tags: []string{"travel"}, Currency: "THB"}
+
+	mock.ExpectQuery("SELECT COALESCE\\(SUM\\(amount\\),0\\), COUNT\\(\\*\\) FROM expenses WHERE deleted_at IS NULL AND owner_id=\\$1 AND currency=\\$2 AND tags && \\$3 AND spent_at >= \\$4 AND spent_at < \\$5 AND status <> 'rejected'").
+		WithArgs("alice", "THB", pq.Array([]string{"travel"}), from, to).
+		WillReturnRows(sqlmock.NewRows([]string{"sum", "count"}).AddRow("850.5", 3))
+	spent, count, err := s.SpentInBudget(ctx, b, from, to)
+	if assert.NoError(t, err) {
+		assert.Equal(t, money.MustParse("850.5"), spent)
+		assert.Equal(t, 3, count)
+	}
+
+	mock.ExpectExec("UPDATE budgets SET alerted_period=\\$2 WHERE id=\\$1 AND alerted_period <> \\$2").
+		WithArgs(1, "2023-01").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE budgets SET alerted_period=\\$2 WHERE id=\\$1 AND alerted_period <> \\$2").
+		WithArgs(1, "2023-01").WillReturnResult(sqlmock.NewResult(0, 0))
+	first, err := s.MarkBudgetAlerted(ctx, 1, "2023-01")
+	assert.NoError(t, err)
+	assert.True(t, first)
+	first, err = s.MarkBudgetAlerted(ctx, 1, "2023-01")
+	assert.NoError(t, err)
+	assert.False(t, first)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -1,72 +1,1433 @@
 package expense
 
 import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
 	"github.com/lib/pq"
 )
 
-func CreateExpenseTable(d *database.DB) error {
-	createTb := `
-	CREATE TABLE IF NOT EXISTS expenses (
-		id SERIAL PRIMARY KEY,
-		title TEXT,
-		amount FLOAT,
-		note TEXT,
-		tags TEXT[]
-	);`
-
-	_, err := d.Database.Exec(createTb)
+type PostgresStore struct {
+	DB *database.DB
+}
+
+func NewPostgresStore(d *database.DB) *PostgresStore {
+	return &PostgresStore{DB: d}
+}
+
+const expenseColumns = "id,owner_id,title,amount,note,tags,currency,spent_at,created_at,updated_at,status,status_reason,account_id"
+
+// transactionColumns select expenses, income and transfers alike.
+const transactionColumns = expenseColumns + ",kind,to_account_id"
+
+// expenseKind keeps the expense statements off income and transfers.
+const expenseKind = " AND kind='expense'"
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func expenseFields(ex *Expense) []interface{} {
+	return []interface{}{&ex.ID, &ex.OwnerID, &ex.Title, &ex.Amount, &ex.Note, pq.Array(&ex.Tags), &ex.Currency, &ex.SpentAt, &ex.CreatedAt, &ex.UpdatedAt, &ex.Status, &ex.StatusReason, &ex.AccountID}
+}
+
+func scanExpense(row rowScanner, ex *Expense) error {
+	return row.Scan(expenseFields(ex)...)
+}
+
+func transactionFields(t *Expense) []interface{} {
+	return append(expenseFields(t), &t.Kind, &t.ToAccountID)
+}
+
+func scanTransaction(row rowScanner, t *Expense) error {
+	return row.Scan(transactionFields(t)...)
+}
+
+// nullableTime lets a zero time fall back to the column's current value or
+// default.
+func nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// ownerCondition restricts a statement to the owner in scope for ctx,
+// numbering its placeholder after args.
+func ownerCondition(ctx context.Context, args []interface{}) (string, []interface{}) {
+	owner, scoped := ownerScope(ctx)
+	if !scoped {
+		return "", args
+	}
+	args = append(args, owner)
+	return " AND owner_id=$" + strconv.Itoa(len(args)), args
+}
+
+func (s *PostgresStore) InsertExpense(ctx context.Context, ex *Expense) error {
+	sqlStatement := `
+	INSERT INTO expenses (title,amount,note,tags,currency,spent_at,owner_id,account_id)
+	values ($1,$2,$3,$4,$5,COALESCE($6,now()),$7,$8)
+	RETURNING id,spent_at,created_at,updated_at;`
+	ex.OwnerID = ownerOf(ctx)
+	ex.Status, ex.StatusReason = StatusDraft, ""
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, sqlStatement,
+			ex.Title, ex.Amount, ex.Note, pq.Array(&ex.Tags), ex.Currency, nullableTime(ex.SpentAt), ex.OwnerID, ex.AccountID)
+		return row.Scan(&ex.ID, &ex.SpentAt, &ex.CreatedAt, &ex.UpdatedAt)
+	})
+}
+
+func (s *PostgresStore) ImportExpenses(ctx context.Context, expenses []Expense) error {
+	_, err := s.copyExpenses(ctx, expenses, nil)
 	return err
 }
 
-func InsertExpense(d *database.DB, ex *Expense) error {
-	row := d.Database.QueryRow("INSERT INTO expenses (title,amount,note,tags) values ($1,$2,$3,$4) RETURNING id",
-		ex.Title, ex.Amount, ex.Note, pq.Array(&ex.Tags))
-	return row.Scan(&ex.ID)
+func (s *PostgresStore) ImportStatement(ctx context.Context, entries []StatementEntry) (int, error) {
+	expenses := make([]Expense, len(entries))
+	fitids := make([]string, len(entries))
+	for i, e := range entries {
+		expenses[i], fitids[i] = e.Expense, e.FITID
+	}
+	n, err := s.copyExpenses(ctx, expenses, fitids)
+	return int(n), err
 }
 
-func UpdateExpenseByID(d *database.DB, rowId int, ex *Expense) error {
+// copyExpenses streams the rows into a temporary table with COPY and
+// inserts them from there, so the insert still passes the row level
+// security checks that COPY into expenses would not. Rows whose fitid the
+// owner already imported are skipped; the rest are counted.
+func (s *PostgresStore) copyExpenses(ctx context.Context, expenses []Expense, fitids []string) (int64, error) {
+	owner := ownerOf(ctx)
+	var inserted int64
+	err := s.DB.InTenantTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `
+	CREATE TEMP TABLE expense_import (
+		ord INT, title TEXT, amount NUMERIC(19,4), note TEXT, tags TEXT[], currency TEXT, spent_at TIMESTAMPTZ, fitid TEXT
+	) ON COMMIT DROP;`)
+		if err != nil {
+			return err
+		}
+		stmt, err := tx.PrepareContext(ctx, pq.CopyIn("expense_import", "ord", "title", "amount", "note", "tags", "currency", "spent_at", "fitid"))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for i, ex := range expenses {
+			var fitid interface{}
+			if fitids != nil {
+				fitid = fitids[i]
+			}
+			if _, err := stmt.ExecContext(ctx, i, ex.Title, ex.Amount, ex.Note, pq.Array(ex.Tags), ex.Currency, nullableTime(ex.SpentAt), fitid); err != nil {
+				return err
+			}
+		}
+		if _, err := stmt.ExecContext(ctx); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+	INSERT INTO expenses (title,amount,note,tags,currency,spent_at,owner_id,fitid)
+	SELECT title,amount,note,tags,currency,COALESCE(spent_at,now()),$1,fitid FROM expense_import ORDER BY ord
+	ON CONFLICT (tenant_id,owner_id,fitid) WHERE fitid IS NOT NULL DO NOTHING;`, owner)
+		if err != nil {
+			return err
+		}
+		inserted, err = res.RowsAffected()
+		return err
+	})
+	return inserted, err
+}
+
+// SelectStatementIDs reports which of fitids the owner has imported before,
+// including expenses since deleted.
+func (s *PostgresStore) SelectStatementIDs(ctx context.Context, fitids []string) (map[string]bool, error) {
+	known := map[string]bool{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, "SELECT fitid FROM expenses WHERE owner_id=$1 AND fitid = ANY($2)", ownerOf(ctx), pq.Array(fitids))
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var fitid string
+			if err := rows.Scan(&fitid); err != nil {
+				return err
+			}
+			known[fitid] = true
+		}
+		return rows.Err()
+	})
+	return known, err
+}
+
+func (s *PostgresStore) UpdateExpenseByID(ctx context.Context, rowId int, ex *Expense) error {
+	owner, args := ownerCondition(ctx, []interface{}{rowId, ex.Title, ex.Amount, ex.Note, pq.Array(&ex.Tags), ex.Currency, nullableTime(ex.SpentAt), ex.AccountID})
 	sqlStatement := `
 	UPDATE expenses
-	SET title=$2 , amount=$3 , note=$4 , tags=$5
-	WHERE id=$1
-	RETURNING id;`
-	stmt, err := d.Database.Prepare(sqlStatement)
-	if err != nil {
+	SET title=$2 , amount=$3 , note=$4 , tags=$5 , currency=$6 , spent_at=COALESCE($7,spent_at) , account_id=$8 , updated_at=now()
+	WHERE id=$1 AND deleted_at IS NULL AND status NOT IN ('approved','reimbursed')` + owner + expenseKind + `
+	RETURNING id,owner_id,spent_at,created_at,updated_at,status,status_reason;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		stmt, err := q.PrepareContext(ctx, sqlStatement)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		row := stmt.QueryRowContext(ctx, args...)
+		err = row.Scan(&ex.ID, &ex.OwnerID, &ex.SpentAt, &ex.CreatedAt, &ex.UpdatedAt, &ex.Status, &ex.StatusReason)
+		if err == sql.ErrNoRows {
+			return explainNoRows(ctx, q, rowId, ErrExpenseLocked)
+		}
+		return err
+	})
+}
+
+// explainNoRows tells a missing expense, reported as sql.ErrNoRows, from one
+// whose status kept a conditional update from matching, reported as conflict.
+func explainNoRows(ctx context.Context, q database.Querier, rowId int, conflict error) error {
+	return explainKindNoRows(ctx, q, rowId, expenseKind, conflict)
+}
+
+// explainKindNoRows is explainNoRows for rows matching the kind condition.
+func explainKindNoRows(ctx context.Context, q database.Querier, rowId int, kind string, conflict error) error {
+	owner, args := ownerCondition(ctx, []interface{}{rowId})
+	var status string
+	row := q.QueryRowContext(ctx, "SELECT status FROM expenses WHERE id=$1 AND deleted_at IS NULL"+owner+kind, args...)
+	if err := row.Scan(&status); err != nil {
 		return err
 	}
-	defer stmt.Close()
-	row := stmt.QueryRow(rowId, ex.Title, ex.Amount, ex.Note, pq.Array(&ex.Tags))
-	return row.Scan(&ex.ID)
+	return conflict
 }
 
-func SelectExpenseByID(d *database.DB, rowId int, ex *Expense) error {
-	stmt, err := d.Database.Prepare("SELECT id,title,amount,note,tags FROM expenses where id=$1")
-	if err != nil {
+func (s *PostgresStore) SelectExpenseByID(ctx context.Context, rowId int, ex *Expense) error {
+	ctx = forReview(ctx)
+	owner, args := ownerCondition(ctx, []interface{}{rowId})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		stmt, err := q.PrepareContext(ctx, "SELECT "+expenseColumns+" FROM expenses where id=$1 AND deleted_at IS NULL"+owner+expenseKind)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		return scanExpense(stmt.QueryRowContext(ctx, args...), ex)
+	})
+}
+
+// orderWithID appends id to the sort so every ordering is total and can be
+// resumed from a cursor row.
+func orderWithID(fields []SortField) []SortField {
+	for _, f := range fields {
+		if f.Column == "id" {
+			return fields
+		}
+	}
+	return append(append([]SortField{}, fields...), SortField{Column: "id"})
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func sortComparison(f SortField, value string) string {
+	if f.Desc {
+		return f.Column + " < " + value
+	}
+	return f.Column + " > " + value
+}
+
+// usesCursorRow tells whether paging q needs the sort values of its cursor
+// row, rather than only its id.
+func usesCursorRow(q ExpenseQuery) bool {
+	return len(orderWithID(q.Sort)) > 1
+}
+
+// keysetCondition selects rows that sort strictly after the cursor row,
+// whose columns are selected as cursor_<column>.
+func keysetCondition(order []SortField) string {
+	alternatives := []string{}
+	for i, f := range order {
+		terms := []string{}
+		for _, prev := range order[:i] {
+			terms = append(terms, prev.Column+" = cursor_"+prev.Column)
+		}
+		terms = append(terms, sortComparison(f, "cursor_"+f.Column))
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// filterConditions are the WHERE conditions of f, numbering parameters
+// through arg.
+func filterConditions(f ExpenseFilter, arg func(interface{}) string) []string {
+	where := []string{"deleted_at IS NULL"}
+	if len(f.Kinds) == 0 {
+		where = append(where, "kind = 'expense'")
+	} else {
+		where = append(where, "kind = ANY("+arg(pq.Array(f.Kinds))+")")
+	}
+	if f.Owner != "" {
+		where = append(where, "owner_id = "+arg(f.Owner))
+	}
+	if f.Status != "" {
+		where = append(where, "status = "+arg(f.Status))
+	}
+	if f.AccountID != nil {
+		p := arg(*f.AccountID)
+		where = append(where, "(account_id = "+p+" OR to_account_id = "+p+")")
+	}
+	if len(f.Tags) > 0 {
+		op := " && "
+		if f.MatchAllTags {
+			op = " @> "
+		}
+		where = append(where, "tags"+op+arg(pq.Array(f.Tags)))
+	}
+	if f.MinAmount != nil {
+		where = append(where, "amount >= "+arg(*f.MinAmount))
+	}
+	if f.MaxAmount != nil {
+		where = append(where, "amount <= "+arg(*f.MaxAmount))
+	}
+	timeRanges := []struct {
+		column string
+		r      TimeRange
+	}{
+		{"spent_at", f.Spent},
+		{"created_at", f.Created},
+		{"updated_at", f.Updated},
+	}
+	for _, tr := range timeRanges {
+		if tr.r.From != nil {
+			where = append(where, tr.column+" >= "+arg(*tr.r.From))
+		}
+		if tr.r.To != nil {
+			where = append(where, tr.column+" < "+arg(*tr.r.To))
+		}
+	}
+	if f.Text != "" {
+		p := arg("%" + escapeLike(f.Text) + "%")
+		where = append(where, "(title ILIKE "+p+" OR note ILIKE "+p+")")
+	}
+	return where
+}
+
+func buildSelectExpenses(q ExpenseQuery) (string, []interface{}) {
+	return buildSelectRows(q, expenseColumns)
+}
+
+func buildSelectRows(q ExpenseQuery, columns string) (string, []interface{}) {
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	from := "expenses"
+	where := filterConditions(q.Filter, arg)
+
+	order := orderWithID(q.Sort)
+	if q.AfterID > 0 && !usesCursorRow(q) {
+		where = append(where, sortComparison(order[0], arg(q.AfterID)))
+	} else if q.AfterID > 0 {
+		columns := []string{}
+		for _, o := range order {
+			columns = append(columns, o.Column+" AS cursor_"+o.Column)
+		}
+		// the cursor row must be one the caller may list, or its sort
+		// values would leak
+		cursor := "id=" + arg(q.AfterID) + " AND deleted_at IS NULL"
+		if q.Filter.Owner != "" {
+			cursor += " AND owner_id=" + arg(q.Filter.Owner)
+		}
+		from += ", (SELECT " + strings.Join(columns, ",") + " FROM expenses WHERE " + cursor + ") AS cursor_row"
+		where = append(where, keysetCondition(order))
+	}
+
+	orderBy := []string{}
+	for _, o := range order {
+		if o.Desc {
+			orderBy = append(orderBy, o.Column+" DESC")
+		} else {
+			orderBy = append(orderBy, o.Column)
+		}
+	}
+	query := "SELECT " + columns + " FROM " + from +
+		" WHERE " + strings.Join(where, " AND ") + " ORDER BY " + strings.Join(orderBy, ",")
+	if q.Limit > 0 {
+		query += " LIMIT " + arg(q.Limit)
+	}
+	return query + ";", args
+}
+
+func (s *PostgresStore) SelectExpenses(ctx context.Context, q ExpenseQuery, each func(Expense) error) error {
+	ctx = forReview(ctx)
+	if owner, scoped := ownerScope(ctx); scoped {
+		q.Filter.Owner = owner
+	}
+	query, args := buildSelectExpenses(q)
+	found := false
+	err := s.selectRows(ctx, query, args, func(rows *sql.Rows) error {
+		var ex Expense
+		if err := scanExpense(rows, &ex); err != nil {
+			return err
+		}
+		found = true
+		return each(ex)
+	})
+	if err == nil && !found {
+		err = s.checkCursorRow(ctx, q)
+	}
+	return err
+}
+
+// checkCursorRow returns errInvalidCursor when q pages from a cursor row
+// the caller can't list, which selects nothing just like the last page.
+func (s *PostgresStore) checkCursorRow(ctx context.Context, q ExpenseQuery) error {
+	if q.AfterID <= 0 || !usesCursorRow(q) {
+		return nil
+	}
+	query, args := "SELECT id FROM expenses WHERE id=$1 AND deleted_at IS NULL", []interface{}{q.AfterID}
+	if q.Filter.Owner != "" {
+		query, args = query+" AND owner_id=$2", append(args, q.Filter.Owner)
+	}
+	return s.DB.InTenant(ctx, func(tq database.Querier) error {
+		var id int
+		err := tq.QueryRowContext(ctx, query, args...).Scan(&id)
+		if err == sql.ErrNoRows {
+			return errInvalidCursor
+		}
 		return err
+	})
+}
+
+// selectRows prepares query and calls scan on every row it returns.
+func (s *PostgresStore) selectRows(ctx context.Context, query string, args []interface{}, scan func(*sql.Rows) error) error {
+	return s.DB.InTenant(ctx, func(tq database.Querier) error {
+		stmt, err := tq.PrepareContext(ctx, query)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		rows, err := stmt.QueryContext(ctx, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := scan(rows); err != nil {
+				return err
+			}
+		}
+
+		return rows.Err()
+	})
+}
+
+// summaryGroupExprs group rows in the same UTC buckets as summaryKeys.
+var summaryGroupExprs = map[string]string{
+	GroupByTag:      "tag",
+	GroupByDay:      "to_char(spent_at AT TIME ZONE 'UTC','YYYY-MM-DD')",
+	GroupByWeek:     "to_char(date_trunc('week',spent_at AT TIME ZONE 'UTC'),'YYYY-MM-DD')",
+	GroupByMonth:    "to_char(spent_at AT TIME ZONE 'UTC','YYYY-MM')",
+	GroupByCurrency: "currency",
+}
+
+// reportedRows selects the expenses matching where with their amount and
+// currency converted into currency like rateTable.convert: at the latest
+// rate dated on or before the UTC day they were spent, taking a direct rate
+// over the inverse of one out of currency on the same day. Rows with no rate,
+// or whose converted amount is past money.MaxConverted, keep their own
+// amount and currency and set missing_rate.
+func reportedRows(where, currency string, arg func(interface{}) string) string {
+	c := arg(currency)
+	digits, _ := money.MinorUnits(currency)
+	day := "(expenses.spent_at AT TIME ZONE 'UTC')::date"
+	converted := "ROUND(amount*rate.rate," + strconv.Itoa(digits) + ")"
+	return "(SELECT spent_at,tags,kind," +
+		"COALESCE(converted,amount) AS amount," +
+		"CASE WHEN converted IS NULL THEN currency ELSE " + c + " END AS currency," +
+		"currency<>" + c + " AND converted IS NULL AS missing_rate" +
+		" FROM expenses LEFT JOIN LATERAL (SELECT rate_date,rate FROM (" +
+		"SELECT rate_date,rate,0 AS inverse FROM exchange_rates WHERE base=expenses.currency AND quote=" + c + " AND rate_date<=" + day +
+		" UNION ALL SELECT rate_date,ROUND(1/rate," + strconv.Itoa(money.RateScale) + "),1 FROM exchange_rates WHERE base=" + c + " AND quote=expenses.currency AND rate_date<=" + day +
+		") AS rates ORDER BY rate_date DESC,inverse LIMIT 1) AS rate ON true" +
+		", LATERAL (SELECT CASE WHEN abs(" + converted + ")<=" + money.MaxConverted.String() + " THEN " + converted + " END AS converted) AS conversion" +
+		" WHERE " + where + ") AS expenses"
+}
+
+// summaryRows is the FROM clause, with its WHERE, of the summaries of the
+// rows matching f, converted first unless currency is empty.
+func summaryRows(f ExpenseFilter, groupBy, currency string, arg func(interface{}) string) string {
+	where := strings.Join(filterConditions(f, arg), " AND ")
+	from := "expenses"
+	if currency != "" {
+		from, where = reportedRows(where, currency, arg), ""
+	}
+	if groupBy == GroupByTag {
+		from += ", unnest(tags) AS tag"
+	}
+	if where != "" {
+		from += " WHERE " + where
 	}
-	defer stmt.Close()
-	row := stmt.QueryRow(rowId)
-	return row.Scan(&ex.ID, &ex.Title, &ex.Amount, &ex.Note, pq.Array(&ex.Tags))
+	return " FROM " + from
 }
 
-func SelectAllExpenses(d *database.DB, expenses *[]Expense) error {
-	stmt, err := d.Database.Prepare("SELECT * FROM expenses;")
-	if err != nil {
+func buildSummarizeExpenses(f ExpenseFilter, groupBy, currency string) (string, []interface{}) {
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	from := summaryRows(f, groupBy, currency, arg)
+	key := summaryGroupExprs[groupBy]
+	aggregates := ",currency,COUNT(*),SUM(amount),ROUND(AVG(amount)," + strconv.Itoa(money.Scale) + "),MIN(amount),MAX(amount)"
+	if currency != "" {
+		aggregates += ",bool_or(missing_rate)"
+	}
+	// byte order, as the memory store sorts
+	return "SELECT " + key + aggregates + from +
+		" GROUP BY " + key + ",currency ORDER BY " + key + ` COLLATE "C",currency COLLATE "C";`, args
+}
+
+func (s *PostgresStore) SummarizeExpenses(ctx context.Context, f ExpenseFilter, groupBy, currency string) ([]SummaryGroup, error) {
+	ctx = forReview(ctx)
+	if owner, scoped := ownerScope(ctx); scoped {
+		f.Owner = owner
+	}
+	query, args := buildSummarizeExpenses(f, groupBy, currency)
+	groups := []SummaryGroup{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var g SummaryGroup
+			dest := []interface{}{&g.Key, &g.Currency, &g.Count, &g.Total, &g.Average, &g.Min, &g.Max}
+			if currency != "" {
+				dest = append(dest, &g.MissingRate)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+			groups = append(groups, g)
+		}
+		return rows.Err()
+	})
+	return groups, err
+}
+
+func (s *PostgresStore) SearchExpenses(ctx context.Context, terms []string, limit int, each func(SearchResult) error) error {
+	// the markers are stripped from the text first so only ts_headline's
+	// become highlights
+	markers := "StartSel=" + headlineStart + ", StopSel=" + headlineStop
+	owner, args := ownerCondition(ctx, []interface{}{prefixTSQuery(terms), limit,
+		headlineStart + headlineStop, markers + ", HighlightAll=true", markers + ", MaxFragments=2"})
+	sqlStatement := `
+	SELECT ` + expenseColumns + `,
+		ts_rank_cd(search_vector, query) AS rank,
+		ts_headline('simple', translate(coalesce(title,''), $3, ''), query, $4),
+		ts_headline('simple', translate(coalesce(note,''), $3, ''), query, $5)
+	FROM expenses, to_tsquery('simple', $1) AS query
+	WHERE deleted_at IS NULL AND search_vector @@ query` + owner + expenseKind + `
+	ORDER BY rank DESC, id
+	LIMIT $2;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, sqlStatement, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r SearchResult
+			dest := append(expenseFields(&r.Expense), &r.Rank, &r.Highlight.Title, &r.Highlight.Note)
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+			r.Highlight.Title, r.Highlight.Note = markHeadline(r.Highlight.Title), markHeadline(r.Highlight.Note)
+			if err := each(r); err != nil {
+				return err
+			}
+		}
+		return rows.Err()
+	})
+}
+
+func (s *PostgresStore) DeleteExpenseByID(ctx context.Context, rowId int) error {
+	owner, args := ownerCondition(ctx, []interface{}{rowId})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, "UPDATE expenses SET deleted_at=now() WHERE id=$1 AND deleted_at IS NULL AND status NOT IN ('approved','reimbursed')"+owner+expenseKind+" RETURNING id", args...)
+		err := row.Scan(&rowId)
+		if err == sql.ErrNoRows {
+			return explainNoRows(ctx, q, rowId, ErrExpenseLocked)
+		}
 		return err
+	})
+}
+
+func (s *PostgresStore) RestoreExpenseByID(ctx context.Context, rowId int, ex *Expense) error {
+	owner, args := ownerCondition(ctx, []interface{}{rowId})
+	sqlStatement := `
+	UPDATE expenses
+	SET deleted_at=NULL
+	WHERE id=$1 AND deleted_at IS NOT NULL` + owner + expenseKind + `
+	RETURNING ` + expenseColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		return scanExpense(q.QueryRowContext(ctx, sqlStatement, args...), ex)
+	})
+}
+
+func (s *PostgresStore) TransitionExpenseByID(ctx context.Context, rowId int, t Transition, ex *Expense) error {
+	ctx = forReview(ctx)
+	owner, args := ownerCondition(ctx, []interface{}{rowId, t.To, t.Reason, pq.Array(t.From)})
+	sqlStatement := `
+	UPDATE expenses
+	SET status=$2 , status_reason=$3 , updated_at=now()
+	WHERE id=$1 AND deleted_at IS NULL AND status = ANY($4)` + owner + expenseKind + `
+	RETURNING ` + expenseColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		err := scanExpense(q.QueryRowContext(ctx, sqlStatement, args...), ex)
+		if err == sql.ErrNoRows {
+			return explainNoRows(ctx, q, rowId, ErrIllegalTransition)
+		}
+		return err
+	})
+}
+
+const attachmentColumns = "id,expense_id,filename,content_type,size,sha256,storage_key,created_at"
+
+func scanAttachment(row rowScanner, a *Attachment) error {
+	return row.Scan(&a.ID, &a.ExpenseID, &a.Filename, &a.ContentType, &a.Size, &a.SHA256, &a.StorageKey, &a.CreatedAt)
+}
+
+// InsertAttachment only inserts while the expense is visible and not
+// approved.
+func (s *PostgresStore) InsertAttachment(ctx context.Context, a *Attachment) error {
+	owner, args := ownerCondition(ctx, []interface{}{a.ExpenseID, a.Filename, a.ContentType, a.Size, a.SHA256, a.StorageKey})
+	sqlStatement := `
+	INSERT INTO expense_attachments (expense_id,filename,content_type,size,sha256,storage_key)
+	SELECT id,$2,$3,$4,$5,$6 FROM expenses
+	WHERE id=$1 AND deleted_at IS NULL AND status NOT IN ('approved','reimbursed')` + owner + expenseKind + `
+	RETURNING id,created_at;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		err := q.QueryRowContext(ctx, sqlStatement, args...).Scan(&a.ID, &a.CreatedAt)
+		if err == sql.ErrNoRows {
+			return explainNoRows(ctx, q, a.ExpenseID, ErrExpenseLocked)
+		}
+		return err
+	})
+}
+
+// attachmentOwnerCondition restricts attachments to visible expenses.
+func attachmentOwnerCondition(ctx context.Context, args []interface{}) (string, []interface{}) {
+	owner, args := ownerCondition(ctx, args)
+	return ` AND expense_id IN (SELECT id FROM expenses WHERE id=$1 AND deleted_at IS NULL` + owner + expenseKind + `)`, args
+}
+
+func (s *PostgresStore) SelectAttachments(ctx context.Context, expenseID int) ([]Attachment, error) {
+	visible, args := attachmentOwnerCondition(ctx, []interface{}{expenseID})
+	attachments := []Attachment{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, "SELECT "+attachmentColumns+" FROM expense_attachments WHERE expense_id=$1"+visible+" ORDER BY id", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			a := Attachment{}
+			if err := scanAttachment(rows, &a); err != nil {
+				return err
+			}
+			attachments = append(attachments, a)
+		}
+		return rows.Err()
+	})
+	return attachments, err
+}
+
+func (s *PostgresStore) SelectAttachmentByID(ctx context.Context, expenseID, id int, a *Attachment) error {
+	visible, args := attachmentOwnerCondition(ctx, []interface{}{expenseID, id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, "SELECT "+attachmentColumns+" FROM expense_attachments WHERE expense_id=$1 AND id=$2"+visible, args...)
+		return scanAttachment(row, a)
+	})
+}
+
+func (s *PostgresStore) DeleteAttachmentByID(ctx context.Context, expenseID, id int, a *Attachment) error {
+	owner, args := ownerCondition(ctx, []interface{}{expenseID, id})
+	sqlStatement := `
+	DELETE FROM expense_attachments
+	WHERE expense_id=$1 AND id=$2 AND expense_id IN (
+		SELECT id FROM expenses
+		WHERE id=$1 AND deleted_at IS NULL AND status NOT IN ('approved','reimbursed')` + owner + expenseKind + `)
+	RETURNING ` + attachmentColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		err := scanAttachment(q.QueryRowContext(ctx, sqlStatement, args...), a)
+		if err != sql.ErrNoRows {
+			return err
+		}
+		// with the expense visible, either the attachment is missing or the
+		// expense is approved
+		if err := explainNoRows(ctx, q, expenseID, ErrExpenseLocked); err != ErrExpenseLocked {
+			return err
+		}
+		var exists bool
+		row := q.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM expense_attachments WHERE expense_id=$1 AND id=$2)", expenseID, id)
+		if err := row.Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		return ErrExpenseLocked
+	})
+}
+
+// PurgeDeletedExpenses reads the storage keys of the attachments that go
+// with the expenses in the same transaction that deletes them.
+func (s *PostgresStore) PurgeDeletedExpenses(ctx context.Context, deletedBefore time.Time) (int64, []string, error) {
+	var purged int64
+	keys := []string{}
+	purge := func(ctx context.Context) error {
+		return s.DB.InTenantTx(ctx, func(tx *sql.Tx) error {
+			rows, err := tx.QueryContext(ctx, `
+	SELECT a.storage_key FROM expense_attachments a JOIN expenses e ON e.id=a.expense_id
+	WHERE e.deleted_at IS NOT NULL AND e.deleted_at < $1`, deletedBefore)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			tenantKeys := []string{}
+			for rows.Next() {
+				var key string
+				if err := rows.Scan(&key); err != nil {
+					return err
+				}
+				tenantKeys = append(tenantKeys, key)
+			}
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			result, err := tx.ExecContext(ctx, "DELETE FROM expenses WHERE deleted_at IS NOT NULL AND deleted_at < $1", deletedBefore)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			purged += n
+			keys = append(keys, tenantKeys...)
+			return nil
+		})
 	}
-	rows, err := stmt.Query()
-	if err != nil {
+	// the purge job has no tenant and cleans up after all of them
+	if _, ok := database.TenantFrom(ctx); !ok {
+		err := s.DB.ForEachTenant(ctx, purge)
+		return purged, keys, err
+	}
+	err := purge(ctx)
+	return purged, keys, err
+}
+
+func (s *PostgresStore) Close() error {
+	return s.DB.CloseDB()
+}
+
+const budgetColumns = "id,owner_id,name,tags,period,amount_limit,currency,threshold,created_at,updated_at"
+
+func scanBudget(row rowScanner, b *Budget) error {
+	return row.Scan(&b.ID, &b.OwnerID, &b.Name, pq.Array(&b.Tags), &b.Period, &b.Limit, &b.Currency, &b.Threshold, &b.CreatedAt, &b.UpdatedAt)
+}
+
+func (s *PostgresStore) InsertBudget(ctx context.Context, b *Budget) error {
+	sqlStatement := `
+	INSERT INTO budgets (owner_id,name,tags,period,amount_limit,currency,threshold)
+	VALUES ($1,$2,$3,$4,$5,$6,$7)
+	RETURNING ` + budgetColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, sqlStatement, ownerOf(ctx), b.Name, pq.Array(b.Tags), b.Period, b.Limit, b.Currency, b.Threshold)
+		return scanBudget(row, b)
+	})
+}
+
+func (s *PostgresStore) SelectBudgetByID(ctx context.Context, id int, b *Budget) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		return scanBudget(q.QueryRowContext(ctx, "SELECT "+budgetColumns+" FROM budgets WHERE id=$1"+owner, args...), b)
+	})
+}
+
+func (s *PostgresStore) SelectBudgets(ctx context.Context) ([]Budget, error) {
+	query := "SELECT " + budgetColumns + " FROM budgets"
+	args := []interface{}{}
+	if owner, scoped := ownerScope(ctx); scoped {
+		query += " WHERE owner_id=$1"
+		args = append(args, owner)
+	}
+	budgets := []Budget{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, query+" ORDER BY id", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			b := Budget{}
+			if err := scanBudget(rows, &b); err != nil {
+				return err
+			}
+			budgets = append(budgets, b)
+		}
+		return rows.Err()
+	})
+	return budgets, err
+}
+
+func (s *PostgresStore) UpdateBudgetByID(ctx context.Context, id int, b *Budget) error {
+	owner, args := ownerCondition(ctx, []interface{}{id, b.Name, pq.Array(b.Tags), b.Period, b.Limit, b.Currency, b.Threshold})
+	sqlStatement := `
+	UPDATE budgets
+	SET name=$2 , tags=$3 , period=$4 , amount_limit=$5 , currency=$6 , threshold=$7 , alerted_period='' , updated_at=now()
+	WHERE id=$1` + owner + `
+	RETURNING ` + budgetColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		return scanBudget(q.QueryRowContext(ctx, sqlStatement, args...), b)
+	})
+}
+
+func (s *PostgresStore) DeleteBudgetByID(ctx context.Context, id int) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		res, err := q.ExecContext(ctx, "DELETE FROM budgets WHERE id=$1"+owner, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return sql.ErrNoRows
+		}
 		return err
+	})
+}
+
+func (s *PostgresStore) SpentInBudget(ctx context.Context, b Budget, from, to time.Time) (money.Amount, int, error) {
+	var spent money.Amount
+	var count int
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, `
+	SELECT COALESCE(SUM(amount),0), COUNT(*) FROM expenses
+	WHERE deleted_at IS NULL AND owner_id=$1 AND currency=$2 AND tags && $3 AND spent_at >= $4 AND spent_at < $5 AND status <> 'rejected'`+expenseKind+`;`,
+			b.OwnerID, b.Currency, pq.Array(b.Tags), from, to)
+		return row.Scan(&spent, &count)
+	})
+	return spent, count, err
+}
+
+func (s *PostgresStore) MarkBudgetAlerted(ctx context.Context, id int, period string) (bool, error) {
+	var marked bool
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		res, err := q.ExecContext(ctx, "UPDATE budgets SET alerted_period=$2 WHERE id=$1 AND alerted_period <> $2", id, period)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		marked = n == 1
+		return err
+	})
+	return marked, err
+}
+
+const recurringColumns = "id,owner_id,title,amount,note,tags,currency,frequency,repeat_interval,start_at,until,occurrences,next_at,created_at,updated_at"
+
+// recurringBatchSize is how many due templates one transaction locks.
+const recurringBatchSize = 50
+
+func scanRecurring(row rowScanner, r *RecurringExpense) error {
+	return row.Scan(&r.ID, &r.OwnerID, &r.Title, &r.Amount, &r.Note, pq.Array(&r.Tags), &r.Currency,
+		&r.Frequency, &r.Interval, &r.StartAt, &r.Until, &r.Occurrences, &r.NextAt, &r.CreatedAt, &r.UpdatedAt)
+}
+
+func (s *PostgresStore) InsertRecurring(ctx context.Context, r *RecurringExpense) error {
+	sqlStatement := `
+	INSERT INTO recurring_expenses (owner_id,title,amount,note,tags,currency,frequency,repeat_interval,start_at,until,occurrences,next_at)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12)
+	RETURNING ` + recurringColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, sqlStatement, ownerOf(ctx), r.Title, r.Amount, r.Note, pq.Array(r.Tags), r.Currency,
+			r.Frequency, r.Interval, r.StartAt, r.Until, r.Occurrences, r.NextAt)
+		return scanRecurring(row, r)
+	})
+}
+
+func (s *PostgresStore) SelectRecurringByID(ctx context.Context, id int, r *RecurringExpense) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		return scanRecurring(q.QueryRowContext(ctx, "SELECT "+recurringColumns+" FROM recurring_expenses WHERE id=$1"+owner, args...), r)
+	})
+}
+
+func (s *PostgresStore) SelectRecurring(ctx context.Context) ([]RecurringExpense, error) {
+	query := "SELECT " + recurringColumns + " FROM recurring_expenses"
+	args := []interface{}{}
+	if owner, scoped := ownerScope(ctx); scoped {
+		query += " WHERE owner_id=$1"
+		args = append(args, owner)
 	}
+	templates := []RecurringExpense{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, query+" ORDER BY id", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			r := RecurringExpense{}
+			if err := scanRecurring(rows, &r); err != nil {
+				return err
+			}
+			templates = append(templates, r)
+		}
+		return rows.Err()
+	})
+	return templates, err
+}
+
+func (s *PostgresStore) UpdateRecurringByID(ctx context.Context, id int, r *RecurringExpense) error {
+	owner, args := ownerCondition(ctx, []interface{}{id, r.Title, r.Amount, r.Note, pq.Array(r.Tags), r.Currency,
+		r.Frequency, r.Interval, r.StartAt, r.Until, r.Occurrences, r.NextAt})
+	sqlStatement := `
+	UPDATE recurring_expenses
+	SET title=$2 , amount=$3 , note=$4 , tags=$5 , currency=$6 , frequency=$7 , repeat_interval=$8 ,
+		start_at=$9 , until=$10 , occurrences=$11 , next_at=$12 , updated_at=now()
+	WHERE id=$1` + owner + `
+	RETURNING ` + recurringColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		return scanRecurring(q.QueryRowContext(ctx, sqlStatement, args...), r)
+	})
+}
+
+func (s *PostgresStore) DeleteRecurringByID(ctx context.Context, id int) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		res, err := q.ExecContext(ctx, "DELETE FROM recurring_expenses WHERE id=$1"+owner, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return sql.ErrNoRows
+		}
+		return err
+	})
+}
+
+func (s *PostgresStore) MaterializeRecurring(ctx context.Context, now time.Time) (int64, error) {
+	var created int64
+	materialize := func(ctx context.Context) error {
+		for {
+			n, more, err := s.materializeBatch(ctx, now)
+			created += n
+			if err != nil || !more {
+				return err
+			}
+		}
+	}
+	// like the purge job, the scheduler has no tenant and serves all of them
+	if _, ok := database.TenantFrom(ctx); !ok {
+		err := s.DB.ForEachTenant(ctx, materialize)
+		return created, err
+	}
+	err := materialize(ctx)
+	return created, err
+}
+
+// materializeBatch locks a batch of due templates, skipping the ones another
+// scheduler holds, and inserts their occurrences in the same transaction as
+// it advances them. The unique index on (recurring_id, spent_at) keeps an
+// occurrence from being inserted twice should that ever race.
+func (s *PostgresStore) materializeBatch(ctx context.Context, now time.Time) (int64, bool, error) {
+	var created int64
+	var more bool
+	err := s.DB.InTenantTx(ctx, func(tx *sql.Tx) error {
+		created = 0
+		rows, err := tx.QueryContext(ctx, "SELECT "+recurringColumns+" FROM recurring_expenses WHERE next_at <= $1 ORDER BY next_at, id LIMIT $2 FOR UPDATE SKIP LOCKED", now, recurringBatchSize)
+		if err != nil {
+			return err
+		}
+		templates := []RecurringExpense{}
+		for rows.Next() {
+			r := RecurringExpense{}
+			if err := scanRecurring(rows, &r); err != nil {
+				rows.Close()
+				return err
+			}
+			templates = append(templates, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		more = len(templates) == recurringBatchSize
+		for _, r := range templates {
+			for _, spentAt := range r.due(now) {
+				res, err := tx.ExecContext(ctx, `
+	INSERT INTO expenses (title,amount,note,tags,currency,spent_at,owner_id,tenant_id,recurring_id)
+	SELECT title,amount,note,tags,currency,$2,owner_id,tenant_id,id FROM recurring_expenses WHERE id=$1
+	ON CONFLICT (recurring_id,spent_at) WHERE recurring_id IS NOT NULL DO NOTHING;`, r.ID, spentAt)
+				if err != nil {
+					return err
+				}
+				n, err := res.RowsAffected()
+				if err != nil {
+					return err
+				}
+				created += n
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE recurring_expenses SET occurrences=$2 , next_at=$3 WHERE id=$1", r.ID, r.Occurrences, r.NextAt); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return created, more, nil
+}
+
+func (s *PostgresStore) ReplaceSplit(ctx context.Context, split *Split) error {
+	owner, args := ownerCondition(ctx, []interface{}{split.ExpenseID})
+	return s.DB.InTenantTx(ctx, func(tx *sql.Tx) error {
+		row := tx.QueryRowContext(ctx, `
+	SELECT owner_id,currency FROM expenses
+	WHERE id=$1 AND deleted_at IS NULL AND status NOT IN ('approved','reimbursed')`+owner+expenseKind+`
+	FOR UPDATE;`, args...)
+		err := row.Scan(&split.Payer, &split.Currency)
+		if err == sql.ErrNoRows {
+			return explainNoRows(ctx, tx, split.ExpenseID, ErrExpenseLocked)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM expense_shares WHERE expense_id=$1", split.ExpenseID); err != nil {
+			return err
+		}
+		for i, share := range split.Shares {
+			_, err := tx.ExecContext(ctx, `
+	INSERT INTO expense_shares (expense_id,participant,method,percent,amount,position)
+	VALUES ($1,$2,$3,$4,$5,$6);`, split.ExpenseID, share.Participant, split.Method, share.Percent, share.Amount, i)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const splitColumns = "s.expense_id,e.owner_id,e.currency,s.method,s.participant,s.percent,s.amount"
+
+// scanSplits groups share rows ordered by expense into splits.
+func scanSplits(rows *sql.Rows) ([]Split, error) {
+	splits := []Split{}
 	for rows.Next() {
-		var ex Expense
-		err := rows.Scan(&ex.ID, &ex.Title, &ex.Amount, &ex.Note, pq.Array(&ex.Tags))
+		split, share := Split{}, Share{}
+		err := rows.Scan(&split.ExpenseID, &split.Payer, &split.Currency, &split.Method, &share.Participant, &share.Percent, &share.Amount)
+		if err != nil {
+			return nil, err
+		}
+		if n := len(splits); n == 0 || splits[n-1].ExpenseID != split.ExpenseID {
+			splits = append(splits, split)
+		}
+		last := &splits[len(splits)-1]
+		last.Shares = append(last.Shares, share)
+	}
+	return splits, rows.Err()
+}
+
+func (s *PostgresStore) SelectSplit(ctx context.Context, expenseID int, split *Split) error {
+	owner, args := ownerCondition(ctx, []interface{}{expenseID})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, `
+	SELECT `+splitColumns+` FROM expense_shares s JOIN expenses e ON e.id=s.expense_id
+	WHERE s.expense_id=$1 AND e.deleted_at IS NULL`+owner+`
+	ORDER BY s.position;`, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		splits, err := scanSplits(rows)
+		if err != nil {
+			return err
+		}
+		if len(splits) == 0 {
+			return sql.ErrNoRows
+		}
+		*split = splits[0]
+		return nil
+	})
+}
+
+func (s *PostgresStore) DeleteSplit(ctx context.Context, expenseID int) error {
+	owner, args := ownerCondition(ctx, []interface{}{expenseID})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		res, err := q.ExecContext(ctx, `
+	DELETE FROM expense_shares
+	WHERE expense_id IN (SELECT id FROM expenses WHERE id=$1 AND deleted_at IS NULL`+owner+`);`, args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 0 {
+			return sql.ErrNoRows
+		}
+		return err
+	})
+}
+
+func (s *PostgresStore) SelectSplits(ctx context.Context) ([]Split, error) {
+	query := `
+	SELECT ` + splitColumns + ` FROM expense_shares s JOIN expenses e ON e.id=s.expense_id
+	WHERE e.deleted_at IS NULL AND e.status <> 'rejected'`
+	args := []interface{}{}
+	if owner, scoped := ownerScope(ctx); scoped {
+		query += ` AND (e.owner_id=$1 OR s.expense_id IN (SELECT expense_id FROM expense_shares WHERE participant=$1))`
+		args = append(args, owner)
+	}
+	var splits []Split
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, query+" ORDER BY s.expense_id, s.position;", args...)
 		if err != nil {
 			return err
 		}
-		*expenses = append(*expenses, ex)
+		defer rows.Close()
+		splits, err = scanSplits(rows)
+		return err
+	})
+	return splits, err
+}
+
+// accountDelta is balanceChange in SQL.
+func accountDelta(account string) string {
+	return "CASE WHEN kind='income' OR to_account_id=" + account + " THEN amount ELSE -amount END"
+}
+
+// accountTransactions are the transactions into or out of account.
+func accountTransactions(account string) string {
+	return "(account_id=" + account + " OR to_account_id=" + account + ")"
+}
+
+// accountEntries are the transactions that count towards the balance of
+// account: active ones, leaving out rejected expenses, which were never paid.
+func accountEntries(account string) string {
+	return accountTransactions(account) + " AND deleted_at IS NULL AND status <> 'rejected'"
+}
+
+// accountColumns selects an account with its balance from the accounts
+// table, which must not be aliased.
+var accountColumns = `id,owner_id,name,type,currency,opening_balance,
+	opening_balance + COALESCE((SELECT SUM(` + accountDelta("accounts.id") + `) FROM expenses
+		WHERE ` + accountEntries("accounts.id") + `),0),
+	created_at,updated_at`
+
+func scanAccount(row rowScanner, a *Account) error {
+	return row.Scan(&a.ID, &a.OwnerID, &a.Name, &a.Type, &a.Currency, &a.OpeningBalance, &a.Balance, &a.CreatedAt, &a.UpdatedAt)
+}
+
+func (s *PostgresStore) InsertAccount(ctx context.Context, a *Account) error {
+	sqlStatement := `
+	INSERT INTO accounts (owner_id,name,type,currency,opening_balance)
+	VALUES ($1,$2,$3,$4,$5)
+	RETURNING ` + accountColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, sqlStatement, ownerOf(ctx), a.Name, a.Type, a.Currency, a.OpeningBalance)
+		return scanAccount(row, a)
+	})
+}
+
+func (s *PostgresStore) SelectAccountByID(ctx context.Context, id int, a *Account) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		return scanAccount(q.QueryRowContext(ctx, "SELECT "+accountColumns+" FROM accounts WHERE id=$1"+owner, args...), a)
+	})
+}
+
+func (s *PostgresStore) SelectAccounts(ctx context.Context) ([]Account, error) {
+	query := "SELECT " + accountColumns + " FROM accounts"
+	args := []interface{}{}
+	if owner, scoped := ownerScope(ctx); scoped {
+		query += " WHERE owner_id=$1"
+		args = append(args, owner)
 	}
+	accounts := []Account{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, query+" ORDER BY id", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			a := Account{}
+			if err := scanAccount(rows, &a); err != nil {
+				return err
+			}
+			accounts = append(accounts, a)
+		}
+		return rows.Err()
+	})
+	return accounts, err
+}
+
+// explainAccountNoRows tells a missing account, reported as sql.ErrNoRows,
+// from one expenses reference.
+func explainAccountNoRows(ctx context.Context, q database.Querier, id int) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	var found int
+	if err := q.QueryRowContext(ctx, "SELECT id FROM accounts WHERE id=$1"+owner, args...).Scan(&found); err != nil {
+		return err
+	}
+	return ErrAccountInUse
+}
 
-	return nil
+func (s *PostgresStore) UpdateAccountByID(ctx context.Context, id int, a *Account) error {
+	owner, args := ownerCondition(ctx, []interface{}{id, a.Name, a.Type, a.Currency, a.OpeningBalance})
+	sqlStatement := `
+	UPDATE accounts
+	SET name=$2 , type=$3 , currency=$4 , opening_balance=$5 , updated_at=now()
+	WHERE id=$1` + owner + ` AND (currency=$4 OR NOT EXISTS (SELECT 1 FROM expenses WHERE ` + accountTransactions("$1") + `))
+	RETURNING ` + accountColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		err := scanAccount(q.QueryRowContext(ctx, sqlStatement, args...), a)
+		if err == sql.ErrNoRows {
+			return explainAccountNoRows(ctx, q, id)
+		}
+		return err
+	})
+}
+
+func (s *PostgresStore) DeleteAccountByID(ctx context.Context, id int) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		res, err := q.ExecContext(ctx, "DELETE FROM accounts WHERE id=$1"+owner+
+			" AND NOT EXISTS (SELECT 1 FROM expenses WHERE "+accountTransactions("$1")+")", args...)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+		return explainAccountNoRows(ctx, q, id)
+	})
+}
+
+func (s *PostgresStore) SelectAccountStatement(ctx context.Context, id int, spent TimeRange, st *AccountStatement) error {
+	st.From, st.To, st.Entries = spent.From, spent.To, []AccountEntry{}
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		owner, args := ownerCondition(ctx, []interface{}{id})
+		err := scanAccount(q.QueryRowContext(ctx, "SELECT "+accountColumns+" FROM accounts WHERE id=$1"+owner, args...), &st.Account)
+		if err != nil {
+			return err
+		}
+		st.OpeningBalance = st.Account.OpeningBalance
+		if spent.From != nil {
+			var before money.Amount
+			row := q.QueryRowContext(ctx, `
+	SELECT COALESCE(SUM(`+accountDelta("$1")+`),0) FROM expenses
+	WHERE `+accountEntries("$1")+` AND spent_at < $2;`, id, *spent.From)
+			if err := row.Scan(&before); err != nil {
+				return err
+			}
+			st.OpeningBalance = st.OpeningBalance.Add(before)
+		}
+		st.ClosingBalance = st.OpeningBalance
+
+		args = []interface{}{}
+		arg := func(v interface{}) string {
+			args = append(args, v)
+			return "$" + strconv.Itoa(len(args))
+		}
+		where := filterConditions(ExpenseFilter{AccountID: &id, Kinds: transactionKinds, Spent: spent}, arg)
+		where = append(where, "status <> 'rejected'")
+		delta := accountDelta(arg(id))
+		rows, err := q.QueryContext(ctx, "SELECT "+transactionColumns+", SUM("+delta+") OVER (ORDER BY spent_at, id) FROM expenses WHERE "+
+			strings.Join(where, " AND ")+" ORDER BY spent_at, id;", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			e := AccountEntry{}
+			var running money.Amount
+			if err := rows.Scan(append(transactionFields(&e.Expense), &running)...); err != nil {
+				return err
+			}
+			e.Balance = st.OpeningBalance.Add(running)
+			st.ClosingBalance = e.Balance
+			st.Entries = append(st.Entries, e)
+		}
+		return rows.Err()
+	})
+}
+
+func (s *PostgresStore) InsertTransaction(ctx context.Context, t *Expense) error {
+	sqlStatement := `
+	INSERT INTO expenses (title,amount,note,tags,currency,spent_at,owner_id,account_id,kind,to_account_id)
+	values ($1,$2,$3,$4,$5,COALESCE($6,now()),$7,$8,$9,$10)
+	RETURNING id,spent_at,created_at,updated_at;`
+	t.OwnerID = ownerOf(ctx)
+	t.Status, t.StatusReason = StatusDraft, ""
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, sqlStatement,
+			t.Title, t.Amount, t.Note, pq.Array(&t.Tags), t.Currency, nullableTime(t.SpentAt), t.OwnerID, t.AccountID, t.Kind, t.ToAccountID)
+		return row.Scan(&t.ID, &t.SpentAt, &t.CreatedAt, &t.UpdatedAt)
+	})
+}
+
+func (s *PostgresStore) SelectTransactionByID(ctx context.Context, id int, t *Expense) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, "SELECT "+transactionColumns+" FROM expenses WHERE id=$1 AND deleted_at IS NULL"+owner, args...)
+		return scanTransaction(row, t)
+	})
+}
+
+func (s *PostgresStore) UpdateTransactionByID(ctx context.Context, id int, t *Expense) error {
+	owner, args := ownerCondition(ctx, []interface{}{id, t.Title, t.Amount, t.Note, pq.Array(&t.Tags), t.Currency, nullableTime(t.SpentAt), t.AccountID, t.ToAccountID})
+	sqlStatement := `
+	UPDATE expenses
+	SET title=$2 , amount=$3 , note=$4 , tags=$5 , currency=$6 , spent_at=COALESCE($7,spent_at) , account_id=$8 , to_account_id=$9 , updated_at=now()
+	WHERE id=$1 AND deleted_at IS NULL AND status NOT IN ('approved','reimbursed')` + owner + `
+	RETURNING ` + transactionColumns + `;`
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		err := scanTransaction(q.QueryRowContext(ctx, sqlStatement, args...), t)
+		if err == sql.ErrNoRows {
+			return explainKindNoRows(ctx, q, id, "", ErrExpenseLocked)
+		}
+		return err
+	})
+}
+
+func (s *PostgresStore) DeleteTransactionByID(ctx context.Context, id int) error {
+	owner, args := ownerCondition(ctx, []interface{}{id})
+	return s.DB.InTenant(ctx, func(q database.Querier) error {
+		row := q.QueryRowContext(ctx, "UPDATE expenses SET deleted_at=now() WHERE id=$1 AND deleted_at IS NULL AND status NOT IN ('approved','reimbursed')"+owner+" RETURNING id", args...)
+		err := row.Scan(&id)
+		if err == sql.ErrNoRows {
+			return explainKindNoRows(ctx, q, id, "", ErrExpenseLocked)
+		}
+		return err
+	})
+}
+
+func (s *PostgresStore) SelectTransactions(ctx context.Context, q ExpenseQuery, each func(Expense) error) error {
+	if owner, scoped := ownerScope(ctx); scoped {
+		q.Filter.Owner = owner
+	}
+	query, args := buildSelectRows(q, transactionColumns)
+	found := false
+	err := s.selectRows(ctx, query, args, func(rows *sql.Rows) error {
+		var t Expense
+		if err := scanTransaction(rows, &t); err != nil {
+			return err
+		}
+		found = true
+		return each(t)
+	})
+	if err == nil && !found {
+		err = s.checkCursorRow(ctx, q)
+	}
+	return err
+}
+
+func buildSummarizeFlow(f ExpenseFilter, groupBy, currency string) (string, []interface{}) {
+	args := []interface{}{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	from := summaryRows(f, groupBy, currency, arg)
+	key := summaryGroupExprs[groupBy]
+	aggregates := ",currency,COALESCE(SUM(amount) FILTER (WHERE kind='income'),0),COALESCE(SUM(amount) FILTER (WHERE kind='expense'),0)"
+	if currency != "" {
+		aggregates += ",bool_or(missing_rate)"
+	}
+	return "SELECT " + key + aggregates + from +
+		" GROUP BY " + key + ",currency ORDER BY " + key + ` COLLATE "C",currency COLLATE "C";`, args
+}
+
+func (s *PostgresStore) SummarizeFlow(ctx context.Context, f ExpenseFilter, groupBy, currency string) ([]FlowGroup, error) {
+	if owner, scoped := ownerScope(ctx); scoped {
+		f.Owner = owner
+	}
+	query, args := buildSummarizeFlow(f, groupBy, currency)
+	groups := []FlowGroup{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var g FlowGroup
+			dest := []interface{}{&g.Key, &g.Currency, &g.Income, &g.Expenses}
+			if currency != "" {
+				dest = append(dest, &g.MissingRate)
+			}
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+			g.Net = g.Income.Sub(g.Expenses)
+			groups = append(groups, g)
+		}
+		return rows.Err()
+	})
+	return groups, err
+}
+
+// SaveRates upserts the rates one by one in a transaction, so a later rate
+// in the batch replaces an earlier one of the same pair and date.
+func (s *PostgresStore) SaveRates(ctx context.Context, rates []ExchangeRate) error {
+	return s.DB.InTenantTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(ctx, `
+	INSERT INTO exchange_rates (base,quote,rate_date,rate) VALUES ($1,$2,$3,$4)
+	ON CONFLICT (tenant_id,base,quote,rate_date) DO UPDATE SET rate=EXCLUDED.rate , updated_at=now();`)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		for _, r := range rates {
+			if _, err := stmt.ExecContext(ctx, r.Base, r.Quote, r.Date, r.Rate); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *PostgresStore) SelectRates(ctx context.Context, currency string) ([]ExchangeRate, error) {
+	query := "SELECT base,quote,rate_date,rate FROM exchange_rates"
+	args := []interface{}{}
+	if currency != "" {
+		query += " WHERE base=$1 OR quote=$1"
+		args = append(args, currency)
+	}
+	rates := []ExchangeRate{}
+	err := s.DB.InTenant(ctx, func(q database.Querier) error {
+		rows, err := q.QueryContext(ctx, query+" ORDER BY base,quote,rate_date", args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r ExchangeRate
+			var date time.Time
+			if err := rows.Scan(&r.Base, &r.Quote, &date, &r.Rate); err != nil {
+				return err
+			}
+			r.Date = date.Format("2006-01-02")
+			rates = append(rates, r)
+		}
+		return rows.Err()
+	})
+	return rates, err
 }
@@ -0,0 +1,147 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildSelectExpenses(t *testing.T) {
+	min := money.FromInt(500)
+	from := time.Date(2022, 12, 1, 0, 0, 0, 0, time.UTC)
+	accountID := 3
+	tests := []struct {
+		testname  string
+		query     ExpenseQuery
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{"No filter keeps id order", ExpenseQuery{},
+			"SELECT " + expenseColumns + " FROM expenses WHERE deleted_at IS NULL AND kind = 'expense' ORDER BY id;",
+			[]interface{}{}},
+		{"Filters become parameters", ExpenseQuery{Filter: ExpenseFilter{Tags: []string{"food"}, MatchAllTags: true, MinAmount: &min, Created: TimeRange{From: &from}, Text: "50%_off"}},
+			"SELECT " + expenseColumns + " FROM expenses WHERE deleted_at IS NULL AND kind = 'expense' AND tags @> $1 AND amount >= $2 AND created_at >= $3 AND (title ILIKE $4 OR note ILIKE $4) ORDER BY id;",
+			[]interface{}{pq.Array([]string{"food"}), min, from, `%50\%\_off%`}},
+		{"Default order pages by id", ExpenseQuery{AfterID: 7, Limit: 21},
+			"SELECT " + expenseColumns + " FROM expenses WHERE deleted_at IS NULL AND kind = 'expense' AND id > $1 ORDER BY id LIMIT $2;",
+			[]interface{}{7, 21}},
+		{"Custom order pages by cursor row", ExpenseQuery{Sort: []SortField{{Column: "amount"}, {Column: "created_at", Desc: true}}, AfterID: 7, Limit: 21},
+			"SELECT " + expenseColumns + " FROM expenses, (SELECT amount AS cursor_amount,created_at AS cursor_created_at,id AS cursor_id FROM expenses WHERE id=$1 AND deleted_at IS NULL) AS cursor_row" +
+				" WHERE deleted_at IS NULL AND kind = 'expense' AND ((amount > cursor_amount) OR (amount = cursor_amount AND created_at < cursor_created_at) OR (amount = cursor_amount AND created_at = cursor_created_at AND id > cursor_id))" +
+				" ORDER BY amount,created_at DESC,id LIMIT $2;",
+			[]interface{}{7, 21}},
+		{"Cursor row is the owner's", ExpenseQuery{Filter: ExpenseFilter{Owner: "alice"}, Sort: []SortField{{Column: "title"}}, AfterID: 7},
+			"SELECT " + expenseColumns + " FROM expenses, (SELECT title AS cursor_title,id AS cursor_id FROM expenses WHERE id=$2 AND deleted_at IS NULL AND owner_id=$3) AS cursor_row" +
+				" WHERE deleted_at IS NULL AND kind = 'expense' AND owner_id = $1 AND ((title > cursor_title) OR (title = cursor_title AND id > cursor_id))" +
+				" ORDER BY title,id;",
+			[]interface{}{"alice", 7, "alice"}},
+		{"Transactions of an account", ExpenseQuery{Filter: ExpenseFilter{Kinds: []string{KindIncome, KindTransfer}, AccountID: &accountID}},
+			"SELECT " + expenseColumns + " FROM expenses WHERE deleted_at IS NULL AND kind = ANY($1) AND (account_id = $2 OR to_account_id = $2) ORDER BY id;",
+			[]interface{}{pq.Array([]string{KindIncome, KindTransfer}), 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			query, args := buildSelectExpenses(tt.query)
+			assert.Equal(t, tt.wantQuery, query)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestBuildSummarizeExpenses(t *testing.T) {
+	aggregates := ",currency,COUNT(*),SUM(amount),ROUND(AVG(amount),4),MIN(amount),MAX(amount)"
+	tests := []struct {
+		groupBy   string
+		filter    ExpenseFilter
+		wantQuery string
+		wantArgs  []interface{}
+	}{
+		{GroupByCurrency, ExpenseFilter{},
+			"SELECT currency" + aggregates + ` FROM expenses WHERE deleted_at IS NULL AND kind = 'expense' GROUP BY currency,currency ORDER BY currency COLLATE "C",currency COLLATE "C";`,
+			[]interface{}{}},
+		{GroupByTag, ExpenseFilter{Owner: "alice", Tags: []string{"food"}},
+			"SELECT tag" + aggregates + ` FROM expenses, unnest(tags) AS tag WHERE deleted_at IS NULL AND kind = 'expense' AND owner_id = $1 AND tags && $2 GROUP BY tag,currency ORDER BY tag COLLATE "C",currency COLLATE "C";`,
+			[]interface{}{"alice", pq.Array([]string{"food"})}},
+		{GroupByWeek, ExpenseFilter{Status: StatusApproved},
+			"SELECT to_char(date_trunc('week',spent_at AT TIME ZONE 'UTC'),'YYYY-MM-DD')" + aggregates + " FROM expenses WHERE deleted_at IS NULL AND kind = 'expense' AND status = $1" +
+				" GROUP BY to_char(date_trunc('week',spent_at AT TIME ZONE 'UTC'),'YYYY-MM-DD'),currency" +
+				` ORDER BY to_char(date_trunc('week',spent_at AT TIME ZONE 'UTC'),'YYYY-MM-DD') COLLATE "C",currency COLLATE "C";`,
+			[]interface{}{StatusApproved}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.groupBy, func(t *testing.T) {
+			query, args := buildSummarizeExpenses(tt.filter, tt.groupBy, "")
+			assert.Equal(t, tt.wantQuery, query)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestBuildSummarizeFlow(t *testing.T) {
+	query, args := buildSummarizeFlow(ExpenseFilter{Kinds: flowKinds, Owner: "alice"}, GroupByMonth, "")
+	assert.Equal(t, "SELECT to_char(spent_at AT TIME ZONE 'UTC','YYYY-MM'),currency,"+
+		"COALESCE(SUM(amount) FILTER (WHERE kind='income'),0),COALESCE(SUM(amount) FILTER (WHERE kind='expense'),0)"+
+		" FROM expenses WHERE deleted_at IS NULL AND kind = ANY($1) AND owner_id = $2"+
+		" GROUP BY to_char(spent_at AT TIME ZONE 'UTC','YYYY-MM'),currency"+
+		` ORDER BY to_char(spent_at AT TIME ZONE 'UTC','YYYY-MM') COLLATE "C",currency COLLATE "C";`, query)
+	assert.Equal(t, []interface{}{pq.Array(flowKinds), "alice"}, args)
+}
+
+func TestBuildSummarizeConverted(t *testing.T) {
+	reported := func(c, where string) string {
+		day := "(expenses.spent_at AT TIME ZONE 'UTC')::date"
+		return "(SELECT spent_at,tags,kind," +
+			"COALESCE(converted,amount) AS amount," +
+			"CASE WHEN converted IS NULL THEN currency ELSE " + c + " END AS currency," +
+			"currency<>" + c + " AND converted IS NULL AS missing_rate" +
+			" FROM expenses LEFT JOIN LATERAL (SELECT rate_date,rate FROM (" +
+			"SELECT rate_date,rate,0 AS inverse FROM exchange_rates WHERE base=expenses.currency AND quote=" + c + " AND rate_date<=" + day +
+			" UNION ALL SELECT rate_date,ROUND(1/rate,8),1 FROM exchange_rates WHERE base=" + c + " AND quote=expenses.currency AND rate_date<=" + day +
+			") AS rates ORDER BY rate_date DESC,inverse LIMIT 1) AS rate ON true" +
+			", LATERAL (SELECT CASE WHEN abs(ROUND(amount*rate.rate,2))<=922337203685477.5807 THEN ROUND(amount*rate.rate,2) END AS converted) AS conversion" +
+			" WHERE " + where + ") AS expenses"
+	}
+
+	query, args := buildSummarizeExpenses(ExpenseFilter{Owner: "alice"}, GroupByTag, "THB")
+	assert.Equal(t, "SELECT tag,currency,COUNT(*),SUM(amount),ROUND(AVG(amount),4),MIN(amount),MAX(amount),bool_or(missing_rate)"+
+		" FROM "+reported("$2", "deleted_at IS NULL AND kind = 'expense' AND owner_id = $1")+", unnest(tags) AS tag"+
+		` GROUP BY tag,currency ORDER BY tag COLLATE "C",currency COLLATE "C";`, query)
+	assert.Equal(t, []interface{}{"alice", "THB"}, args)
+
+	query, args = buildSummarizeFlow(ExpenseFilter{Kinds: flowKinds, Owner: "alice"}, GroupByCurrency, "THB")
+	assert.Equal(t, "SELECT currency,currency,"+
+		"COALESCE(SUM(amount) FILTER (WHERE kind='income'),0),COALESCE(SUM(amount) FILTER (WHERE kind='expense'),0),bool_or(missing_rate)"+
+		" FROM "+reported("$3", "deleted_at IS NULL AND kind = ANY($1) AND owner_id = $2")+
+		` GROUP BY currency,currency ORDER BY currency COLLATE "C",currency COLLATE "C";`, query)
+	assert.Equal(t, []interface{}{pq.Array(flowKinds), "alice", "THB"}, args)
+}
+
+func TestPostgresCursorRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "bob"})
+	q := ExpenseQuery{Sort: []SortField{{Column: "title"}}, AfterID: 7, Limit: 21}
+
+	mock.ExpectPrepare("SELECT (.+) FROM expenses, \\(SELECT (.+) FROM expenses WHERE id=\\$2 AND deleted_at IS NULL AND owner_id=\\$3\\) AS cursor_row").
+		ExpectQuery().WithArgs("bob", 7, "bob", 21).
+		WillReturnRows(sqlmock.NewRows(strings.Split(expenseColumns, ",")))
+	mock.ExpectQuery("SELECT id FROM expenses WHERE id=\\$1 AND deleted_at IS NULL AND owner_id=\\$2").
+		WithArgs(7, "bob").WillReturnError(sql.ErrNoRows)
+	err = s.SelectExpenses(ctx, q, func(Expense) error { return nil })
+	assert.ErrorIs(t, err, errInvalidCursor)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
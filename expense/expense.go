@@ -1,11 +1,38 @@
 package expense
 
+import (
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+)
+
+// Expense.SpentAt is supplied by the caller and defaults to now, while
+// OwnerID, CreatedAt and UpdatedAt are always set by the store. Status and
+// StatusReason only change through a Transition. AccountID optionally names
+// the account the expense was paid from.
+//
+// Expenses share their table with income and transfers, told apart by Kind,
+// which is only set on the transactions API. A transfer moves Amount from
+// AccountID to ToAccountID.
+//
+// Converted is only set on responses that asked for a reporting currency.
 type Expense struct {
-	ID     int      `json:"id"`
-	Title  string   `json:"title"`
-	Amount float64  `json:"amount"`
-	Note   string   `json:"note"`
-	Tags   []string `json:"tags"`
+	ID           int          `json:"id"`
+	OwnerID      string       `json:"owner_id"`
+	Title        string       `json:"title"`
+	Amount       money.Amount `json:"amount"`
+	Currency     string       `json:"currency"`
+	Note         string       `json:"note"`
+	Tags         []string     `json:"tags"`
+	SpentAt      time.Time    `json:"spent_at"`
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	Status       string       `json:"status"`
+	StatusReason string       `json:"status_reason"`
+	AccountID    *int         `json:"account_id"`
+	Kind         string       `json:"kind,omitempty"`
+	ToAccountID  *int         `json:"to_account_id,omitempty"`
+	Converted    *Conversion  `json:"converted,omitempty"`
 }
 
 type Err struct {
@@ -0,0 +1,309 @@
+package expense
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// exportFlushEvery is how many rows are buffered before they are flushed to
+// the client.
+const exportFlushEvery = 500
+
+var exportColumns = []string{
+	"id", "owner_id", "title", "amount", "currency", "note", "tags",
+	"spent_at", "created_at", "updated_at", "status", "status_reason",
+}
+
+// exporter writes one expense at a time to w. Flush hands buffered rows to
+// w; Close finishes the file and is not called when the export fails part
+// way.
+type exporter interface {
+	Write(ex Expense) error
+	Flush() error
+	Close() error
+}
+
+var exportFormats = map[string]struct {
+	contentType string
+	open        func(w io.Writer) (exporter, error)
+}{
+	"csv":   {"text/csv; charset=utf-8", newCSVExporter},
+	"jsonl": {"application/x-ndjson", newJSONLExporter},
+	"xlsx":  {"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", newXLSXExporter},
+}
+
+// ExportExpensesHandler streams every expense matching the list filters in
+// the requested format, writing each row as the store yields it.
+func (h Handler) ExportExpensesHandler(c echo.Context) error {
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "csv"
+	}
+	f, ok := exportFormats[format]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid format"})
+	}
+	filter, sort, ifErr, respErr := getFilterParams(c)
+	if ifErr {
+		return respErr
+	}
+
+	res := c.Response()
+	var out exporter
+	rows := 0
+	// the response is only committed by the first row, so a query that fails
+	// straight away still gets a proper error status
+	start := func() error {
+		header := res.Header()
+		header.Set(echo.HeaderContentType, f.contentType)
+		header.Set(echo.HeaderContentDisposition, `attachment; filename="expenses.`+format+`"`)
+		res.WriteHeader(http.StatusOK)
+		var err error
+		out, err = f.open(res)
+		return err
+	}
+	err := h.Storage.SelectExpenses(c.Request().Context(), ExpenseQuery{Filter: filter, Sort: sort}, func(ex Expense) error {
+		if out == nil {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := out.Write(ex); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushEvery == 0 {
+			if err := out.Flush(); err != nil {
+				return err
+			}
+			res.Flush()
+		}
+		return nil
+	})
+	if err != nil && out == nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	if err != nil {
+		// too late for an error status; the truncated file is all we can send
+		c.Logger().Error("export failed after ", rows, " rows : ", err)
+		return nil
+	}
+	if out == nil {
+		if err := start(); err != nil {
+			return err
+		}
+	}
+	return out.Close()
+}
+
+func exportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// exportRecord is ex as the strings of exportColumns.
+func exportRecord(ex Expense) []string {
+	return []string{
+		strconv.Itoa(ex.ID), ex.OwnerID, ex.Title, ex.Amount.String(), ex.Currency, ex.Note,
+		strings.Join(ex.Tags, ","), exportTime(ex.SpentAt), exportTime(ex.CreatedAt),
+		exportTime(ex.UpdatedAt), ex.Status, ex.StatusReason,
+	}
+}
+
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w io.Writer) (exporter, error) {
+	e := csvExporter{w: csv.NewWriter(w)}
+	return e, e.w.Write(exportColumns)
+}
+
+// escapeFormula keeps spreadsheets from evaluating user text as a formula.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func (e csvExporter) Write(ex Expense) error {
+	record := exportRecord(ex)
+	for _, i := range []int{1, 2, 5, 6, 11} {
+		record[i] = escapeFormula(record[i])
+	}
+	return e.w.Write(record)
+}
+
+func (e csvExporter) Flush() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e csvExporter) Close() error {
+	return e.Flush()
+}
+
+type jsonlExporter struct {
+	enc *json.Encoder
+}
+
+func newJSONLExporter(w io.Writer) (exporter, error) {
+	return jsonlExporter{enc: json.NewEncoder(w)}, nil
+}
+
+func (e jsonlExporter) Write(ex Expense) error {
+	return e.enc.Encode(ex)
+}
+
+func (e jsonlExporter) Flush() error {
+	return nil
+}
+
+func (e jsonlExporter) Close() error {
+	return nil
+}
+
+// xlsxExporter writes a single sheet workbook. The sheet is the last entry
+// of the zip so its rows can be streamed; strings are inline rather than
+// shared, since a shared string table would have to come first.
+type xlsxExporter struct {
+	zw    *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+var xlsxParts = []struct{ name, body string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="Expenses" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// style 1 is the built-in "m/d/yy h:mm" date format
+	{"xl/styles.xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="1"><font><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="1"><fill><patternFill patternType="none"/></fill></fills>` +
+		`<borders count="1"><border/></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/>` +
+		`<xf numFmtId="22" fontId="0" fillId="0" borderId="0" xfId="0" applyNumberFormat="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+func newXLSXExporter(w io.Writer) (exporter, error) {
+	zw := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		pw, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(pw, part.body); err != nil {
+			return nil, err
+		}
+	}
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e := &xlsxExporter{zw: zw, sheet: bufio.NewWriter(sheet)}
+	e.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	e.startRow()
+	for _, name := range exportColumns {
+		e.text(name)
+	}
+	return e, e.endRow()
+}
+
+func (e *xlsxExporter) startRow() {
+	e.row++
+	e.sheet.WriteString(`<row r="` + strconv.Itoa(e.row) + `">`)
+}
+
+func (e *xlsxExporter) endRow() error {
+	_, err := e.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (e *xlsxExporter) text(s string) {
+	e.sheet.WriteString(`<c t="inlineStr"><is><t xml:space="preserve">`)
+	xml.EscapeText(e.sheet, []byte(s))
+	e.sheet.WriteString(`</t></is></c>`)
+}
+
+func (e *xlsxExporter) number(s string) {
+	e.sheet.WriteString(`<c><v>` + s + `</v></c>`)
+}
+
+// excelEpoch is day zero of the 1900 date system, as Excel counts it.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+func (e *xlsxExporter) date(t time.Time) {
+	if t.IsZero() {
+		e.sheet.WriteString(`<c/>`)
+		return
+	}
+	days := float64(t.UTC().Sub(excelEpoch)) / float64(24*time.Hour)
+	e.sheet.WriteString(`<c s="1"><v>` + strconv.FormatFloat(days, 'f', -1, 64) + `</v></c>`)
+}
+
+func (e *xlsxExporter) Write(ex Expense) error {
+	e.startRow()
+	e.number(strconv.Itoa(ex.ID))
+	e.text(ex.OwnerID)
+	e.text(ex.Title)
+	e.number(ex.Amount.String())
+	e.text(ex.Currency)
+	e.text(ex.Note)
+	e.text(strings.Join(ex.Tags, ","))
+	e.date(ex.SpentAt)
+	e.date(ex.CreatedAt)
+	e.date(ex.UpdatedAt)
+	e.text(ex.Status)
+	e.text(ex.StatusReason)
+	return e.endRow()
+}
+
+func (e *xlsxExporter) Flush() error {
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zw.Flush()
+}
+
+func (e *xlsxExporter) Close() error {
+	if _, err := e.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zw.Close()
+}
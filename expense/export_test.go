@@ -0,0 +1,133 @@
+//go:build unit
+
+package expense
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportExpenses(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	for _, ex := range []Expense{
+		{Title: "taxi", Amount: money.MustParse("120.5"), Note: "client visit", Tags: []string{"travel", "client"}, Currency: "THB", SpentAt: testTime},
+		{Title: "=HYPERLINK(\"http://evil\")", Amount: money.MustParse("80"), Note: "team", Tags: []string{"food"}, Currency: "THB", SpentAt: testTime},
+	} {
+		ex := ex
+		store.InsertExpense(context.Background(), &ex)
+	}
+	h := Handler{Storage: store}
+	call := func(query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/export"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.ExportExpensesHandler(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("CSV is the default format", func(t *testing.T) {
+		rec := call("")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+		assert.Equal(t, `attachment; filename="expenses.csv"`, rec.Header().Get(echo.HeaderContentDisposition))
+		records, err := csv.NewReader(rec.Body).ReadAll()
+		if assert.NoError(t, err) && assert.Equal(t, 3, len(records)) {
+			assert.Equal(t, exportColumns, records[0])
+			assert.Equal(t, []string{"1", "default", "taxi", "120.5", "THB", "client visit", "travel,client",
+				"2022-12-01T09:30:00Z"}, records[1][:8])
+			assert.Equal(t, `'=HYPERLINK("http://evil")`, records[2][2])
+		}
+	})
+
+	t.Run("JSON Lines honors the list filters", func(t *testing.T) {
+		rec := call("?format=jsonl&q=taxi")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+		if assert.Equal(t, 1, len(lines)) {
+			got := Expense{}
+			assert.NoError(t, json.Unmarshal([]byte(lines[0]), &got))
+			assert.Equal(t, "taxi", got.Title)
+		}
+	})
+
+	t.Run("XLSX is a workbook with one row per expense", func(t *testing.T) {
+		rec := call("?format=xlsx&sort=-id")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		zr, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+		if !assert.NoError(t, err) {
+			return
+		}
+		parts := map[string][]byte{}
+		for _, f := range zr.File {
+			r, _ := f.Open()
+			parts[f.Name], _ = io.ReadAll(r)
+			r.Close()
+		}
+		for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+			assert.NoError(t, xml.Unmarshal(parts[name], new(interface{})), name)
+		}
+		sheet := struct {
+			Rows []struct {
+				Cells []struct {
+					Style  string `xml:"s,attr"`
+					Value  string `xml:"v"`
+					Inline string `xml:"is>t"`
+				} `xml:"c"`
+			} `xml:"sheetData>row"`
+		}{}
+		if !assert.NoError(t, xml.Unmarshal(parts["xl/worksheets/sheet1.xml"], &sheet)) || !assert.Equal(t, 3, len(sheet.Rows)) {
+			return
+		}
+		assert.Equal(t, "id", sheet.Rows[0].Cells[0].Inline)
+		taxi := sheet.Rows[2].Cells
+		assert.Equal(t, "1", taxi[0].Value)
+		assert.Equal(t, "taxi", taxi[2].Inline)
+		assert.Equal(t, "120.5", taxi[3].Value)
+		assert.Equal(t, "1", taxi[7].Style)
+		assert.Equal(t, "44896.395833333336", taxi[7].Value)
+		assert.Equal(t, `=HYPERLINK("http://evil")`, sheet.Rows[1].Cells[2].Inline)
+	})
+
+	t.Run("No rows still writes the header", func(t *testing.T) {
+		rec := call("?status=approved")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, strings.Join(exportColumns, ",")+"\n", rec.Body.String())
+	})
+
+	t.Run("Invalid format Return HTTP Status Bad Request", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, call("?format=pdf").Code)
+		assert.Equal(t, http.StatusBadRequest, call("?status=lost").Code)
+	})
+}
+
+func TestExportExpensesStoreError(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	mock.ExpectPrepare("SELECT").WillReturnError(assert.AnError)
+	h := Handler{Storage: NewPostgresStore(&database.DB{Database: db})}
+	req := httptest.NewRequest(http.MethodGet, "/expenses/export?format=xlsx", nil)
+	rec := httptest.NewRecorder()
+
+	assert.NoError(t, h.ExportExpensesHandler(echo.New().NewContext(req, rec)))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	assert.Equal(t, echo.MIMEApplicationJSONCharsetUTF8, rec.Header().Get(echo.HeaderContentType))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
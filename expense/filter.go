@@ -0,0 +1,186 @@
+package expense
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+// TimeRange is inclusive of From and exclusive of To; nil bounds are open.
+type TimeRange struct {
+	From *time.Time
+	To   *time.Time
+}
+
+func (r TimeRange) Contains(t time.Time) bool {
+	if r.From != nil && t.Before(*r.From) {
+		return false
+	}
+	if r.To != nil && !t.Before(*r.To) {
+		return false
+	}
+	return true
+}
+
+// ExpenseFilter.Owner only narrows an admin's or approver's results; stores
+// always restrict other callers to their own rows. Without Kinds only
+// expenses match, and AccountID matches either side of a transfer.
+type ExpenseFilter struct {
+	Owner        string
+	Status       string
+	AccountID    *int
+	Kinds        []string
+	Tags         []string
+	MatchAllTags bool
+	MinAmount    *money.Amount
+	MaxAmount    *money.Amount
+	Spent        TimeRange
+	Created      TimeRange
+	Updated      TimeRange
+	Text         string
+}
+
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// sortColumns whitelists the columns GET /expenses may be sorted by.
+var sortColumns = map[string]bool{
+	"id":         true,
+	"title":      true,
+	"amount":     true,
+	"spent_at":   true,
+	"created_at": true,
+	"updated_at": true,
+}
+
+func parseSort(s string) ([]SortField, bool) {
+	fields := []SortField{}
+	if s == "" {
+		return fields, true
+	}
+	for _, name := range strings.Split(s, ",") {
+		f := SortField{Column: strings.TrimSpace(name)}
+		if strings.HasPrefix(f.Column, "-") {
+			f.Column = f.Column[1:]
+			f.Desc = true
+		}
+		if !sortColumns[f.Column] {
+			return nil, false
+		}
+		fields = append(fields, f)
+	}
+	return fields, true
+}
+
+func sortKey(fields []SortField) string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.Column
+		if f.Desc {
+			names[i] = "-" + f.Column
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+func parseAmount(s string) (*money.Amount, bool) {
+	if s == "" {
+		return nil, true
+	}
+	v, err := money.Parse(s)
+	if err != nil {
+		return nil, false
+	}
+	return &v, true
+}
+
+// parseDateParam accepts RFC 3339 timestamps or plain dates, which are taken
+// as midnight UTC.
+func parseDateParam(s string) (*time.Time, bool) {
+	if s == "" {
+		return nil, true
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, true
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, false
+	}
+	return &t, true
+}
+
+func parseTags(values []string) []string {
+	tags := []string{}
+	for _, v := range values {
+		for _, tag := range strings.Split(v, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+func getFilterParams(c echo.Context) (ExpenseFilter, []SortField, bool, error) {
+	f := ExpenseFilter{
+		Owner:  strings.TrimSpace(c.QueryParam("owner")),
+		Status: c.QueryParam("status"),
+		Tags:   parseTags(c.QueryParams()["tag"]),
+		Text:   strings.TrimSpace(c.QueryParam("q")),
+	}
+	invalid := func(msg string) (ExpenseFilter, []SortField, bool, error) {
+		return f, nil, true, c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	if f.Status != "" && !isStatus(f.Status) {
+		return invalid("Invalid status")
+	}
+	switch c.QueryParam("tag_match") {
+	case "", "any":
+	case "all":
+		f.MatchAllTags = true
+	default:
+		return invalid("Invalid tag_match")
+	}
+	if s := c.QueryParam("account_id"); s != "" {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return invalid("Invalid account_id")
+		}
+		f.AccountID = &id
+	}
+	var ok bool
+	if f.MinAmount, ok = parseAmount(c.QueryParam("min_amount")); !ok {
+		return invalid("Invalid min_amount")
+	}
+	if f.MaxAmount, ok = parseAmount(c.QueryParam("max_amount")); !ok {
+		return invalid("Invalid max_amount")
+	}
+	ranges := []struct {
+		name string
+		r    *TimeRange
+	}{
+		{"spent", &f.Spent},
+		{"created", &f.Created},
+		{"updated", &f.Updated},
+	}
+	for _, tr := range ranges {
+		if tr.r.From, ok = parseDateParam(c.QueryParam(tr.name + "_from")); !ok {
+			return invalid("Invalid " + tr.name + "_from")
+		}
+		if tr.r.To, ok = parseDateParam(c.QueryParam(tr.name + "_to")); !ok {
+			return invalid("Invalid " + tr.name + "_to")
+		}
+	}
+	sort, ok := parseSort(c.QueryParam("sort"))
+	if !ok {
+		return invalid("Invalid sort")
+	}
+	return f, sort, false, nil
+}
@@ -1,17 +1,37 @@
 package expense
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/Temwalker/assessment/blob"
 	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
 	"github.com/labstack/echo/v4"
 )
 
 type Handler struct {
-	Storage *database.DB
+	Storage      ExpenseStore
+	Attachments  AttachmentStore
+	Budgets      BudgetStore
+	Recurring    RecurringStore
+	Splits       SplitStore
+	Accounts     AccountStore
+	Transactions TransactionStore
+	Rates        RateStore
+	Alerts       AlertSink
+	Blobs        blob.Store
+	// MaxAttachmentSize defaults to DefaultMaxAttachmentSize.
+	MaxAttachmentSize int64
+	// ReportingCurrency is what list and summary amounts are converted to
+	// when the request names no report_currency; empty leaves them as is.
+	ReportingCurrency string
 }
 
 func NewHandler() Handler {
@@ -19,17 +39,26 @@ func NewHandler() Handler {
 	if err != nil {
 		log.Panic("Can't connect to DB : ", err)
 	}
-	err = CreateExpenseTable(db)
+	err = db.Migrate(context.Background())
 	if err != nil {
-		log.Panic("Can't create table : ", err)
+		log.Panic("Can't migrate DB : ", err)
 	}
+	store := NewPostgresStore(db)
 	return Handler{
-		Storage: db,
+		Storage:      store,
+		Attachments:  store,
+		Budgets:      store,
+		Recurring:    store,
+		Splits:       store,
+		Accounts:     store,
+		Transactions: store,
+		Rates:        store,
+		Alerts:       LogAlerts{},
 	}
 }
 
 func (h Handler) Close() error {
-	err := h.Storage.CloseDB()
+	err := h.Storage.Close()
 	if err != nil {
 		log.Println("Can't close DB Connection  : ", err)
 	}
@@ -46,12 +75,38 @@ func getIDParam(c echo.Context) (int, bool, error) {
 
 func bindRequestBody(c echo.Context, ex *Expense) (bool, error) {
 	err := c.Bind(ex)
-	if err != nil || checkEmptyField(*ex) {
+	if err != nil {
 		return true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
 	}
+	return validateExpense(c, ex)
+}
+
+// validateExpense also drops the transaction fields, which the expenses API
+// does not take.
+func validateExpense(c echo.Context, ex *Expense) (bool, error) {
+	ex.Kind, ex.ToAccountID = "", nil
+	if msg := checkExpense(ex); msg != "" {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
 	return false, nil
 }
 
+// checkExpense normalizes ex.Currency and returns why ex is invalid, or ""
+// when it is valid.
+func checkExpense(ex *Expense) string {
+	if checkEmptyField(*ex) {
+		return "Invalid request body"
+	}
+	ex.Currency = money.NormalizeCurrency(ex.Currency)
+	if !money.IsCurrency(ex.Currency) {
+		return "Invalid currency"
+	}
+	if !ex.Amount.FitsCurrency(ex.Currency) {
+		return "Invalid amount"
+	}
+	return ""
+}
+
 func checkEmptyField(ex Expense) bool {
 	//check only string field
 	if len(ex.Title) == 0 {
@@ -70,8 +125,9 @@ func returnExpenseByID(err error, c echo.Context, ex Expense) error {
 	if err == nil {
 		return c.JSON(http.StatusOK, ex)
 	}
+	// another owner's expense is reported exactly like a missing one
 	if err.Error() == sql.ErrNoRows.Error() {
-		return c.JSON(http.StatusBadRequest, Err{Msg: "Expense not found"})
+		return c.JSON(http.StatusNotFound, Err{Msg: "Expense not found"})
 	}
 	return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
 }
@@ -96,7 +152,13 @@ func (h Handler) CreateExpenseHandler(c echo.Context) error {
 	if ifErr {
 		return respErr
 	}
-	err := InsertExpense(h.Storage, &ex)
+	if ifErr, respErr := h.checkAccount(c, ex); ifErr {
+		return respErr
+	}
+	err := h.Storage.InsertExpense(c.Request().Context(), &ex)
+	if err == nil {
+		h.checkBudgets(c, ex)
+	}
 	return returnExpenseCreated(err, c, ex)
 }
 
@@ -106,7 +168,7 @@ func (h Handler) GetExpenseByIdHandler(c echo.Context) error {
 		return respErr
 	}
 	ex := Expense{}
-	err := SelectExpenseByID(h.Storage, intVar, &ex)
+	err := h.Storage.SelectExpenseByID(c.Request().Context(), intVar, &ex)
 	return returnExpenseByID(err, c, ex)
 }
 
@@ -120,12 +182,188 @@ func (h Handler) UpdateExpenseByIDHandler(c echo.Context) error {
 	if ifErr {
 		return respErr
 	}
-	err := UpdateExpenseByID(h.Storage, intVar, &ex)
+	if ifErr, respErr := h.splitConflict(c, intVar, ex); ifErr {
+		return respErr
+	}
+	if ifErr, respErr := h.checkAccount(c, ex); ifErr {
+		return respErr
+	}
+	err := h.Storage.UpdateExpenseByID(c.Request().Context(), intVar, &ex)
+	if errors.Is(err, ErrExpenseLocked) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	if err == nil {
+		h.checkBudgets(c, ex)
+	}
 	return returnExpenseByID(err, c, ex)
 }
 
 func (h Handler) GetAllExpensesHandler(c echo.Context) error {
+	q, paged, ifErr, respErr := getListParams(c)
+	if ifErr {
+		return respErr
+	}
+	currency, ifErr, respErr := h.getReportCurrency(c)
+	if ifErr {
+		return respErr
+	}
+	limit := q.Limit
+	if paged {
+		q.Limit++
+	}
+	ctx := c.Request().Context()
 	expenses := []Expense{}
-	err := SelectAllExpenses(h.Storage, &expenses)
-	return returnExpensesList(err, c, expenses)
+	err := h.Storage.SelectExpenses(ctx, q, func(ex Expense) error {
+		expenses = append(expenses, ex)
+		return nil
+	})
+	if errors.Is(err, errInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid cursor"})
+	}
+	if err == nil {
+		err = convertExpenses(ctx, h.Rates, currency, expenses)
+	}
+	if err != nil || !paged {
+		return returnExpensesList(err, c, expenses)
+	}
+	page := ExpensePage{Expenses: expenses}
+	if len(expenses) > limit {
+		page.Expenses = expenses[:limit]
+		page.NextCursor = encodeCursor(expenseCursor{ID: page.Expenses[limit-1].ID, Sort: sortKey(q.Sort)})
+		c.Response().Header().Set("Link", nextPageLink(c, page.NextCursor))
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func returnExpenseDeleted(err error, c echo.Context) error {
+	if err == nil {
+		return c.NoContent(http.StatusNoContent)
+	}
+	if errors.Is(err, ErrExpenseLocked) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be deleted"})
+	}
+	if err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Expense not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+}
+
+func (h Handler) DeleteExpenseByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	err := h.Storage.DeleteExpenseByID(c.Request().Context(), intVar)
+	return returnExpenseDeleted(err, c)
+}
+
+func (h Handler) RestoreExpenseByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ex := Expense{}
+	err := h.Storage.RestoreExpenseByID(c.Request().Context(), intVar, &ex)
+	return returnExpenseByID(err, c, ex)
+}
+
+func returnPatchError(err error, c echo.Context) error {
+	if errors.Is(err, errUnsupportedPatch) {
+		return c.JSON(http.StatusUnsupportedMediaType, Err{Msg: "Unsupported patch content type"})
+	}
+	if errors.Is(err, errPatchTestFailed) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Patch test failed"})
+	}
+	return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid patch document"})
+}
+
+func (h Handler) PatchExpenseByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ctx := c.Request().Context()
+	ex := Expense{}
+	err := h.Storage.SelectExpenseByID(ctx, intVar, &ex)
+	if err != nil {
+		return returnExpenseByID(err, c, ex)
+	}
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxPatchSize)
+	patch, err := io.ReadAll(c.Request().Body)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Msg: "Patch document is too large"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid patch document"})
+	}
+	ex, err = applyPatch(c.Request().Header.Get(echo.HeaderContentType), ex, patch)
+	if err != nil {
+		return returnPatchError(err, c)
+	}
+	ifErr, respErr = validateExpense(c, &ex)
+	if ifErr {
+		return respErr
+	}
+	if ifErr, respErr := h.splitConflict(c, intVar, ex); ifErr {
+		return respErr
+	}
+	if ifErr, respErr := h.checkAccount(c, ex); ifErr {
+		return respErr
+	}
+	err = h.Storage.UpdateExpenseByID(ctx, intVar, &ex)
+	if errors.Is(err, ErrExpenseLocked) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	if err == nil {
+		h.checkBudgets(c, ex)
+	}
+	return returnExpenseByID(err, c, ex)
+}
+
+type transitionRequest struct {
+	Reason string `json:"reason"`
+}
+
+func (h Handler) transitionExpense(c echo.Context, action string) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	req := transitionRequest{}
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if action == "reject" && req.Reason == "" {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Reason is required"})
+	}
+	t, _ := NewTransition(action, req.Reason)
+	ex := Expense{}
+	err := h.Storage.TransitionExpenseByID(c.Request().Context(), intVar, t, &ex)
+	if errors.Is(err, ErrIllegalTransition) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Expense can not be " + t.To + " from its current status"})
+	}
+	if err != nil && err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Expense not found"})
+	}
+	return returnExpenseByID(err, c, ex)
+}
+
+func (h Handler) SubmitExpenseHandler(c echo.Context) error {
+	return h.transitionExpense(c, "submit")
+}
+
+func (h Handler) ApproveExpenseHandler(c echo.Context) error {
+	return h.transitionExpense(c, "approve")
+}
+
+func (h Handler) RejectExpenseHandler(c echo.Context) error {
+	return h.transitionExpense(c, "reject")
+}
+
+func (h Handler) ReimburseExpenseHandler(c echo.Context) error {
+	return h.transitionExpense(c, "reimburse")
 }
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/Temwalker/assessment/money"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -141,7 +142,7 @@ func TestDBGetExpenseByID(t *testing.T) {
 		want         interface{}
 	}{
 		{"Get Expense By ID Return HTTP OK and Query Expense", strconv.Itoa(seed.ID), false, http.StatusOK, seed},
-		{"Get Expense By ID but not found Return HTTP Status Bad Request", "0", false, http.StatusBadRequest, Err{"Expense not found"}},
+		{"Get Expense By ID but not found Return HTTP Status Not Found", "0", false, http.StatusNotFound, Err{"Expense not found"}},
 		{"Get Expense By ID but DB close Return HTTP Internal Error", "1", true, http.StatusInternalServerError, Err{"Internal error"}},
 	}
 	for _, tt := range tests {
@@ -176,11 +177,16 @@ func TestDBUpdateExpenseByID(t *testing.T) {
 		t.Fatal("can't seed expense : ", err)
 	}
 	wantOK := Expense{
-		ID:     seed.ID,
-		Title:  "apple smoothie",
-		Amount: 89,
-		Note:   "no discount",
-		Tags:   []string{"beverage"},
+		ID:        seed.ID,
+		Title:     "apple smoothie",
+		Amount:    money.FromInt(89),
+		Currency:  "THB",
+		Note:      "no discount",
+		Tags:      []string{"beverage"},
+		OwnerID:   seed.OwnerID,
+		SpentAt:   seed.SpentAt,
+		CreatedAt: seed.CreatedAt,
+		Status:    seed.Status,
 	}
 	e := echo.New()
 	tests := []struct {
@@ -198,13 +204,13 @@ func TestDBUpdateExpenseByID(t *testing.T) {
 			"amount": 89,
 			"note": "no discount", 
 			"tags": ["beverage"]}`, false, http.StatusOK, wantOK},
-		{"Update Expense By ID but not found Return HTTP Status Bad Request", strconv.Itoa(0),
+		{"Update Expense By ID but not found Return HTTP Status Not Found", strconv.Itoa(0),
 			`{
 			"id": ` + strconv.Itoa(0) + `,
 			"title": "apple smoothie",
 			"amount": 89,
 			"note": "no discount", 
-			"tags": ["beverage"]}`, false, http.StatusBadRequest, Err{"Expense not found"}},
+			"tags": ["beverage"]}`, false, http.StatusNotFound, Err{"Expense not found"}},
 		{"Update Expense By ID but DB close Return HTTP Internal Error", strconv.Itoa(seed.ID),
 			`{
 			"id": ` + strconv.Itoa(seed.ID) + `,
@@ -233,6 +239,12 @@ func TestDBUpdateExpenseByID(t *testing.T) {
 			}
 
 			err = h.UpdateExpenseByIDHandler(c)
+			if want, ok := tt.want.(Expense); ok {
+				got := Expense{}
+				json.Unmarshal(rec.Body.Bytes(), &got)
+				want.UpdatedAt = got.UpdatedAt
+				expected, _ = json.Marshal(want)
+			}
 			if assert.NoError(t, err) {
 				assert.Equal(t, tt.httpStatus, rec.Code)
 				assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
@@ -4,6 +4,7 @@ package expense
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"encoding/json"
@@ -12,34 +13,72 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
 	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 )
 
+func expectMigrated(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(driver.ResultNoRows)
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations (.+)").WillReturnResult(driver.ResultNoRows)
+	rows := sqlmock.NewRows([]string{"version"})
+	migrations, _ := database.Migrations()
+	for _, m := range migrations {
+		rows.AddRow(m.Version)
+	}
+	mock.ExpectQuery("SELECT version FROM schema_migrations").WillReturnRows(rows)
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(driver.ResultNoRows)
+}
+
+var testTime = time.Date(2022, 12, 1, 9, 30, 0, 0, time.UTC)
+
+func expenseRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "owner_id", "title", "amount", "note", "tags", "currency", "spent_at", "created_at", "updated_at", "status", "status_reason", "account_id"})
+}
+
+// stored fills in the fields a PostgresStore sets, using testTime for every
+// timestamp.
+func stored(ex Expense) Expense {
+	ex.OwnerID = auth.DefaultSubject
+	ex.SpentAt, ex.CreatedAt, ex.UpdatedAt = testTime, testTime, testTime
+	ex.Status = StatusDraft
+	return ex
+}
+
+// withoutStoreFields clears the owner, timestamps and status a MemoryStore
+// sets.
+func withoutStoreFields(ex Expense) Expense {
+	ex.OwnerID, ex.Status = "", ""
+	ex.SpentAt, ex.CreatedAt, ex.UpdatedAt = time.Time{}, time.Time{}, time.Time{}
+	return ex
+}
+
 func TestCreateHandler(t *testing.T) {
-	t.Run("Create Handler Success (DB Connnection OK , Create Table OK)", func(t *testing.T) {
+	t.Run("Create Handler Success (DB Connnection OK , Migrate OK)", func(t *testing.T) {
 		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		mock.ExpectPing().WillReturnError(nil)
-		mock.ExpectExec("CREATE TABLE IF NOT EXISTS expenses (.+)").WillReturnResult(driver.ResultNoRows)
+		expectMigrated(mock)
 		d, _ := database.GetDB()
 		d.Database = db
 		assert.NotPanics(t, func() { NewHandler() })
 	})
 
-	t.Run("Create Handler but handler can not Create Table Should Panic", func(t *testing.T) {
+	t.Run("Create Handler but handler can not Migrate DB Should Panic", func(t *testing.T) {
 		db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		mock.ExpectPing().WillReturnError(nil)
-		mock.ExpectExec("CREATE TABLE IF NOT EXISTS expenses (.+)").WillReturnError(sql.ErrConnDone)
+		mock.ExpectExec("SELECT pg_advisory_lock").WillReturnError(sql.ErrConnDone)
 		d, _ := database.GetDB()
 		d.Database = db
 		assert.Panics(t, func() { NewHandler() })
@@ -57,7 +96,7 @@ func TestCloseHandler(t *testing.T) {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		mock.ExpectPing().WillReturnError(nil)
-		mock.ExpectExec("CREATE TABLE IF NOT EXISTS expenses (.+)").WillReturnResult(driver.ResultNoRows)
+		expectMigrated(mock)
 		mock.ExpectClose().WillReturnError(nil)
 		d, _ := database.GetDB()
 		d.Database = db
@@ -71,7 +110,7 @@ func TestCloseHandler(t *testing.T) {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		mock.ExpectPing().WillReturnError(nil)
-		mock.ExpectExec("CREATE TABLE IF NOT EXISTS expenses (.+)").WillReturnResult(driver.ResultNoRows)
+		expectMigrated(mock)
 		mock.ExpectClose().WillReturnError(assert.AnError)
 		d, _ := database.GetDB()
 		d.Database = db
@@ -85,11 +124,17 @@ func TestCloseHandler(t *testing.T) {
 func TestCreateExpense(t *testing.T) {
 	t.Run("Create Expense Return HTTP StatusCreated and Created Expense", func(t *testing.T) {
 		want := Expense{
-			ID:     1,
-			Title:  "strawberry smoothie",
-			Amount: 79,
-			Note:   "night market promotion discount 10 bath",
-			Tags:   []string{"food", "beverage"},
+			ID:        1,
+			Title:     "strawberry smoothie",
+			Amount:    money.FromInt(79),
+			Currency:  "THB",
+			Note:      "night market promotion discount 10 bath",
+			Tags:      []string{"food", "beverage"},
+			OwnerID:   auth.DefaultSubject,
+			SpentAt:   testTime,
+			CreatedAt: testTime,
+			UpdatedAt: testTime,
+			Status:    StatusDraft,
 		}
 		expected, _ := json.Marshal(want)
 		e := echo.New()
@@ -109,10 +154,10 @@ func TestCreateExpense(t *testing.T) {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		mock.ExpectQuery("INSERT INTO expenses (.+) RETURNING id").
-			WithArgs(want.Title, want.Amount, want.Note, pq.Array(&want.Tags)).
-			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+			WithArgs(want.Title, want.Amount, want.Note, pq.Array(&want.Tags), want.Currency, nil, want.OwnerID, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "spent_at", "created_at", "updated_at"}).AddRow(1, testTime, testTime, testTime))
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.CreateExpenseHandler(c)
@@ -135,7 +180,7 @@ func TestCreateExpense(t *testing.T) {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.CreateExpenseHandler(c)
@@ -153,7 +198,7 @@ func TestCreateExpense(t *testing.T) {
 		c := e.NewContext(req, rec)
 
 		h := Handler{
-			Storage: &database.DB{},
+			Storage: NewMemoryStore(),
 		}
 
 		err := h.CreateExpenseHandler(c)
@@ -185,7 +230,7 @@ func TestCreateExpense(t *testing.T) {
 		mock.ExpectQuery("INSERT INTO expenses (.+) RETURNING id").
 			WillReturnError(sql.ErrConnDone)
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.CreateExpenseHandler(c)
@@ -203,13 +248,14 @@ func TestGetExpenseByID(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
 	req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
 	t.Run("Get Expense By ID Return HTTP OK and Query Expense", func(t *testing.T) {
-		want := Expense{
-			ID:     1,
-			Title:  "strawberry smoothie",
-			Amount: 79,
-			Note:   "night market promotion discount 10 bath",
-			Tags:   []string{"food", "beverage"},
-		}
+		want := stored(Expense{
+			ID:       1,
+			Title:    "strawberry smoothie",
+			Amount:   money.FromInt(79),
+			Currency: "THB",
+			Note:     "night market promotion discount 10 bath",
+			Tags:     []string{"food", "beverage"},
+		})
 		expected, _ := json.Marshal(want)
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
@@ -221,12 +267,12 @@ func TestGetExpenseByID(t *testing.T) {
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
-		mock.ExpectPrepare("SELECT id,title,amount,note,tags FROM expenses").
+		mock.ExpectPrepare("SELECT (.+) FROM expenses where id=\\$1").
 			ExpectQuery().WithArgs(1).
-			WillReturnRows(sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).AddRow(want.ID, want.Title, want.Amount, want.Note, pq.Array(&want.Tags)))
+			WillReturnRows(expenseRows().AddRow(want.ID, "default", want.Title, want.Amount.String(), want.Note, pq.Array(&want.Tags), want.Currency, testTime, testTime, testTime, "draft", "", nil))
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetExpenseByIdHandler(c)
@@ -247,7 +293,7 @@ func TestGetExpenseByID(t *testing.T) {
 		c.SetParamValues("NumberOne")
 
 		h := Handler{
-			Storage: &database.DB{},
+			Storage: NewMemoryStore(),
 		}
 
 		err := h.GetExpenseByIdHandler(c)
@@ -258,7 +304,7 @@ func TestGetExpenseByID(t *testing.T) {
 		}
 	})
 
-	t.Run("Get Expense By ID but not found Return HTTP Status Bad Request", func(t *testing.T) {
+	t.Run("Get Expense By ID but not found Return HTTP Status Not Found", func(t *testing.T) {
 		want := Err{"Expense not found"}
 		expected, _ := json.Marshal(want)
 		rec := httptest.NewRecorder()
@@ -271,17 +317,17 @@ func TestGetExpenseByID(t *testing.T) {
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
-		mock.ExpectPrepare("SELECT id,title,amount,note,tags FROM expenses").
+		mock.ExpectPrepare("SELECT (.+) FROM expenses where id=\\$1").
 			ExpectQuery().WithArgs(1).WillReturnError(sql.ErrNoRows)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetExpenseByIdHandler(c)
 
 		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
 			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
 		}
 	})
@@ -299,10 +345,10 @@ func TestGetExpenseByID(t *testing.T) {
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
-		mock.ExpectPrepare("SELECT id,title,amount,note,tags FROM expenses").WillReturnError(sql.ErrConnDone)
+		mock.ExpectPrepare("SELECT (.+) FROM expenses where id=\\$1").WillReturnError(sql.ErrConnDone)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetExpenseByIdHandler(c)
@@ -318,13 +364,14 @@ func TestGetExpenseByID(t *testing.T) {
 func TestUpdateExpenseByID(t *testing.T) {
 	e := echo.New()
 	t.Run("Update Expense By ID Return HTTP OK and Expense", func(t *testing.T) {
-		want := Expense{
-			ID:     1,
-			Title:  "apple smoothie",
-			Amount: 89,
-			Note:   "no discount",
-			Tags:   []string{"beverage"},
-		}
+		want := stored(Expense{
+			ID:       1,
+			Title:    "apple smoothie",
+			Amount:   money.FromInt(89),
+			Currency: "THB",
+			Note:     "no discount",
+			Tags:     []string{"beverage"},
+		})
 		expected, _ := json.Marshal(want)
 		body := bytes.NewBufferString(`{
 			"id": 1,
@@ -346,11 +393,11 @@ func TestUpdateExpenseByID(t *testing.T) {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		mock.ExpectPrepare("UPDATE expenses").
-			ExpectQuery().WithArgs(want.ID, want.Title, want.Amount, want.Note, pq.Array(&want.Tags)).
-			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(want.ID))
+			ExpectQuery().WithArgs(want.ID, want.Title, want.Amount, want.Note, pq.Array(&want.Tags), want.Currency, nil, nil).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "spent_at", "created_at", "updated_at", "status", "status_reason"}).AddRow(want.ID, want.OwnerID, testTime, testTime, testTime, "draft", ""))
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.UpdateExpenseByIDHandler(c)
@@ -378,7 +425,7 @@ func TestUpdateExpenseByID(t *testing.T) {
 		c.SetParamValues("NumberOne")
 
 		h := Handler{
-			Storage: &database.DB{},
+			Storage: NewMemoryStore(),
 		}
 
 		err := h.UpdateExpenseByIDHandler(c)
@@ -430,7 +477,7 @@ func TestUpdateExpenseByID(t *testing.T) {
 			c.SetParamValues("1")
 
 			h := Handler{
-				Storage: &database.DB{},
+				Storage: NewMemoryStore(),
 			}
 
 			err := h.UpdateExpenseByIDHandler(c)
@@ -442,7 +489,7 @@ func TestUpdateExpenseByID(t *testing.T) {
 		})
 	}
 
-	t.Run("Update Expense By ID but ID not found Return HTTP Status Bad Request", func(t *testing.T) {
+	t.Run("Update Expense By ID but ID not found Return HTTP Status Not Found", func(t *testing.T) {
 		want := Err{Msg: "Expense not found"}
 		expected, _ := json.Marshal(want)
 		body := bytes.NewBufferString(`{
@@ -465,17 +512,19 @@ func TestUpdateExpenseByID(t *testing.T) {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 		}
 		mock.ExpectPrepare("UPDATE expenses").
-			ExpectQuery().WithArgs(1, "apple smoothie", 89.00, "no discount", pq.Array(&[]string{"beverage"})).
+			ExpectQuery().WithArgs(1, "apple smoothie", money.FromInt(89), "no discount", pq.Array(&[]string{"beverage"}), "THB", nil, nil).
 			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT status FROM expenses WHERE id=\\$1 AND deleted_at IS NULL").
+			WithArgs(1).WillReturnError(sql.ErrNoRows)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.UpdateExpenseByIDHandler(c)
 
 		if assert.NoError(t, err) {
-			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, http.StatusNotFound, rec.Code)
 			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
 		}
 	})
@@ -505,7 +554,7 @@ func TestUpdateExpenseByID(t *testing.T) {
 		mock.ExpectPrepare("UPDATE expenses").WillReturnError(sql.ErrConnDone)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.UpdateExpenseByIDHandler(c)
@@ -526,9 +575,9 @@ func TestGetAllExpenses(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 
-		mockReturnRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"}).
-			AddRow(1, "strawberry smoothie", 79.00, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"})).
-			AddRow(2, "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"}))
+		mockReturnRows := expenseRows().
+			AddRow(1, "default", "strawberry smoothie", 79.00, "night market promotion discount 10 bath", pq.Array([]string{"food", "beverage"}), "THB", testTime, testTime, testTime, "draft", "", nil).
+			AddRow(2, "default", "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"}), "THB", testTime, testTime, testTime, "draft", "", nil)
 		db, mock, err := sqlmock.New()
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -538,7 +587,7 @@ func TestGetAllExpenses(t *testing.T) {
 			WillReturnRows(mockReturnRows)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetAllExpensesHandler(c)
@@ -556,7 +605,7 @@ func TestGetAllExpenses(t *testing.T) {
 		rec := httptest.NewRecorder()
 		c := e.NewContext(req, rec)
 
-		mockReturnRows := sqlmock.NewRows([]string{"id", "title", "amount", "note", "tags"})
+		mockReturnRows := expenseRows()
 		db, mock, err := sqlmock.New()
 		if err != nil {
 			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -566,7 +615,7 @@ func TestGetAllExpenses(t *testing.T) {
 			WillReturnRows(mockReturnRows)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetAllExpensesHandler(c)
@@ -595,7 +644,7 @@ func TestGetAllExpenses(t *testing.T) {
 			WillReturnRows(mockReturnRows)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetAllExpensesHandler(c)
@@ -619,7 +668,7 @@ func TestGetAllExpenses(t *testing.T) {
 			WillReturnError(sql.ErrConnDone)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetAllExpensesHandler(c)
@@ -642,7 +691,7 @@ func TestGetAllExpenses(t *testing.T) {
 		mock.ExpectPrepare("SELECT (.+) FROM expenses").WillReturnError(sql.ErrConnDone)
 
 		h := Handler{
-			Storage: &database.DB{Database: db},
+			Storage: NewPostgresStore(&database.DB{Database: db}),
 		}
 
 		err = h.GetAllExpensesHandler(c)
@@ -653,3 +702,1040 @@ func TestGetAllExpenses(t *testing.T) {
 	})
 
 }
+
+func TestHandlerWithMemoryStore(t *testing.T) {
+	e := echo.New()
+	h := Handler{
+		Storage: NewMemoryStore(),
+	}
+
+	t.Run("Create Expense Return HTTP StatusCreated and Created Expense", func(t *testing.T) {
+		body := bytes.NewBufferString(`{
+			"title": "strawberry smoothie",
+			"amount": 79,
+			"note": "night market promotion discount 10 bath", 
+			"tags": ["food", "beverage"]
+		}`)
+		req := httptest.NewRequest(http.MethodPost, "/expenses", body)
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := h.CreateExpenseHandler(c)
+
+		got := Expense{}
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusCreated, rec.Code)
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, 1, got.ID)
+		}
+	})
+
+	t.Run("Update Expense By ID Return HTTP OK and Expense", func(t *testing.T) {
+		body := bytes.NewBufferString(`{
+			"title": "apple smoothie",
+			"amount": 89,
+			"note": "no discount", 
+			"tags": ["beverage"]
+		}`)
+		req := httptest.NewRequest(http.MethodPut, "/expenses", body)
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		err := h.UpdateExpenseByIDHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+		}
+	})
+
+	t.Run("Get Expense By ID Return HTTP OK and Updated Expense", func(t *testing.T) {
+		want := Expense{
+			ID:       1,
+			Title:    "apple smoothie",
+			Amount:   money.FromInt(89),
+			Currency: "THB",
+			Note:     "no discount",
+			Tags:     []string{"beverage"},
+		}
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		err := h.GetExpenseByIdHandler(c)
+
+		got := Expense{}
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.False(t, got.SpentAt.IsZero())
+			assert.False(t, got.UpdatedAt.Before(got.CreatedAt))
+			assert.Equal(t, want, withoutStoreFields(got))
+		}
+	})
+
+	t.Run("Get Expense By ID but not found Return HTTP Status Not Found", func(t *testing.T) {
+		want := Err{"Expense not found"}
+		expected, _ := json.Marshal(want)
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("2")
+
+		err := h.GetExpenseByIdHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		}
+	})
+
+	t.Run("Get All Expenses Return HTTP OK and Stored Expenses", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err := h.GetAllExpensesHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			respEx := []Expense{}
+			json.Unmarshal(rec.Body.Bytes(), &respEx)
+			assert.Equal(t, 1, len(respEx))
+		}
+	})
+}
+
+func TestDeleteExpenseByID(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodDelete, "/expenses", nil)
+	t.Run("Delete Expense By ID Return HTTP No Content", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectQuery("UPDATE expenses SET deleted_at=now\\(\\) WHERE id=\\$1 AND deleted_at IS NULL").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+
+		h := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+
+		err = h.DeleteExpenseByIDHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		}
+	})
+
+	t.Run("Delete Expense By ID but not found Return HTTP Status Not Found", func(t *testing.T) {
+		want := Err{"Expense not found"}
+		expected, _ := json.Marshal(want)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		h := Handler{
+			Storage: NewMemoryStore(),
+		}
+
+		err := h.DeleteExpenseByIDHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		}
+	})
+
+	t.Run("Delete approved Expense Return HTTP Status Conflict", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectQuery("UPDATE expenses SET deleted_at=now\\(\\) WHERE id=\\$1 AND deleted_at IS NULL AND status NOT IN \\('approved','reimbursed'\\)").
+			WithArgs(1).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT status FROM expenses WHERE id=\\$1 AND deleted_at IS NULL").
+			WithArgs(1).
+			WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(StatusApproved))
+
+		h := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+
+		err = h.DeleteExpenseByIDHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusConflict, rec.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		}
+	})
+
+	t.Run("Delete Expense By ID but DB close Return HTTP Internal Error", func(t *testing.T) {
+		want := Err{"Internal error"}
+		expected, _ := json.Marshal(want)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues("1")
+
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectQuery("UPDATE expenses SET deleted_at").WillReturnError(sql.ErrConnDone)
+
+		h := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+
+		err = h.DeleteExpenseByIDHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		}
+	})
+}
+
+func TestSoftDeleteWithMemoryStore(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{
+		Storage: store,
+	}
+	seed := Expense{Title: "strawberry smoothie", Amount: money.FromInt(79), Currency: "THB", Note: "night market", Tags: []string{"food"}}
+	store.InsertExpense(context.Background(), &seed)
+
+	callByID := func(handler echo.HandlerFunc, method string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/expenses", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(seed.ID))
+		assert.NoError(t, handler(c))
+		return rec
+	}
+
+	t.Run("Deleted Expense is hidden from Get By ID and Get All", func(t *testing.T) {
+		assert.Equal(t, http.StatusNoContent, callByID(h.DeleteExpenseByIDHandler, http.MethodDelete).Code)
+		assert.Equal(t, http.StatusNotFound, callByID(h.GetExpenseByIdHandler, http.MethodGet).Code)
+
+		expenses := []Expense{}
+		store.SelectExpenses(context.Background(), ExpenseQuery{}, func(ex Expense) error {
+			expenses = append(expenses, ex)
+			return nil
+		})
+		assert.Equal(t, 0, len(expenses))
+	})
+
+	t.Run("Delete Expense twice Return HTTP Status Not Found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, callByID(h.DeleteExpenseByIDHandler, http.MethodDelete).Code)
+	})
+
+	t.Run("Restore Expense Return HTTP OK and Restored Expense", func(t *testing.T) {
+		expected, _ := json.Marshal(seed)
+		rec := callByID(h.RestoreExpenseByIDHandler, http.MethodPost)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		assert.Equal(t, http.StatusOK, callByID(h.GetExpenseByIdHandler, http.MethodGet).Code)
+	})
+
+	t.Run("Restore Expense which is not deleted Return HTTP Status Not Found", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, callByID(h.RestoreExpenseByIDHandler, http.MethodPost).Code)
+	})
+
+	t.Run("Purge removes only expenses deleted before retention", func(t *testing.T) {
+		p := NewPurger(store, nil, time.Hour)
+		callByID(h.DeleteExpenseByIDHandler, http.MethodDelete)
+
+		purged, err := p.PurgeOnce(context.Background(), time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), purged)
+
+		purged, err = p.PurgeOnce(context.Background(), time.Now().Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+		assert.Equal(t, http.StatusNotFound, callByID(h.RestoreExpenseByIDHandler, http.MethodPost).Code)
+	})
+}
+
+func TestPatchExpenseByID(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{
+		Storage: store,
+	}
+	seed := Expense{Title: "strawberry smoothie", Amount: money.FromInt(79), Currency: "THB", Note: "night market", Tags: []string{"food", "beverage"}}
+	store.InsertExpense(context.Background(), &seed)
+
+	tests := []struct {
+		testname    string
+		id          string
+		contentType string
+		patch       string
+		httpStatus  int
+		want        interface{}
+	}{
+		{"Merge Patch Expense Return HTTP OK and Patched Expense", strconv.Itoa(seed.ID), MIMEMergePatch, `{"amount": 89}`,
+			http.StatusOK, Expense{ID: seed.ID, Title: seed.Title, Amount: money.FromInt(89), Currency: "THB", Note: seed.Note, Tags: seed.Tags}},
+		{"JSON Patch Expense Return HTTP OK and Patched Expense", strconv.Itoa(seed.ID), MIMEJSONPatch, `[{"op": "remove", "path": "/tags/0"}]`,
+			http.StatusOK, Expense{ID: seed.ID, Title: seed.Title, Amount: money.FromInt(89), Currency: "THB", Note: seed.Note, Tags: []string{"beverage"}}},
+		{"Patch Expense to empty title Return HTTP Status Bad Request", strconv.Itoa(seed.ID), MIMEMergePatch, `{"title": ""}`,
+			http.StatusBadRequest, Err{Msg: "Invalid request body"}},
+		{"Patch Expense removing all tags Return HTTP Status Bad Request", strconv.Itoa(seed.ID), MIMEJSONPatch, `[{"op": "remove", "path": "/tags"}]`,
+			http.StatusBadRequest, Err{Msg: "Invalid request body"}},
+		{"Patch Expense with broken document Return HTTP Status Bad Request", strconv.Itoa(seed.ID), MIMEJSONPatch, `{"op": "remove"}`,
+			http.StatusBadRequest, Err{Msg: "Invalid patch document"}},
+		{"Patch Expense with failed test Return HTTP Status Conflict", strconv.Itoa(seed.ID), MIMEJSONPatch, `[{"op": "test", "path": "/amount", "value": 1}]`,
+			http.StatusConflict, Err{Msg: "Patch test failed"}},
+		{"Patch Expense with JSON content type Return HTTP Unsupported Media Type", strconv.Itoa(seed.ID), echo.MIMEApplicationJSON, `{"amount": 1}`,
+			http.StatusUnsupportedMediaType, Err{Msg: "Unsupported patch content type"}},
+		{"Patch Expense with oversized document Return HTTP Status Request Entity Too Large", strconv.Itoa(seed.ID), MIMEMergePatch, `{"note": "` + strings.Repeat("a", maxPatchSize) + `"}`,
+			http.StatusRequestEntityTooLarge, Err{Msg: "Patch document is too large"}},
+		{"Patch Expense but not found Return HTTP Status Not Found", "0", MIMEMergePatch, `{"amount": 1}`,
+			http.StatusNotFound, Err{Msg: "Expense not found"}},
+		{"Patch Expense By ID(STRING) Return HTTP Status Bad Request", "NumberOne", MIMEMergePatch, `{"amount": 1}`,
+			http.StatusBadRequest, Err{Msg: "ID is not numeric"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			expected, _ := json.Marshal(tt.want)
+			req := httptest.NewRequest(http.MethodPatch, "/expenses", strings.NewReader(tt.patch))
+			req.Header.Add(echo.HeaderContentType, tt.contentType)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetPath("/:id")
+			c.SetParamNames("id")
+			c.SetParamValues(tt.id)
+
+			err := h.PatchExpenseByIDHandler(c)
+
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.httpStatus, rec.Code)
+			if want, ok := tt.want.(Expense); ok {
+				got := Expense{}
+				json.Unmarshal(rec.Body.Bytes(), &got)
+				assert.True(t, seed.CreatedAt.Equal(got.CreatedAt))
+				assert.Equal(t, want, withoutStoreFields(got))
+				return
+			}
+			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		})
+	}
+}
+
+func TestGetAllExpensesPagination(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{
+		Storage: store,
+	}
+	for i := 0; i < 5; i++ {
+		store.InsertExpense(context.Background(), &Expense{Title: "smoothie", Amount: money.FromInt(79), Currency: "THB", Note: "no discount", Tags: []string{"food"}})
+	}
+	getPage := func(query string) (*httptest.ResponseRecorder, ExpensePage) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		assert.NoError(t, h.GetAllExpensesHandler(c))
+		page := ExpensePage{}
+		json.Unmarshal(rec.Body.Bytes(), &page)
+		return rec, page
+	}
+
+	t.Run("Get Expenses with limit Return first page, next cursor and Link header", func(t *testing.T) {
+		rec, page := getPage("limit=2")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, 2, len(page.Expenses))
+		assert.Equal(t, 1, page.Expenses[0].ID)
+		assert.NotEmpty(t, page.NextCursor)
+		assert.Equal(t, `</expenses?cursor=`+page.NextCursor+`&limit=2>; rel="next"`, rec.Header().Get("Link"))
+	})
+
+	t.Run("Get Expenses following cursors walk every row once in id order", func(t *testing.T) {
+		ids := []int{}
+		query := "limit=2"
+		for {
+			_, page := getPage(query)
+			for _, ex := range page.Expenses {
+				ids = append(ids, ex.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query = "limit=2&cursor=" + page.NextCursor
+		}
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, ids)
+	})
+
+	t.Run("Get Expenses last page has no Link header", func(t *testing.T) {
+		rec, page := getPage("limit=5")
+		assert.Equal(t, 5, len(page.Expenses))
+		assert.Empty(t, page.NextCursor)
+		assert.Empty(t, rec.Header().Get("Link"))
+	})
+
+	invalidTests := []struct {
+		testname string
+		query    string
+		want     Err
+	}{
+		{"Get Expenses with zero limit Return HTTP Status Bad Request", "limit=0", Err{Msg: "Invalid limit"}},
+		{"Get Expenses with too large limit Return HTTP Status Bad Request", "limit=1000", Err{Msg: "Invalid limit"}},
+		{"Get Expenses with garbage cursor Return HTTP Status Bad Request", "cursor=not-a-cursor", Err{Msg: "Invalid cursor"}},
+	}
+	for _, tt := range invalidTests {
+		t.Run(tt.testname, func(t *testing.T) {
+			expected, _ := json.Marshal(tt.want)
+			rec, _ := getPage(tt.query)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		})
+	}
+
+	t.Run("Get Expenses page from Postgres uses keyset on id", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE deleted_at IS NULL AND kind = 'expense' AND id > \\$1 ORDER BY id LIMIT \\$2").
+			ExpectQuery().WithArgs(3, 3).
+			WillReturnRows(expenseRows().
+				AddRow(4, "default", "apple smoothie", 89.00, "no discount", pq.Array([]string{"beverage"}), "THB", testTime, testTime, testTime, "draft", "", nil))
+		pgHandler := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/expenses?limit=2&cursor="+encodeCursor(expenseCursor{ID: 3}), nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+
+		err = pgHandler.GetAllExpensesHandler(c)
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		}
+	})
+}
+
+func TestGetAllExpensesFilterAndSort(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{
+		Storage: store,
+	}
+	seeds := []Expense{
+		{Title: "strawberry smoothie", Amount: money.FromInt(79), Currency: "THB", Note: "night market promotion", Tags: []string{"food", "beverage"}},
+		{Title: "iPhone 14 Pro Max 1TB", Amount: money.FromInt(66900), Currency: "THB", Note: "birthday gift", Tags: []string{"gadget"}},
+		{Title: "omakase dinner", Amount: money.FromInt(3500), Currency: "THB", Note: "anniversary", Tags: []string{"food"}},
+		{Title: "apple smoothie", Amount: money.FromInt(89), Currency: "THB", Note: "no discount", Tags: []string{"beverage"}},
+	}
+	for i := range seeds {
+		store.InsertExpense(context.Background(), &seeds[i])
+	}
+	list := func(query string) (*httptest.ResponseRecorder, []int) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses?"+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		assert.NoError(t, h.GetAllExpensesHandler(c))
+		respEx := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &respEx)
+		ids := []int{}
+		for _, ex := range respEx {
+			ids = append(ids, ex.ID)
+		}
+		return rec, ids
+	}
+
+	tests := []struct {
+		testname string
+		query    string
+		want     []int
+	}{
+		{"Filter by any tag", "tag=food&tag=gadget", []int{1, 2, 3}},
+		{"Filter by all tags", "tag=food,beverage&tag_match=all", []int{1}},
+		{"Filter by amount range", "min_amount=80&max_amount=3500", []int{3, 4}},
+		{"Filter food over 500", "tag=food&min_amount=500", []int{3}},
+		{"Filter by text on title or note", "q=SMOOTHIE", []int{1, 4}},
+		{"Filter by text on note", "q=gift", []int{2}},
+		{"Filter by created range", "created_from=2000-01-01&created_to=" + time.Now().Add(time.Hour).Format(time.RFC3339), []int{1, 2, 3, 4}},
+		{"Filter by created range in the past", "created_to=2000-01-01", []int{}},
+		{"Sort by amount", "sort=amount", []int{1, 4, 3, 2}},
+		{"Sort by amount descending", "sort=-amount", []int{2, 3, 4, 1}},
+		{"Sort by title then amount", "sort=title,-amount", []int{4, 2, 3, 1}},
+		{"Sort by created_at descending", "sort=-created_at,-id", []int{4, 3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			rec, ids := list(tt.query)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.want, ids)
+		})
+	}
+
+	t.Run("Sorted pages follow cursor in sort order", func(t *testing.T) {
+		ids := []int{}
+		query := "sort=-amount&limit=3"
+		for {
+			req := httptest.NewRequest(http.MethodGet, "/expenses?"+query, nil)
+			rec := httptest.NewRecorder()
+			assert.NoError(t, h.GetAllExpensesHandler(e.NewContext(req, rec)))
+			page := ExpensePage{}
+			json.Unmarshal(rec.Body.Bytes(), &page)
+			for _, ex := range page.Expenses {
+				ids = append(ids, ex.ID)
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			query = "sort=-amount&limit=3&cursor=" + page.NextCursor
+		}
+		assert.Equal(t, []int{2, 3, 4, 1}, ids)
+	})
+
+	invalidTests := []struct {
+		testname string
+		query    string
+		want     Err
+	}{
+		{"Sort by unknown column Return HTTP Status Bad Request", "sort=note", Err{Msg: "Invalid sort"}},
+		{"Sort with SQL injection Return HTTP Status Bad Request", "sort=amount%3BDROP%20TABLE%20expenses", Err{Msg: "Invalid sort"}},
+		{"Unknown tag_match Return HTTP Status Bad Request", "tag=food&tag_match=some", Err{Msg: "Invalid tag_match"}},
+		{"Non numeric min_amount Return HTTP Status Bad Request", "min_amount=cheap", Err{Msg: "Invalid min_amount"}},
+		{"Bad created_from Return HTTP Status Bad Request", "created_from=yesterday", Err{Msg: "Invalid created_from"}},
+		{"Cursor from another sort Return HTTP Status Bad Request", "sort=amount&cursor=" + encodeCursor(expenseCursor{ID: 1}), Err{Msg: "Invalid cursor"}},
+		{"Cursor of a missing expense Return HTTP Status Bad Request", "sort=amount&cursor=" + encodeCursor(expenseCursor{ID: 99, Sort: "amount"}), Err{Msg: "Invalid cursor"}},
+	}
+	for _, tt := range invalidTests {
+		t.Run(tt.testname, func(t *testing.T) {
+			expected, _ := json.Marshal(tt.want)
+			rec, _ := list(tt.query)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		})
+	}
+}
+
+func TestSearchExpenses(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{
+		Storage: store,
+	}
+	seeds := []Expense{
+		{Title: "strawberry smoothie", Amount: money.FromInt(79), Currency: "THB", Note: "night market promotion", Tags: []string{"food"}},
+		{Title: "apple smoothie", Amount: money.FromInt(89), Currency: "THB", Note: "no discount", Tags: []string{"beverage"}},
+		{Title: "iPhone 14 Pro Max 1TB", Amount: money.FromInt(66900), Currency: "THB", Note: "birthday gift", Tags: []string{"gadget"}},
+		{Title: "fish & chips <large>", Amount: money.FromInt(120), Currency: "THB", Note: "<script>alert(1)</script>", Tags: []string{"food"}},
+	}
+	for i := range seeds {
+		store.InsertExpense(context.Background(), &seeds[i])
+	}
+	search := func(query string) (*httptest.ResponseRecorder, []SearchResult) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/search?"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.SearchExpensesHandler(e.NewContext(req, rec)))
+		results := []SearchResult{}
+		json.Unmarshal(rec.Body.Bytes(), &results)
+		return rec, results
+	}
+
+	t.Run("Search Return ranked results with highlights", func(t *testing.T) {
+		rec, results := search("q=Smoothie+market")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Equal(t, 2, len(results)) {
+			assert.Equal(t, 1, results[0].ID)
+			assert.Equal(t, 2, results[1].ID)
+			assert.Greater(t, results[0].Rank, results[1].Rank)
+			assert.Equal(t, "strawberry <mark>smoothie</mark>", results[0].Highlight.Title)
+			assert.Equal(t, "night <mark>market</mark> promotion", results[0].Highlight.Note)
+		}
+	})
+
+	t.Run("Search matches word prefixes", func(t *testing.T) {
+		_, results := search("q=birth")
+		if assert.Equal(t, 1, len(results)) {
+			assert.Equal(t, 3, results[0].ID)
+		}
+	})
+
+	t.Run("Search escapes the highlighted text", func(t *testing.T) {
+		_, results := search("q=chips+script")
+		if assert.Equal(t, 1, len(results)) {
+			assert.Equal(t, "fish &amp; <mark>chips</mark> &lt;large&gt;", results[0].Highlight.Title)
+			assert.Equal(t, "&lt;<mark>script</mark>&gt;alert(1)&lt;/<mark>script</mark>&gt;", results[0].Highlight.Note)
+		}
+	})
+
+	t.Run("Search with limit", func(t *testing.T) {
+		_, results := search("q=smoothie&limit=1")
+		assert.Equal(t, 1, len(results))
+	})
+
+	t.Run("Search without terms Return HTTP Status Bad Request", func(t *testing.T) {
+		expected, _ := json.Marshal(Err{Msg: "Invalid search query"})
+		rec, _ := search("q=%21%3F")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Search Postgres with prefix tsquery", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectQuery("SELECT (.+) FROM expenses, to_tsquery\\('simple', \\$1\\) AS query").
+			WithArgs("smoothie:* | market:*", DefaultPageLimit, "\x02\x03", "StartSel=\x02, StopSel=\x03, HighlightAll=true", "StartSel=\x02, StopSel=\x03, MaxFragments=2").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "owner_id", "title", "amount", "note", "tags", "currency", "spent_at", "created_at", "updated_at", "status", "status_reason", "account_id", "rank", "title", "note"}).
+				AddRow(1, "default", "strawberry smoothie <b>", 79.00, "night market", pq.Array([]string{"food"}), "THB", testTime, testTime, testTime, "draft", "", nil, 0.2, "strawberry \x02smoothie\x03 <b>", "night \x02market\x03"))
+		pgHandler := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+		req := httptest.NewRequest(http.MethodGet, "/expenses/search?q=smoothie%27+market", nil)
+		rec := httptest.NewRecorder()
+
+		err = pgHandler.SearchExpensesHandler(e.NewContext(req, rec))
+
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NoError(t, mock.ExpectationsWereMet())
+			results := []SearchResult{}
+			json.Unmarshal(rec.Body.Bytes(), &results)
+			if assert.Equal(t, 1, len(results)) {
+				assert.Equal(t, "strawberry <mark>smoothie</mark> &lt;b&gt;", results[0].Highlight.Title)
+			}
+		}
+	})
+}
+
+func TestCreateExpenseMoneyValidation(t *testing.T) {
+	e := echo.New()
+	h := Handler{
+		Storage: NewMemoryStore(),
+	}
+	tests := []struct {
+		testname   string
+		body       string
+		httpStatus int
+		want       interface{}
+	}{
+		{"Create Expense without currency defaults to THB", `{"title": "smoothie", "amount": 79.5, "note": "no discount", "tags": ["food"]}`,
+			http.StatusCreated, Expense{ID: 1, Title: "smoothie", Amount: money.MustParse("79.5"), Currency: "THB", Note: "no discount", Tags: []string{"food"}}},
+		{"Create Expense with amount as string and lower case currency", `{"title": "sushi", "amount": "1200", "currency": "jpy", "note": "no discount", "tags": ["food"]}`,
+			http.StatusCreated, Expense{ID: 2, Title: "sushi", Amount: money.FromInt(1200), Currency: "JPY", Note: "no discount", Tags: []string{"food"}}},
+		{"Create Expense with too many fraction digits for currency Return HTTP Status Bad Request", `{"title": "sushi", "amount": 1200.5, "currency": "JPY", "note": "no discount", "tags": ["food"]}`,
+			http.StatusBadRequest, Err{Msg: "Invalid amount"}},
+		{"Create Expense with three fraction digits in THB Return HTTP Status Bad Request", `{"title": "smoothie", "amount": 79.505, "note": "no discount", "tags": ["food"]}`,
+			http.StatusBadRequest, Err{Msg: "Invalid amount"}},
+		{"Create Expense with unknown currency Return HTTP Status Bad Request", `{"title": "smoothie", "amount": 79, "currency": "ABC", "note": "no discount", "tags": ["food"]}`,
+			http.StatusBadRequest, Err{Msg: "Invalid currency"}},
+		{"Create Expense with unrepresentable amount Return HTTP Status Bad Request", `{"title": "smoothie", "amount": 0.00001, "note": "no discount", "tags": ["food"]}`,
+			http.StatusBadRequest, Err{Msg: "Invalid request body"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			expected, _ := json.Marshal(tt.want)
+			req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(tt.body))
+			req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+
+			err := h.CreateExpenseHandler(e.NewContext(req, rec))
+
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.httpStatus, rec.Code)
+			if want, ok := tt.want.(Expense); ok {
+				got := Expense{}
+				json.Unmarshal(rec.Body.Bytes(), &got)
+				assert.Equal(t, want, withoutStoreFields(got))
+				return
+			}
+			assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+		})
+	}
+}
+
+func TestExpenseTimestamps(t *testing.T) {
+	e := echo.New()
+	h := Handler{
+		Storage: NewMemoryStore(),
+	}
+	create := func(body string) Expense {
+		req := httptest.NewRequest(http.MethodPost, "/expenses", strings.NewReader(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.CreateExpenseHandler(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		got := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		return got
+	}
+	list := func(query string) []int {
+		req := httptest.NewRequest(http.MethodGet, "/expenses?"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.GetAllExpensesHandler(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		respEx := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &respEx)
+		ids := []int{}
+		for _, ex := range respEx {
+			ids = append(ids, ex.ID)
+		}
+		return ids
+	}
+
+	older := create(`{"title": "omakase dinner", "amount": 3500, "note": "anniversary", "tags": ["food"], "spent_at": "2022-11-20T19:00:00+07:00"}`)
+	newer := create(`{"title": "apple smoothie", "amount": 89, "note": "no discount", "tags": ["beverage"]}`)
+
+	t.Run("Create Expense keeps given spent_at and defaults it to now", func(t *testing.T) {
+		assert.True(t, older.SpentAt.Equal(time.Date(2022, 11, 20, 12, 0, 0, 0, time.UTC)))
+		assert.False(t, newer.SpentAt.IsZero())
+		assert.True(t, newer.SpentAt.Equal(newer.CreatedAt))
+		assert.True(t, newer.UpdatedAt.Equal(newer.CreatedAt))
+	})
+
+	t.Run("Update Expense keeps created_at and spent_at and moves updated_at", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPut, "/expenses", strings.NewReader(`{"title": "omakase lunch", "amount": 2500, "note": "anniversary", "tags": ["food"]}`))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(strconv.Itoa(older.ID))
+
+		err := h.UpdateExpenseByIDHandler(c)
+
+		got := Expense{}
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.True(t, got.SpentAt.Equal(older.SpentAt))
+			assert.True(t, got.CreatedAt.Equal(older.CreatedAt))
+			assert.False(t, got.UpdatedAt.Before(older.UpdatedAt))
+		}
+	})
+
+	t.Run("Filter by spent range", func(t *testing.T) {
+		assert.Equal(t, []int{older.ID}, list("spent_from=2022-11-01&spent_to=2022-12-01"))
+		assert.Equal(t, []int{newer.ID}, list("spent_from=2022-12-01"))
+	})
+
+	t.Run("Sort by spent_at descending", func(t *testing.T) {
+		assert.Equal(t, []int{newer.ID, older.ID}, list("sort=-spent_at"))
+	})
+
+	t.Run("Bad spent_to Return HTTP Status Bad Request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses?spent_to=tomorrow", nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.GetAllExpensesHandler(e.NewContext(req, rec)))
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
+
+func TestExpenseOwnership(t *testing.T) {
+	e := echo.New()
+	h := Handler{
+		Storage: NewMemoryStore(),
+	}
+	alice := auth.Principal{Subject: "alice"}
+	bob := auth.Principal{Subject: "bob"}
+	admin := auth.Principal{Subject: "auditor", Roles: []string{auth.RoleAdmin}}
+	call := func(p auth.Principal, method, target, id, body string, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if id != "" {
+			c.SetPath("/:id")
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+		}
+		assert.NoError(t, handler(c))
+		return rec
+	}
+	listOwners := func(p auth.Principal, query string) []string {
+		rec := call(p, http.MethodGet, "/expenses?"+query, "", "", h.GetAllExpensesHandler)
+		respEx := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &respEx)
+		owners := []string{}
+		for _, ex := range respEx {
+			owners = append(owners, ex.OwnerID)
+		}
+		return owners
+	}
+	body := `{"title": "strawberry smoothie", "amount": 79, "note": "night market", "tags": ["food"]}`
+
+	created := Expense{}
+	rec := call(alice, http.MethodPost, "/expenses", "", body, h.CreateExpenseHandler)
+	json.Unmarshal(rec.Body.Bytes(), &created)
+	assert.Equal(t, "alice", created.OwnerID)
+	call(bob, http.MethodPost, "/expenses", "", body, h.CreateExpenseHandler)
+	id := strconv.Itoa(created.ID)
+
+	t.Run("Owner can get own expense", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, call(alice, http.MethodGet, "/expenses", id, "", h.GetExpenseByIdHandler).Code)
+	})
+
+	t.Run("Another user's expense Return HTTP Status Not Found", func(t *testing.T) {
+		expected, _ := json.Marshal(Err{Msg: "Expense not found"})
+		rec := call(bob, http.MethodGet, "/expenses", id, "", h.GetExpenseByIdHandler)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, string(expected), strings.TrimSpace(rec.Body.String()))
+	})
+
+	t.Run("Another user can not update or delete the expense", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, call(bob, http.MethodPut, "/expenses", id, body, h.UpdateExpenseByIDHandler).Code)
+		assert.Equal(t, http.StatusNotFound, call(bob, http.MethodDelete, "/expenses", id, "", h.DeleteExpenseByIDHandler).Code)
+	})
+
+	t.Run("List and search only return own expenses", func(t *testing.T) {
+		assert.Equal(t, []string{"alice"}, listOwners(alice, ""))
+		assert.Equal(t, []string{"bob"}, listOwners(bob, "owner=alice"))
+		rec := call(bob, http.MethodGet, "/expenses/search?q=smoothie", "", "", h.SearchExpensesHandler)
+		results := []SearchResult{}
+		json.Unmarshal(rec.Body.Bytes(), &results)
+		if assert.Equal(t, 1, len(results)) {
+			assert.Equal(t, "bob", results[0].OwnerID)
+		}
+	})
+
+	t.Run("Another user's expense is not a cursor", func(t *testing.T) {
+		cursor := encodeCursor(expenseCursor{ID: created.ID, Sort: "title"})
+		rec := call(bob, http.MethodGet, "/expenses?sort=title&cursor="+cursor, "", "", h.GetAllExpensesHandler)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		rec = call(alice, http.MethodGet, "/expenses?sort=title&cursor="+cursor, "", "", h.GetAllExpensesHandler)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Admin queries across owners", func(t *testing.T) {
+		assert.Equal(t, []string{"alice", "bob"}, listOwners(admin, ""))
+		assert.Equal(t, []string{"alice"}, listOwners(admin, "owner=alice"))
+		assert.Equal(t, http.StatusOK, call(admin, http.MethodGet, "/expenses", id, "", h.GetExpenseByIdHandler).Code)
+	})
+
+	t.Run("Admin update keeps the owner", func(t *testing.T) {
+		rec := call(admin, http.MethodPut, "/expenses", id, body, h.UpdateExpenseByIDHandler)
+		got := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "alice", got.OwnerID)
+	})
+
+	t.Run("Postgres queries are scoped by owner", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectPrepare("SELECT (.+) FROM expenses where id=\\$1 AND deleted_at IS NULL AND owner_id=\\$2").
+			ExpectQuery().WithArgs(1, "bob").WillReturnError(sql.ErrNoRows)
+		mock.ExpectPrepare("SELECT (.+) FROM expenses WHERE deleted_at IS NULL AND kind = 'expense' AND owner_id = \\$1 ORDER BY id").
+			ExpectQuery().WithArgs("bob").WillReturnRows(expenseRows())
+		pgHandler := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+
+		assert.Equal(t, http.StatusNotFound, call(bob, http.MethodGet, "/expenses", "1", "", pgHandler.GetExpenseByIdHandler).Code)
+		assert.Equal(t, http.StatusOK, call(bob, http.MethodGet, "/expenses?owner=alice", "", "", pgHandler.GetAllExpensesHandler).Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestExpenseApproval(t *testing.T) {
+	e := echo.New()
+	h := Handler{
+		Storage: NewMemoryStore(),
+	}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	finance := auth.Principal{Subject: "finance", Roles: []string{auth.RoleApprover}}
+	call := func(p auth.Principal, method, id, body string, handler echo.HandlerFunc) (*httptest.ResponseRecorder, Expense) {
+		req := httptest.NewRequest(method, "/expenses", strings.NewReader(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if id != "" {
+			c.SetPath("/:id")
+			c.SetParamNames("id")
+			c.SetParamValues(id)
+		}
+		assert.NoError(t, handler(c))
+		got := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		return rec, got
+	}
+	body := `{"title": "taxi", "amount": 350, "note": "client visit", "tags": ["travel"], "status": "approved"}`
+	create := func() string {
+		_, created := call(alice, http.MethodPost, "", body, h.CreateExpenseHandler)
+		return strconv.Itoa(created.ID)
+	}
+
+	t.Run("New expense is a draft whatever the body says", func(t *testing.T) {
+		_, got := call(alice, http.MethodPost, "", body, h.CreateExpenseHandler)
+		assert.Equal(t, StatusDraft, got.Status)
+	})
+
+	t.Run("Expense goes through submit, approve and reimburse", func(t *testing.T) {
+		id := create()
+		steps := []struct {
+			p       auth.Principal
+			handler echo.HandlerFunc
+			want    string
+		}{
+			{alice, h.SubmitExpenseHandler, StatusSubmitted},
+			{finance, h.ApproveExpenseHandler, StatusApproved},
+			{finance, h.ReimburseExpenseHandler, StatusReimbursed},
+		}
+		for _, s := range steps {
+			rec, got := call(s.p, http.MethodPost, id, "", s.handler)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, s.want, got.Status)
+		}
+	})
+
+	t.Run("Rejected expense keeps the reason and can be resubmitted", func(t *testing.T) {
+		id := create()
+		call(alice, http.MethodPost, id, "", h.SubmitExpenseHandler)
+
+		rec, _ := call(finance, http.MethodPost, id, "", h.RejectExpenseHandler)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+		rec, got := call(finance, http.MethodPost, id, `{"reason": "missing receipt"}`, h.RejectExpenseHandler)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, StatusRejected, got.Status)
+		assert.Equal(t, "missing receipt", got.StatusReason)
+
+		rec, _ = call(alice, http.MethodPut, id, body, h.UpdateExpenseByIDHandler)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		rec, got = call(alice, http.MethodPost, id, "", h.SubmitExpenseHandler)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, StatusSubmitted, got.Status)
+		assert.Empty(t, got.StatusReason)
+	})
+
+	t.Run("Illegal transition Return HTTP Status Conflict", func(t *testing.T) {
+		id := create()
+		rec, _ := call(finance, http.MethodPost, id, "", h.ApproveExpenseHandler)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		rec, _ = call(finance, http.MethodPost, id, "", h.ReimburseExpenseHandler)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		rec, _ = call(finance, http.MethodPost, "999", "", h.ApproveExpenseHandler)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Approved expense can not be edited", func(t *testing.T) {
+		id := create()
+		call(alice, http.MethodPost, id, "", h.SubmitExpenseHandler)
+		call(finance, http.MethodPost, id, "", h.ApproveExpenseHandler)
+
+		rec, _ := call(alice, http.MethodPut, id, body, h.UpdateExpenseByIDHandler)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+
+		req := httptest.NewRequest(http.MethodPatch, "/expenses", strings.NewReader(`{"title": "bus"}`))
+		req.Header.Add(echo.HeaderContentType, MIMEMergePatch)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), alice))
+		rec = httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/:id")
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, h.PatchExpenseByIDHandler(c))
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Approver lists submitted expenses of every owner", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses?status=submitted", nil)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), finance))
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.GetAllExpensesHandler(e.NewContext(req, rec)))
+		respEx := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &respEx)
+		if assert.Equal(t, 1, len(respEx)) {
+			assert.Equal(t, "alice", respEx[0].OwnerID)
+			assert.Equal(t, StatusSubmitted, respEx[0].Status)
+		}
+	})
+
+	t.Run("Approver edit or delete of another owner's expense Return HTTP Status Not Found", func(t *testing.T) {
+		id := create()
+
+		rec, _ := call(finance, http.MethodPut, id, body, h.UpdateExpenseByIDHandler)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec, _ = call(finance, http.MethodDelete, id, "", h.DeleteExpenseByIDHandler)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec, got := call(finance, http.MethodGet, id, "", h.GetExpenseByIdHandler)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "taxi", got.Title)
+	})
+
+	t.Run("Postgres delete by approver is restricted to their own rows", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectQuery("UPDATE expenses SET deleted_at=now\\(\\) WHERE id=\\$1 AND deleted_at IS NULL (.+) AND owner_id=\\$2").
+			WithArgs(1, "finance").WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT status FROM expenses WHERE id=\\$1 AND deleted_at IS NULL AND owner_id=\\$2").
+			WithArgs(1, "finance").WillReturnError(sql.ErrNoRows)
+		pgHandler := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+
+		rec, _ := call(finance, http.MethodDelete, "1", "", pgHandler.DeleteExpenseByIDHandler)
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Postgres transition is conditional on the current status", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		if err != nil {
+			t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		mock.ExpectQuery("UPDATE expenses SET status=\\$2 , status_reason=\\$3 , updated_at=now\\(\\) WHERE id=\\$1 AND deleted_at IS NULL AND status = ANY\\(\\$4\\)").
+			WithArgs(1, StatusApproved, "", pq.Array([]string{StatusSubmitted})).
+			WillReturnError(sql.ErrNoRows)
+		mock.ExpectQuery("SELECT status FROM expenses WHERE id=\\$1 AND deleted_at IS NULL").
+			WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(StatusDraft))
+		pgHandler := Handler{
+			Storage: NewPostgresStore(&database.DB{Database: db}),
+		}
+
+		rec, _ := call(finance, http.MethodPost, "1", "", pgHandler.ApproveExpenseHandler)
+
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
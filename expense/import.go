@@ -0,0 +1,233 @@
+package expense
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultMaxImportSize bounds an uploaded CSV file.
+const DefaultMaxImportSize = 10 << 20
+
+// importFields are the expense fields a CSV column can be mapped onto.
+// Unmapped fields are read from a column of the same name.
+var importFields = []string{"title", "amount", "note", "tags", "currency", "spent_at"}
+
+// RowError explains why the CSV line Row can not be imported.
+type RowError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+type ImportReport struct {
+	DryRun   bool       `json:"dry_run"`
+	Rows     int        `json:"rows"`
+	Imported int        `json:"imported"`
+	Errors   []RowError `json:"errors"`
+}
+
+// importMapping reads map.<field>=<column> query parameters.
+func importMapping(c echo.Context) (map[string]string, string) {
+	mapping := map[string]string{}
+	for _, f := range importFields {
+		mapping[f] = f
+	}
+	for name, values := range c.QueryParams() {
+		if !strings.HasPrefix(name, "map.") {
+			continue
+		}
+		field := strings.TrimPrefix(name, "map.")
+		if _, ok := mapping[field]; !ok {
+			return nil, "Unknown import field " + field
+		}
+		mapping[field] = strings.TrimSpace(values[0])
+	}
+	return mapping, ""
+}
+
+// columnIndexes locates every mapped column in header, case-insensitively.
+// Only currency and spent_at may be missing.
+func columnIndexes(header []string, mapping map[string]string) (map[string]int, string) {
+	byName := map[string]int{}
+	for i, name := range header {
+		if i == 0 {
+			name = strings.TrimPrefix(name, "\ufeff")
+		}
+		byName[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	columns := map[string]int{}
+	for _, field := range importFields {
+		i, ok := byName[strings.ToLower(mapping[field])]
+		if ok {
+			columns[field] = i
+		} else if field != "currency" && field != "spent_at" {
+			return nil, "Missing column " + mapping[field]
+		}
+	}
+	return columns, ""
+}
+
+// parseImportRow builds an expense from record and validates it like
+// CreateExpenseHandler does.
+func parseImportRow(record []string, columns map[string]int) (Expense, string) {
+	value := func(field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+	ex := Expense{
+		Title:    value("title"),
+		Note:     value("note"),
+		Tags:     parseTags([]string{value("tags")}),
+		Currency: value("currency"),
+	}
+	amount, err := money.Parse(value("amount"))
+	if err != nil {
+		return ex, "Invalid amount"
+	}
+	ex.Amount = amount
+	if spent, ok := parseDateParam(value("spent_at")); !ok {
+		return ex, "Invalid spent_at"
+	} else if spent != nil {
+		ex.SpentAt = *spent
+	}
+	if checkEmptyField(ex) {
+		return ex, "Missing title, note or tags"
+	}
+	return ex, checkExpense(&ex)
+}
+
+// readImport parses the whole CSV, collecting an error for every invalid
+// row rather than stopping at the first. A non-empty message rejects the
+// file as a whole; err is only set when the body could not be read.
+func readImport(r io.Reader, mapping map[string]string) ([]Expense, ImportReport, string, error) {
+	report := ImportReport{Errors: []RowError{}}
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if isReadError(err) {
+		return nil, report, "", err
+	}
+	if err != nil {
+		return nil, report, "Invalid CSV header", nil
+	}
+	columns, msg := columnIndexes(header, mapping)
+	if msg != "" {
+		return nil, report, msg, nil
+	}
+	expenses := []Expense{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if isReadError(err) {
+			return nil, report, "", err
+		}
+		report.Rows++
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Errors = append(report.Errors, RowError{Row: parseErr.StartLine, Message: "Invalid CSV row"})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+		ex, msg := parseImportRow(record, columns)
+		if msg != "" {
+			report.Errors = append(report.Errors, RowError{Row: line, Message: msg})
+			continue
+		}
+		expenses = append(expenses, ex)
+	}
+	return expenses, report, "", nil
+}
+
+// isReadError tells a failing body apart from malformed CSV.
+func isReadError(err error) bool {
+	var parseErr *csv.ParseError
+	return err != nil && err != io.EOF && !errors.As(err, &parseErr)
+}
+
+// importBody opens the uploaded file, sent either as the request body or as
+// the multipart "file" field, limited to DefaultMaxImportSize.
+func importBody(c echo.Context) (io.ReadCloser, bool, error) {
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, DefaultMaxImportSize)
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		return c.Request().Body, false, nil
+	}
+	fh, err := c.FormFile("file")
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return nil, true, c.JSON(http.StatusRequestEntityTooLarge, Err{Msg: "Import is too large"})
+	}
+	if err != nil {
+		return nil, true, c.JSON(http.StatusBadRequest, Err{Msg: "Missing file"})
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, true, c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return f, false, nil
+}
+
+// getDryRunParam reads the optional dry_run flag.
+func getDryRunParam(c echo.Context) (bool, bool, error) {
+	if c.QueryParam("dry_run") == "" {
+		return false, false, nil
+	}
+	dryRun, err := strconv.ParseBool(c.QueryParam("dry_run"))
+	if err != nil {
+		return false, true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid dry_run"})
+	}
+	return dryRun, false, nil
+}
+
+// ImportExpensesHandler loads a CSV body, given directly or as the multipart
+// "file" field. With dry_run=true it only reports what would be imported;
+// otherwise every row is imported in one transaction, or none is when any
+// row is invalid.
+func (h Handler) ImportExpensesHandler(c echo.Context) error {
+	mapping, msg := importMapping(c)
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	dryRun, ifErr, respErr := getDryRunParam(c)
+	if ifErr {
+		return respErr
+	}
+	body, ifErr, respErr := importBody(c)
+	if ifErr {
+		return respErr
+	}
+	defer body.Close()
+	expenses, report, msg, err := readImport(body, mapping)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Msg: "Import is too large"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	report.DryRun = dryRun
+	if dryRun {
+		return c.JSON(http.StatusOK, report)
+	}
+	if len(report.Errors) > 0 {
+		return c.JSON(http.StatusUnprocessableEntity, report)
+	}
+	if err := h.Storage.ImportExpenses(c.Request().Context(), expenses); err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	report.Imported = len(expenses)
+	return c.JSON(http.StatusCreated, report)
+}
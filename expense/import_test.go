@@ -0,0 +1,165 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportExpenses(t *testing.T) {
+	e := echo.New()
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	call := func(h Handler, query, contentType string, body *bytes.Buffer) (*httptest.ResponseRecorder, ImportReport) {
+		req := httptest.NewRequest(http.MethodPost, "/expenses/import"+query, body)
+		req.Header.Add(echo.HeaderContentType, contentType)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), alice))
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.ImportExpensesHandler(e.NewContext(req, rec)))
+		report := ImportReport{}
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		return rec, report
+	}
+	stored := func(store *MemoryStore) []Expense {
+		got := []Expense{}
+		store.SelectExpenses(context.Background(), ExpenseQuery{}, func(ex Expense) error {
+			got = append(got, ex)
+			return nil
+		})
+		return got
+	}
+	valid := "\ufeffTitle,Amount,Note,Tags,Currency,Spent_At\n" +
+		"taxi,120.50,client visit,\"travel, client\",thb,2023-01-02\n" +
+		"lunch,80,team,food,,\n"
+	invalid := "title,amount,note,tags,currency\n" +
+		"taxi,120.50,client visit,travel,THB\n" +
+		"lunch,abc,team,food,THB\n" +
+		"\"broken,1,x,y\n"
+
+	t.Run("Valid CSV Return HTTP Status Created", func(t *testing.T) {
+		store := NewMemoryStore()
+		rec, report := call(Handler{Storage: store}, "", "text/csv", bytes.NewBufferString(valid))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, ImportReport{Rows: 2, Imported: 2, Errors: []RowError{}}, report)
+		got := stored(store)
+		if assert.Equal(t, 2, len(got)) {
+			assert.Equal(t, "taxi", got[0].Title)
+			assert.Equal(t, money.MustParse("120.50"), got[0].Amount)
+			assert.Equal(t, []string{"travel", "client"}, got[0].Tags)
+			assert.Equal(t, "THB", got[0].Currency)
+			assert.Equal(t, "2023-01-02", got[0].SpentAt.Format("2006-01-02"))
+			assert.Equal(t, "alice", got[0].OwnerID)
+			assert.Equal(t, StatusDraft, got[1].Status)
+		}
+	})
+
+	t.Run("Dry run reports without importing", func(t *testing.T) {
+		store := NewMemoryStore()
+		rec, report := call(Handler{Storage: store}, "?dry_run=true", "text/csv", bytes.NewBufferString(invalid))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, ImportReport{DryRun: true, Rows: 3, Errors: []RowError{
+			{Row: 3, Message: "Invalid amount"},
+			{Row: 4, Message: "Invalid CSV row"},
+		}}, report)
+		assert.Empty(t, stored(store))
+	})
+
+	t.Run("Invalid rows Return HTTP Status Unprocessable Entity and import nothing", func(t *testing.T) {
+		store := NewMemoryStore()
+		rec, report := call(Handler{Storage: store}, "", "text/csv", bytes.NewBufferString(invalid))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		assert.Equal(t, 2, len(report.Errors))
+		assert.Empty(t, stored(store))
+	})
+
+	t.Run("Mapped columns from a multipart file", func(t *testing.T) {
+		store := NewMemoryStore()
+		contentType, body := uploadBody(t, "bank.csv", []byte("Description,Total,Memo,Category\ntaxi,10,ride,travel\n"))
+		rec, report := call(Handler{Storage: store}, "?map.title=Description&map.amount=total&map.note=Memo&map.tags=Category", contentType, body)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, 1, report.Imported)
+		got := stored(store)
+		if assert.Equal(t, 1, len(got)) {
+			assert.Equal(t, "ride", got[0].Note)
+			assert.Equal(t, money.DefaultCurrency, got[0].Currency)
+		}
+	})
+
+	t.Run("Bad files Return HTTP Status Bad Request", func(t *testing.T) {
+		tests := []struct {
+			name  string
+			query string
+			body  string
+			want  string
+		}{
+			{"Unknown field", "?map.owner=user", valid, "Unknown import field owner"},
+			{"Missing column", "?map.amount=Total", valid, "Missing column Total"},
+			{"Empty file", "", "", "Invalid CSV header"},
+			{"Invalid dry_run", "?dry_run=maybe", valid, "Invalid dry_run"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				rec, _ := call(Handler{Storage: NewMemoryStore()}, tt.query, "text/csv", bytes.NewBufferString(tt.body))
+				got := Err{}
+				json.Unmarshal(rec.Body.Bytes(), &got)
+				assert.Equal(t, http.StatusBadRequest, rec.Code)
+				assert.Equal(t, tt.want, got.Msg)
+			})
+		}
+	})
+}
+
+func TestPostgresImportExpenses(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "alice"})
+	expenses := []Expense{
+		{Title: "taxi", Amount: money.MustParse("120.5"), Note: "client visit", Tags: []string{"travel"}, Currency: "THB"},
+		{Title: "lunch", Amount: money.MustParse("80"), Note: "team", Tags: []string{"food"}, Currency: "THB", SpentAt: testTime},
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE expense_import (.+) ON COMMIT DROP").WillReturnResult(sqlmock.NewResult(0, 0))
+	copyIn := mock.ExpectPrepare(`COPY "expense_import" \("ord", "title", "amount", "note", "tags", "currency", "spent_at", "fitid"\) FROM STDIN`)
+	copyIn.ExpectExec().WithArgs(0, "taxi", expenses[0].Amount.String(), "client visit", "{\"travel\"}", "THB", nil, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	copyIn.ExpectExec().WithArgs(1, "lunch", expenses[1].Amount.String(), "team", "{\"food\"}", "THB", testTime, nil).WillReturnResult(sqlmock.NewResult(0, 0))
+	copyIn.ExpectExec().WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO expenses (.+) SELECT (.+),COALESCE\\(spent_at,now\\(\\)\\),\\$1,fitid FROM expense_import ORDER BY ord ON CONFLICT").
+		WithArgs("alice").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	assert.NoError(t, s.ImportExpenses(ctx, expenses))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestImportExpensesRollsBack(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+
+	mock.ExpectBegin()
+	mock.ExpectExec("CREATE TEMP TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+	copyIn := mock.ExpectPrepare("COPY")
+	copyIn.ExpectExec().WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	err = s.ImportExpenses(context.Background(), []Expense{{Title: "taxi", Tags: []string{"travel"}}})
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
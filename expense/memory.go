@@ -0,0 +1,1063 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+)
+
+// memoryRecord keeps the kind apart from the expense, whose Kind is only
+// set when it is read as a transaction.
+type memoryRecord struct {
+	expense     Expense
+	kind        string
+	deletedAt   *time.Time
+	fitid       string
+	recurringID int
+}
+
+func (r *memoryRecord) transaction() Expense {
+	t := copyExpense(r.expense)
+	t.Kind = r.kind
+	return t
+}
+
+// MemoryStore keeps expenses in process memory. Missing rows are reported
+// with sql.ErrNoRows so handlers treat it exactly like PostgresStore.
+type MemoryStore struct {
+	mu               sync.RWMutex
+	nextID           int
+	records          map[int]*memoryRecord
+	nextAttachmentID int
+	attachments      map[int]Attachment
+	nextBudgetID     int
+	budgets          map[int]*memoryBudget
+	nextRecurringID  int
+	recurring        map[int]*RecurringExpense
+	splits           map[int]Split
+	nextAccountID    int
+	accounts         map[int]Account
+	rates            map[rateKey]money.Rate
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nextID:           1,
+		records:          map[int]*memoryRecord{},
+		nextAttachmentID: 1,
+		attachments:      map[int]Attachment{},
+		nextBudgetID:     1,
+		budgets:          map[int]*memoryBudget{},
+		nextRecurringID:  1,
+		recurring:        map[int]*RecurringExpense{},
+		splits:           map[int]Split{},
+		nextAccountID:    1,
+		accounts:         map[int]Account{},
+		rates:            map[rateKey]money.Rate{},
+	}
+}
+
+func copyExpense(ex Expense) Expense {
+	if ex.Tags != nil {
+		ex.Tags = append([]string{}, ex.Tags...)
+	}
+	if ex.AccountID != nil {
+		id := *ex.AccountID
+		ex.AccountID = &id
+	}
+	return ex
+}
+
+func (m *MemoryStore) activeRecord(ctx context.Context, rowId int) (*memoryRecord, bool) {
+	r, ok := m.records[rowId]
+	if !ok || r.deletedAt != nil || !r.visibleTo(ctx) || r.kind != KindExpense {
+		return nil, false
+	}
+	return r, true
+}
+
+func (m *MemoryStore) activeTransaction(ctx context.Context, id int) (*memoryRecord, bool) {
+	r, ok := m.records[id]
+	if !ok || r.deletedAt != nil || !r.visibleTo(ctx) {
+		return nil, false
+	}
+	return r, true
+}
+
+func (m *MemoryStore) InsertExpense(ctx context.Context, ex *Expense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insert(ctx, ex, time.Now())
+	return nil
+}
+
+func (m *MemoryStore) ImportExpenses(ctx context.Context, expenses []Expense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for i := range expenses {
+		m.insert(ctx, &expenses[i], now)
+	}
+	return nil
+}
+
+func (m *MemoryStore) ImportStatement(ctx context.Context, entries []StatementEntry) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	known := m.statementIDs(ctx)
+	now := time.Now()
+	n := 0
+	for _, e := range entries {
+		if known[e.FITID] {
+			continue
+		}
+		known[e.FITID] = true
+		ex := e.Expense
+		m.insert(ctx, &ex, now).fitid = e.FITID
+		n++
+	}
+	return n, nil
+}
+
+func (m *MemoryStore) SelectStatementIDs(ctx context.Context, fitids []string) (map[string]bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	all := m.statementIDs(ctx)
+	known := map[string]bool{}
+	for _, fitid := range fitids {
+		if all[fitid] {
+			known[fitid] = true
+		}
+	}
+	return known, nil
+}
+
+// statementIDs are the fitids imported by the owner new expenses are
+// created for.
+func (m *MemoryStore) statementIDs(ctx context.Context) map[string]bool {
+	owner := ownerOf(ctx)
+	known := map[string]bool{}
+	for _, r := range m.records {
+		if r.fitid != "" && r.expense.OwnerID == owner {
+			known[r.fitid] = true
+		}
+	}
+	return known
+}
+
+func (m *MemoryStore) insert(ctx context.Context, ex *Expense, now time.Time) *memoryRecord {
+	ex.ID = m.nextID
+	m.nextID++
+	if ex.SpentAt.IsZero() {
+		ex.SpentAt = now
+	}
+	ex.OwnerID = ownerOf(ctx)
+	ex.Status, ex.StatusReason = StatusDraft, ""
+	ex.CreatedAt = now
+	ex.UpdatedAt = now
+	r := &memoryRecord{expense: copyExpense(*ex), kind: KindExpense}
+	r.expense.Kind = ""
+	m.records[ex.ID] = r
+	return r
+}
+
+func (m *MemoryStore) UpdateExpenseByID(ctx context.Context, rowId int, ex *Expense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.activeRecord(ctx, rowId)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if isLocked(r.expense.Status) {
+		return ErrExpenseLocked
+	}
+	ex.ID = rowId
+	ex.OwnerID = r.expense.OwnerID
+	ex.Status, ex.StatusReason = r.expense.Status, r.expense.StatusReason
+	if ex.SpentAt.IsZero() {
+		ex.SpentAt = r.expense.SpentAt
+	}
+	ex.CreatedAt = r.expense.CreatedAt
+	ex.UpdatedAt = time.Now()
+	r.expense = copyExpense(*ex)
+	return nil
+}
+
+func (m *MemoryStore) SelectExpenseByID(ctx context.Context, rowId int, ex *Expense) error {
+	ctx = forReview(ctx)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.activeRecord(ctx, rowId)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*ex = copyExpense(r.expense)
+	return nil
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryRecord) matches(f ExpenseFilter) bool {
+	ex := r.expense
+	if len(f.Kinds) == 0 && r.kind != KindExpense || len(f.Kinds) > 0 && !hasTag(f.Kinds, r.kind) {
+		return false
+	}
+	if f.Owner != "" && ex.OwnerID != f.Owner {
+		return false
+	}
+	if f.Status != "" && ex.Status != f.Status {
+		return false
+	}
+	if f.AccountID != nil && !sameID(ex.AccountID, *f.AccountID) && !sameID(ex.ToAccountID, *f.AccountID) {
+		return false
+	}
+	if len(f.Tags) > 0 {
+		matched := 0
+		for _, tag := range f.Tags {
+			if hasTag(ex.Tags, tag) {
+				matched++
+			}
+		}
+		if matched == 0 || (f.MatchAllTags && matched < len(f.Tags)) {
+			return false
+		}
+	}
+	if f.MinAmount != nil && ex.Amount.Cmp(*f.MinAmount) < 0 {
+		return false
+	}
+	if f.MaxAmount != nil && ex.Amount.Cmp(*f.MaxAmount) > 0 {
+		return false
+	}
+	if !f.Spent.Contains(ex.SpentAt) || !f.Created.Contains(ex.CreatedAt) || !f.Updated.Contains(ex.UpdatedAt) {
+		return false
+	}
+	if f.Text != "" {
+		text := strings.ToLower(f.Text)
+		if !strings.Contains(strings.ToLower(ex.Title), text) && !strings.Contains(strings.ToLower(ex.Note), text) {
+			return false
+		}
+	}
+	return true
+}
+
+func compareTimes(a, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	}
+	return 0
+}
+
+func compareRecords(a, b *memoryRecord, order []SortField) int {
+	for _, f := range order {
+		c := 0
+		switch f.Column {
+		case "id":
+			c = a.expense.ID - b.expense.ID
+		case "title":
+			c = strings.Compare(a.expense.Title, b.expense.Title)
+		case "amount":
+			c = a.expense.Amount.Cmp(b.expense.Amount)
+		case "spent_at":
+			c = compareTimes(a.expense.SpentAt, b.expense.SpentAt)
+		case "created_at":
+			c = compareTimes(a.expense.CreatedAt, b.expense.CreatedAt)
+		case "updated_at":
+			c = compareTimes(a.expense.UpdatedAt, b.expense.UpdatedAt)
+		}
+		if c != 0 {
+			if f.Desc {
+				return -c
+			}
+			return c
+		}
+	}
+	return 0
+}
+
+func (m *MemoryStore) SelectExpenses(ctx context.Context, q ExpenseQuery, each func(Expense) error) error {
+	ctx = forReview(ctx)
+	return m.selectRecords(ctx, q, func(r *memoryRecord) Expense {
+		return copyExpense(r.expense)
+	}, each)
+}
+
+// selectRecords calls each with the view of every record matching q.
+func (m *MemoryStore) selectRecords(ctx context.Context, q ExpenseQuery, view func(*memoryRecord) Expense, each func(Expense) error) error {
+	if owner, scoped := ownerScope(ctx); scoped {
+		q.Filter.Owner = owner
+	}
+	m.mu.RLock()
+	order := orderWithID(q.Sort)
+	after, ok := m.records[q.AfterID]
+	if !ok || after.deletedAt != nil || (q.Filter.Owner != "" && after.expense.OwnerID != q.Filter.Owner) {
+		if q.AfterID > 0 && usesCursorRow(q) {
+			m.mu.RUnlock()
+			return errInvalidCursor
+		}
+		// paging by id alone only needs the id
+		after = &memoryRecord{expense: Expense{ID: q.AfterID}}
+	}
+	matched := []*memoryRecord{}
+	for _, r := range m.records {
+		if r.deletedAt != nil || !r.matches(q.Filter) {
+			continue
+		}
+		if q.AfterID > 0 && compareRecords(r, after, order) <= 0 {
+			continue
+		}
+		matched = append(matched, r)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return compareRecords(matched[i], matched[j], order) < 0
+	})
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+	}
+	expenses := make([]Expense, 0, len(matched))
+	for _, r := range matched {
+		expenses = append(expenses, view(r))
+	}
+	m.mu.RUnlock()
+
+	for _, ex := range expenses {
+		if err := each(ex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) SummarizeExpenses(ctx context.Context, f ExpenseFilter, groupBy, currency string) ([]SummaryGroup, error) {
+	expenses := []Expense{}
+	err := m.SelectExpenses(ctx, ExpenseQuery{Filter: f}, func(ex Expense) error {
+		expenses = append(expenses, ex)
+		return nil
+	})
+	if err == nil {
+		err = convertExpenses(ctx, m, currency, expenses)
+	}
+	return summarize(expenses, groupBy), err
+}
+
+// SearchExpenses is a naive stand-in for ts_rank_cd: rows matching more of
+// the terms rank first, then rows where matches make up more of the text.
+func (m *MemoryStore) SearchExpenses(ctx context.Context, terms []string, limit int, each func(SearchResult) error) error {
+	m.mu.RLock()
+	results := []SearchResult{}
+	for _, r := range m.records {
+		if r.deletedAt != nil || !r.visibleTo(ctx) || r.kind != KindExpense {
+			continue
+		}
+		title, titleHits := highlightText(r.expense.Title, terms)
+		note, noteHits := highlightText(r.expense.Note, terms)
+		if titleHits+noteHits == 0 {
+			continue
+		}
+		words := searchTerms(r.expense.Title + " " + r.expense.Note)
+		matched := 0
+		for _, t := range terms {
+			for _, w := range words {
+				if strings.HasPrefix(w, t) {
+					matched++
+					break
+				}
+			}
+		}
+		results = append(results, SearchResult{
+			Expense:   copyExpense(r.expense),
+			Rank:      float64(matched) + float64(titleHits+noteHits)/float64(len(words)+1),
+			Highlight: SearchHighlight{Title: title, Note: note},
+		})
+	}
+	m.mu.RUnlock()
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Rank != results[j].Rank {
+			return results[i].Rank > results[j].Rank
+		}
+		return results[i].ID < results[j].ID
+	})
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	for _, r := range results {
+		if err := each(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpenseByID(ctx context.Context, rowId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.activeRecord(ctx, rowId)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if isLocked(r.expense.Status) {
+		return ErrExpenseLocked
+	}
+	now := time.Now()
+	r.deletedAt = &now
+	return nil
+}
+
+func (m *MemoryStore) RestoreExpenseByID(ctx context.Context, rowId int, ex *Expense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.records[rowId]
+	if !ok || r.deletedAt == nil || !r.visibleTo(ctx) || r.kind != KindExpense {
+		return sql.ErrNoRows
+	}
+	r.deletedAt = nil
+	*ex = copyExpense(r.expense)
+	return nil
+}
+
+func (m *MemoryStore) TransitionExpenseByID(ctx context.Context, rowId int, t Transition, ex *Expense) error {
+	ctx = forReview(ctx)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.activeRecord(ctx, rowId)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if !t.Allows(r.expense.Status) {
+		return ErrIllegalTransition
+	}
+	r.expense.Status, r.expense.StatusReason = t.To, t.Reason
+	r.expense.UpdatedAt = time.Now()
+	*ex = copyExpense(r.expense)
+	return nil
+}
+
+func (m *MemoryStore) InsertAttachment(ctx context.Context, a *Attachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.activeRecord(ctx, a.ExpenseID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if isLocked(r.expense.Status) {
+		return ErrExpenseLocked
+	}
+	a.ID = m.nextAttachmentID
+	m.nextAttachmentID++
+	a.CreatedAt = time.Now()
+	m.attachments[a.ID] = *a
+	return nil
+}
+
+func (m *MemoryStore) SelectAttachments(ctx context.Context, expenseID int) ([]Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	attachments := []Attachment{}
+	if _, ok := m.activeRecord(ctx, expenseID); !ok {
+		return attachments, nil
+	}
+	for _, a := range m.attachments {
+		if a.ExpenseID == expenseID {
+			attachments = append(attachments, a)
+		}
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		return attachments[i].ID < attachments[j].ID
+	})
+	return attachments, nil
+}
+
+func (m *MemoryStore) SelectAttachmentByID(ctx context.Context, expenseID, id int, a *Attachment) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found, ok := m.attachments[id]
+	if _, visible := m.activeRecord(ctx, expenseID); !ok || !visible || found.ExpenseID != expenseID {
+		return sql.ErrNoRows
+	}
+	*a = found
+	return nil
+}
+
+func (m *MemoryStore) DeleteAttachmentByID(ctx context.Context, expenseID, id int, a *Attachment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, visible := m.activeRecord(ctx, expenseID)
+	found, ok := m.attachments[id]
+	if !ok || !visible || found.ExpenseID != expenseID {
+		return sql.ErrNoRows
+	}
+	if isLocked(r.expense.Status) {
+		return ErrExpenseLocked
+	}
+	delete(m.attachments, id)
+	*a = found
+	return nil
+}
+
+func (m *MemoryStore) PurgeDeletedExpenses(ctx context.Context, deletedBefore time.Time) (int64, []string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var purged int64
+	keys := []string{}
+	for id, r := range m.records {
+		if r.deletedAt != nil && r.deletedAt.Before(deletedBefore) {
+			delete(m.records, id)
+			purged++
+			for attachmentID, a := range m.attachments {
+				if a.ExpenseID == id {
+					delete(m.attachments, attachmentID)
+					keys = append(keys, a.StorageKey)
+				}
+			}
+		}
+	}
+	return purged, keys, nil
+}
+
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+type memoryBudget struct {
+	budget        Budget
+	alertedPeriod string
+}
+
+func copyBudget(b Budget) Budget {
+	b.Tags = append([]string{}, b.Tags...)
+	return b
+}
+
+func (m *MemoryStore) visibleBudget(ctx context.Context, id int) (*memoryBudget, bool) {
+	r, ok := m.budgets[id]
+	if !ok {
+		return nil, false
+	}
+	owner, scoped := ownerScope(ctx)
+	return r, !scoped || r.budget.OwnerID == owner
+}
+
+func (m *MemoryStore) InsertBudget(ctx context.Context, b *Budget) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	b.ID = m.nextBudgetID
+	m.nextBudgetID++
+	b.OwnerID = ownerOf(ctx)
+	b.CreatedAt, b.UpdatedAt = now, now
+	m.budgets[b.ID] = &memoryBudget{budget: copyBudget(*b)}
+	return nil
+}
+
+func (m *MemoryStore) SelectBudgetByID(ctx context.Context, id int, b *Budget) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.visibleBudget(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*b = copyBudget(r.budget)
+	return nil
+}
+
+func (m *MemoryStore) SelectBudgets(ctx context.Context) ([]Budget, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	budgets := []Budget{}
+	for id := range m.budgets {
+		if r, ok := m.visibleBudget(ctx, id); ok {
+			budgets = append(budgets, copyBudget(r.budget))
+		}
+	}
+	sort.Slice(budgets, func(i, j int) bool {
+		return budgets[i].ID < budgets[j].ID
+	})
+	return budgets, nil
+}
+
+func (m *MemoryStore) UpdateBudgetByID(ctx context.Context, id int, b *Budget) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.visibleBudget(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	b.ID, b.OwnerID, b.CreatedAt = id, r.budget.OwnerID, r.budget.CreatedAt
+	b.UpdatedAt = time.Now()
+	r.budget = copyBudget(*b)
+	r.alertedPeriod = ""
+	return nil
+}
+
+func (m *MemoryStore) DeleteBudgetByID(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.visibleBudget(ctx, id); !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.budgets, id)
+	return nil
+}
+
+func (m *MemoryStore) SpentInBudget(ctx context.Context, b Budget, from, to time.Time) (money.Amount, int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	spent, count := money.Amount{}, 0
+	for _, r := range m.records {
+		ex := r.expense
+		if r.deletedAt != nil || r.kind != KindExpense || !b.counts(ex) || ex.SpentAt.Before(from) || !ex.SpentAt.Before(to) {
+			continue
+		}
+		spent = spent.Add(ex.Amount)
+		count++
+	}
+	return spent, count, nil
+}
+
+func (m *MemoryStore) MarkBudgetAlerted(ctx context.Context, id int, period string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.budgets[id]
+	if !ok || r.alertedPeriod == period {
+		return false, nil
+	}
+	r.alertedPeriod = period
+	return true, nil
+}
+
+func copyRecurring(r RecurringExpense) RecurringExpense {
+	r.Tags = append([]string{}, r.Tags...)
+	return r
+}
+
+func (m *MemoryStore) visibleRecurring(ctx context.Context, id int) (*RecurringExpense, bool) {
+	r, ok := m.recurring[id]
+	if !ok {
+		return nil, false
+	}
+	owner, scoped := ownerScope(ctx)
+	return r, !scoped || r.OwnerID == owner
+}
+
+func (m *MemoryStore) InsertRecurring(ctx context.Context, r *RecurringExpense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	r.ID = m.nextRecurringID
+	m.nextRecurringID++
+	r.OwnerID = ownerOf(ctx)
+	r.CreatedAt, r.UpdatedAt = now, now
+	saved := copyRecurring(*r)
+	m.recurring[r.ID] = &saved
+	return nil
+}
+
+func (m *MemoryStore) SelectRecurringByID(ctx context.Context, id int, r *RecurringExpense) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	saved, ok := m.visibleRecurring(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*r = copyRecurring(*saved)
+	return nil
+}
+
+func (m *MemoryStore) SelectRecurring(ctx context.Context) ([]RecurringExpense, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	templates := []RecurringExpense{}
+	for id := range m.recurring {
+		if r, ok := m.visibleRecurring(ctx, id); ok {
+			templates = append(templates, copyRecurring(*r))
+		}
+	}
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].ID < templates[j].ID
+	})
+	return templates, nil
+}
+
+func (m *MemoryStore) UpdateRecurringByID(ctx context.Context, id int, r *RecurringExpense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	saved, ok := m.visibleRecurring(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	r.ID, r.OwnerID, r.CreatedAt = id, saved.OwnerID, saved.CreatedAt
+	r.UpdatedAt = time.Now()
+	*saved = copyRecurring(*r)
+	return nil
+}
+
+func (m *MemoryStore) DeleteRecurringByID(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.visibleRecurring(ctx, id); !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.recurring, id)
+	return nil
+}
+
+func (m *MemoryStore) MaterializeRecurring(ctx context.Context, now time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]int, 0, len(m.recurring))
+	for id := range m.recurring {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	var created int64
+	for _, id := range ids {
+		r := m.recurring[id]
+		for _, spentAt := range r.due(now) {
+			if m.hasOccurrence(id, spentAt) {
+				continue
+			}
+			ex := r.occurrenceExpense(spentAt)
+			ex.ID = m.nextID
+			m.nextID++
+			ex.CreatedAt, ex.UpdatedAt = now, now
+			m.records[ex.ID] = &memoryRecord{expense: ex, kind: KindExpense, recurringID: id}
+			created++
+		}
+	}
+	return created, nil
+}
+
+func (m *MemoryStore) hasOccurrence(recurringID int, spentAt time.Time) bool {
+	for _, r := range m.records {
+		if r.recurringID == recurringID && r.expense.SpentAt.Equal(spentAt) {
+			return true
+		}
+	}
+	return false
+}
+
+func copySplit(s Split) Split {
+	s.Shares = append([]Share{}, s.Shares...)
+	return s
+}
+
+func (m *MemoryStore) ReplaceSplit(ctx context.Context, s *Split) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.activeRecord(ctx, s.ExpenseID)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if isLocked(r.expense.Status) {
+		return ErrExpenseLocked
+	}
+	s.Payer, s.Currency = r.expense.OwnerID, r.expense.Currency
+	m.splits[s.ExpenseID] = copySplit(*s)
+	return nil
+}
+
+func (m *MemoryStore) SelectSplit(ctx context.Context, expenseID int, s *Split) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	saved, ok := m.splits[expenseID]
+	if _, visible := m.activeRecord(ctx, expenseID); !ok || !visible {
+		return sql.ErrNoRows
+	}
+	*s = copySplit(saved)
+	return nil
+}
+
+func (m *MemoryStore) DeleteSplit(ctx context.Context, expenseID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.splits[expenseID]
+	if _, visible := m.activeRecord(ctx, expenseID); !ok || !visible {
+		return sql.ErrNoRows
+	}
+	delete(m.splits, expenseID)
+	return nil
+}
+
+func (m *MemoryStore) SelectSplits(ctx context.Context) ([]Split, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	owner, scoped := ownerScope(ctx)
+	splits := []Split{}
+	for id, s := range m.splits {
+		r, ok := m.records[id]
+		if !ok || r.deletedAt != nil || r.expense.Status == StatusRejected {
+			continue
+		}
+		if scoped && s.Payer != owner && !s.hasParticipant(owner) {
+			continue
+		}
+		splits = append(splits, copySplit(s))
+	}
+	sort.Slice(splits, func(i, j int) bool {
+		return splits[i].ExpenseID < splits[j].ExpenseID
+	})
+	return splits, nil
+}
+
+func (m *MemoryStore) visibleAccount(ctx context.Context, id int) (Account, bool) {
+	a, ok := m.accounts[id]
+	if !ok {
+		return Account{}, false
+	}
+	owner, scoped := ownerScope(ctx)
+	return a, !scoped || a.OwnerID == owner
+}
+
+// accountInUse reports whether any transaction, even a deleted one not yet
+// purged, is into or out of the account.
+func (m *MemoryStore) accountInUse(id int) bool {
+	for _, r := range m.records {
+		if sameID(r.expense.AccountID, id) || sameID(r.expense.ToAccountID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// accountEntries are the active transactions into or out of the account, in
+// spent_at order. Rejected expenses were never paid, so they are left out.
+func (m *MemoryStore) accountEntries(id int) []Expense {
+	expenses := []Expense{}
+	for _, r := range m.records {
+		if r.deletedAt != nil || r.expense.Status == StatusRejected {
+			continue
+		}
+		if sameID(r.expense.AccountID, id) || sameID(r.expense.ToAccountID, id) {
+			expenses = append(expenses, r.transaction())
+		}
+	}
+	sort.Slice(expenses, func(i, j int) bool {
+		if !expenses[i].SpentAt.Equal(expenses[j].SpentAt) {
+			return expenses[i].SpentAt.Before(expenses[j].SpentAt)
+		}
+		return expenses[i].ID < expenses[j].ID
+	})
+	return expenses
+}
+
+func (m *MemoryStore) withBalance(a Account) Account {
+	a.Balance = a.OpeningBalance
+	for _, t := range m.accountEntries(a.ID) {
+		a.Balance = a.Balance.Add(t.balanceChange(a.ID))
+	}
+	return a
+}
+
+func (m *MemoryStore) InsertAccount(ctx context.Context, a *Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	a.ID = m.nextAccountID
+	m.nextAccountID++
+	a.OwnerID = ownerOf(ctx)
+	a.Balance = a.OpeningBalance
+	a.CreatedAt, a.UpdatedAt = now, now
+	m.accounts[a.ID] = *a
+	return nil
+}
+
+func (m *MemoryStore) SelectAccountByID(ctx context.Context, id int, a *Account) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	found, ok := m.visibleAccount(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*a = m.withBalance(found)
+	return nil
+}
+
+func (m *MemoryStore) SelectAccounts(ctx context.Context) ([]Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	accounts := []Account{}
+	for id := range m.accounts {
+		if a, ok := m.visibleAccount(ctx, id); ok {
+			accounts = append(accounts, m.withBalance(a))
+		}
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].ID < accounts[j].ID
+	})
+	return accounts, nil
+}
+
+func (m *MemoryStore) UpdateAccountByID(ctx context.Context, id int, a *Account) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.visibleAccount(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if a.Currency != old.Currency && m.accountInUse(id) {
+		return ErrAccountInUse
+	}
+	a.ID, a.OwnerID, a.CreatedAt = id, old.OwnerID, old.CreatedAt
+	a.UpdatedAt = time.Now()
+	m.accounts[id] = *a
+	*a = m.withBalance(*a)
+	return nil
+}
+
+func (m *MemoryStore) DeleteAccountByID(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.visibleAccount(ctx, id); !ok {
+		return sql.ErrNoRows
+	}
+	if m.accountInUse(id) {
+		return ErrAccountInUse
+	}
+	delete(m.accounts, id)
+	return nil
+}
+
+func (m *MemoryStore) SelectAccountStatement(ctx context.Context, id int, spent TimeRange, st *AccountStatement) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	a, ok := m.visibleAccount(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	st.Account = m.withBalance(a)
+	st.From, st.To, st.Entries = spent.From, spent.To, []AccountEntry{}
+	st.OpeningBalance = a.OpeningBalance
+	for _, t := range m.accountEntries(id) {
+		if spent.From != nil && t.SpentAt.Before(*spent.From) {
+			st.OpeningBalance = st.OpeningBalance.Add(t.balanceChange(id))
+		}
+	}
+	st.ClosingBalance = st.OpeningBalance
+	for _, t := range m.accountEntries(id) {
+		if !spent.Contains(t.SpentAt) {
+			continue
+		}
+		st.ClosingBalance = st.ClosingBalance.Add(t.balanceChange(id))
+		st.Entries = append(st.Entries, AccountEntry{Expense: t, Balance: st.ClosingBalance})
+	}
+	return nil
+}
+
+func (m *MemoryStore) InsertTransaction(ctx context.Context, t *Expense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.insert(ctx, t, time.Now()).kind = t.Kind
+	return nil
+}
+
+func (m *MemoryStore) SelectTransactionByID(ctx context.Context, id int, t *Expense) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.activeTransaction(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	*t = r.transaction()
+	return nil
+}
+
+func (m *MemoryStore) UpdateTransactionByID(ctx context.Context, id int, t *Expense) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.activeTransaction(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if isLocked(r.expense.Status) {
+		return ErrExpenseLocked
+	}
+	t.ID, t.OwnerID, t.Kind = id, r.expense.OwnerID, r.kind
+	t.Status, t.StatusReason = r.expense.Status, r.expense.StatusReason
+	if t.SpentAt.IsZero() {
+		t.SpentAt = r.expense.SpentAt
+	}
+	t.CreatedAt = r.expense.CreatedAt
+	t.UpdatedAt = time.Now()
+	r.expense = copyExpense(*t)
+	r.expense.Kind = ""
+	return nil
+}
+
+func (m *MemoryStore) DeleteTransactionByID(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r, ok := m.activeTransaction(ctx, id)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if isLocked(r.expense.Status) {
+		return ErrExpenseLocked
+	}
+	now := time.Now()
+	r.deletedAt = &now
+	return nil
+}
+
+func (m *MemoryStore) SelectTransactions(ctx context.Context, q ExpenseQuery, each func(Expense) error) error {
+	return m.selectRecords(ctx, q, (*memoryRecord).transaction, each)
+}
+
+func (m *MemoryStore) SummarizeFlow(ctx context.Context, f ExpenseFilter, groupBy, currency string) ([]FlowGroup, error) {
+	transactions := []Expense{}
+	err := m.SelectTransactions(ctx, ExpenseQuery{Filter: f}, func(t Expense) error {
+		transactions = append(transactions, t)
+		return nil
+	})
+	if err == nil {
+		err = convertExpenses(ctx, m, currency, transactions)
+	}
+	return flow(transactions, groupBy), err
+}
+
+type rateKey struct{ base, quote, date string }
+
+func (m *MemoryStore) SaveRates(ctx context.Context, rates []ExchangeRate) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range rates {
+		m.rates[rateKey{r.Base, r.Quote, r.Date}] = r.Rate
+	}
+	return nil
+}
+
+func (m *MemoryStore) SelectRates(ctx context.Context, currency string) ([]ExchangeRate, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	rates := []ExchangeRate{}
+	for k, rate := range m.rates {
+		if currency == "" || k.base == currency || k.quote == currency {
+			rates = append(rates, ExchangeRate{Base: k.base, Quote: k.quote, Date: k.date, Rate: rate})
+		}
+	}
+	sort.Slice(rates, func(i, j int) bool {
+		a, b := rates[i], rates[j]
+		if a.Base != b.Base {
+			return a.Base < b.Base
+		}
+		if a.Quote != b.Quote {
+			return a.Quote < b.Quote
+		}
+		return a.Date < b.Date
+	})
+	return rates, nil
+}
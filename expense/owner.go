@@ -0,0 +1,45 @@
+package expense
+
+import (
+	"context"
+
+	"github.com/Temwalker/assessment/auth"
+)
+
+// ownerOf is the owner new expenses are created for.
+func ownerOf(ctx context.Context) string {
+	if p, ok := auth.PrincipalFrom(ctx); ok {
+		return p.Subject
+	}
+	return auth.DefaultSubject
+}
+
+// ownerScope returns the owner every store query must be restricted to.
+// Admins and calls without a principal, such as the purge job, see every
+// owner's rows, and so do approvers while reviewing.
+func ownerScope(ctx context.Context) (string, bool) {
+	p, ok := auth.PrincipalFrom(ctx)
+	if !ok || p.HasRole(auth.RoleAdmin) || p.HasRole(auth.RoleApprover) && reviewing(ctx) {
+		return "", false
+	}
+	return p.Subject, true
+}
+
+type reviewKey struct{}
+
+// forReview marks ctx as reading expenses or moving them through the
+// approval workflow, which approvers do for every owner. Anything else they
+// do stays limited to their own rows.
+func forReview(ctx context.Context) context.Context {
+	return context.WithValue(ctx, reviewKey{}, true)
+}
+
+func reviewing(ctx context.Context) bool {
+	review, _ := ctx.Value(reviewKey{}).(bool)
+	return review
+}
+
+func (r *memoryRecord) visibleTo(ctx context.Context) bool {
+	owner, scoped := ownerScope(ctx)
+	return !scoped || r.expense.OwnerID == owner
+}
@@ -0,0 +1,89 @@
+package expense
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var errInvalidCursor = errors.New("invalid cursor")
+
+type ExpensePage struct {
+	Expenses   []Expense `json:"expenses"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// expenseCursor names the last row of a page. Sort is kept so a cursor can
+// not be replayed against a different ordering.
+type expenseCursor struct {
+	ID   int    `json:"id"`
+	Sort string `json:"sort,omitempty"`
+}
+
+func encodeCursor(cur expenseCursor) string {
+	data, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (expenseCursor, error) {
+	cur := expenseCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cur, errInvalidCursor
+	}
+	if err := json.Unmarshal(data, &cur); err != nil || cur.ID <= 0 {
+		return expenseCursor{}, errInvalidCursor
+	}
+	return cur, nil
+}
+
+// getListParams reads filters, sort, limit and cursor from the query string.
+// paged is false when the caller asked for neither limit nor cursor, which
+// keeps the legacy full listing.
+func getListParams(c echo.Context) (q ExpenseQuery, paged bool, ifErr bool, respErr error) {
+	q.Filter, q.Sort, ifErr, respErr = getFilterParams(c)
+	if ifErr {
+		return q, false, ifErr, respErr
+	}
+	limit := c.QueryParam("limit")
+	cursor := c.QueryParam("cursor")
+	if limit == "" && cursor == "" {
+		return q, false, false, nil
+	}
+	q.Limit = DefaultPageLimit
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > MaxPageLimit {
+			return q, true, true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid limit"})
+		}
+		q.Limit = n
+	}
+	if cursor != "" {
+		cur, err := decodeCursor(cursor)
+		if err != nil || cur.Sort != sortKey(q.Sort) {
+			return q, true, true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid cursor"})
+		}
+		q.AfterID = cur.ID
+	}
+	return q, true, false, nil
+}
+
+func nextPageLink(c echo.Context, cursor string) string {
+	params := url.Values{}
+	for k, v := range c.QueryParams() {
+		params[k] = v
+	}
+	params.Set("cursor", cursor)
+	u := url.URL{Path: c.Request().URL.Path, RawQuery: params.Encode()}
+	return "<" + u.String() + `>; rel="next"`
+}
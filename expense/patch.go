@@ -0,0 +1,425 @@
+package expense
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"mime"
+	"strconv"
+	"strings"
+)
+
+const (
+	MIMEMergePatch = "application/merge-patch+json"
+	MIMEJSONPatch  = "application/json-patch+json"
+)
+
+// maxPatchSize bounds a patch document.
+const maxPatchSize = 1 << 20
+
+var (
+	errUnsupportedPatch = errors.New("unsupported patch content type")
+	errInvalidPatch     = errors.New("invalid patch document")
+	errPatchTestFailed  = errors.New("patch test operation failed")
+)
+
+// applyPatch applies a JSON Merge Patch (RFC 7396) or JSON Patch (RFC 6902)
+// document, chosen by contentType, to ex and returns the patched expense.
+func applyPatch(contentType string, ex Expense, patch []byte) (Expense, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Expense{}, errUnsupportedPatch
+	}
+	doc, err := toJSONDocument(ex)
+	if err != nil {
+		return Expense{}, err
+	}
+	switch mediaType {
+	case MIMEMergePatch:
+		p, err := decodeJSON(patch)
+		if err != nil {
+			return Expense{}, errInvalidPatch
+		}
+		doc = mergePatch(doc, p)
+	case MIMEJSONPatch:
+		doc, err = jsonPatch(doc, patch)
+		if err != nil {
+			return Expense{}, err
+		}
+	default:
+		return Expense{}, errUnsupportedPatch
+	}
+	return fromJSONDocument(doc)
+}
+
+func decodeJSON(data []byte) (interface{}, error) {
+	var v interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errInvalidPatch
+	}
+	return v, nil
+}
+
+func toJSONDocument(ex Expense) (interface{}, error) {
+	data, err := json.Marshal(ex)
+	if err != nil {
+		return nil, err
+	}
+	return decodeJSON(data)
+}
+
+func fromJSONDocument(doc interface{}) (Expense, error) {
+	if _, ok := doc.(map[string]interface{}); !ok {
+		return Expense{}, errInvalidPatch
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return Expense{}, errInvalidPatch
+	}
+	ex := Expense{}
+	if err := json.Unmarshal(data, &ex); err != nil {
+		return Expense{}, errInvalidPatch
+	}
+	return ex, nil
+}
+
+func mergePatch(target, patch interface{}) interface{} {
+	p, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]interface{})
+	if !ok {
+		t = map[string]interface{}{}
+	}
+	for k, v := range p {
+		if v == nil {
+			delete(t, k)
+			continue
+		}
+		t[k] = mergePatch(t[k], v)
+	}
+	return t
+}
+
+func jsonPatch(doc interface{}, patch []byte) (interface{}, error) {
+	var ops []map[string]json.RawMessage
+	if err := json.Unmarshal(patch, &ops); err != nil {
+		return nil, errInvalidPatch
+	}
+	for _, op := range ops {
+		var err error
+		doc, err = applyOperation(doc, op)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return doc, nil
+}
+
+func operationString(op map[string]json.RawMessage, member string) (string, error) {
+	raw, ok := op[member]
+	if !ok {
+		return "", errInvalidPatch
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return "", errInvalidPatch
+	}
+	return s, nil
+}
+
+func operationValue(op map[string]json.RawMessage) (interface{}, error) {
+	raw, ok := op["value"]
+	if !ok {
+		return nil, errInvalidPatch
+	}
+	v, err := decodeJSON(raw)
+	if err != nil {
+		return nil, errInvalidPatch
+	}
+	return v, nil
+}
+
+func applyOperation(doc interface{}, op map[string]json.RawMessage) (interface{}, error) {
+	name, err := operationString(op, "op")
+	if err != nil {
+		return nil, err
+	}
+	path, err := operationString(op, "path")
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "add", "replace", "test":
+		value, err := operationValue(op)
+		if err != nil {
+			return nil, err
+		}
+		if name == "add" {
+			return addValue(doc, tokens, value)
+		}
+		if name == "replace" {
+			return replaceValue(doc, tokens, value)
+		}
+		current, err := getValue(doc, tokens)
+		if err != nil {
+			return nil, err
+		}
+		if !jsonEqual(current, value) {
+			return nil, errPatchTestFailed
+		}
+		return doc, nil
+	case "remove":
+		return removeValue(doc, tokens)
+	case "move", "copy":
+		from, err := operationString(op, "from")
+		if err != nil {
+			return nil, err
+		}
+		fromTokens, err := parsePointer(from)
+		if err != nil {
+			return nil, err
+		}
+		value, err := getValue(doc, fromTokens)
+		if err != nil {
+			return nil, err
+		}
+		if name == "copy" {
+			return addValue(doc, tokens, deepCopy(value))
+		}
+		if from == path {
+			return doc, nil
+		}
+		if strings.HasPrefix(path, from+"/") {
+			return nil, errInvalidPatch
+		}
+		doc, err = removeValue(doc, fromTokens)
+		if err != nil {
+			return nil, err
+		}
+		return addValue(doc, tokens, value)
+	}
+	return nil, errInvalidPatch
+}
+
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, errInvalidPatch
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, errInvalidPatch
+	}
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, errInvalidPatch
+	}
+	if i > length || (i == length && !allowEnd) {
+		return 0, errInvalidPatch
+	}
+	return i, nil
+}
+
+func getValue(doc interface{}, tokens []string) (interface{}, error) {
+	for _, t := range tokens {
+		switch n := doc.(type) {
+		case map[string]interface{}:
+			v, ok := n[t]
+			if !ok {
+				return nil, errInvalidPatch
+			}
+			doc = v
+		case []interface{}:
+			i, err := arrayIndex(t, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = n[i]
+		default:
+			return nil, errInvalidPatch
+		}
+	}
+	return doc, nil
+}
+
+// updateIn walks to the container holding the last token and lets update
+// return its replacement, so array inserts and removals can reallocate.
+func updateIn(node interface{}, tokens []string, update func(container interface{}, key string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return update(node, tokens[0])
+	}
+	switch n := node.(type) {
+	case map[string]interface{}:
+		child, ok := n[tokens[0]]
+		if !ok {
+			return nil, errInvalidPatch
+		}
+		child, err := updateIn(child, tokens[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		n[tokens[0]] = child
+		return n, nil
+	case []interface{}:
+		i, err := arrayIndex(tokens[0], len(n), false)
+		if err != nil {
+			return nil, err
+		}
+		child, err := updateIn(n[i], tokens[1:], update)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = child
+		return n, nil
+	}
+	return nil, errInvalidPatch
+}
+
+func addValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateIn(doc, tokens, func(container interface{}, key string) (interface{}, error) {
+		switch n := container.(type) {
+		case map[string]interface{}:
+			n[key] = value
+			return n, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(n), true)
+			if err != nil {
+				return nil, err
+			}
+			n = append(n, nil)
+			copy(n[i+1:], n[i:])
+			n[i] = value
+			return n, nil
+		}
+		return nil, errInvalidPatch
+	})
+}
+
+func removeValue(doc interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, errInvalidPatch
+	}
+	return updateIn(doc, tokens, func(container interface{}, key string) (interface{}, error) {
+		switch n := container.(type) {
+		case map[string]interface{}:
+			if _, ok := n[key]; !ok {
+				return nil, errInvalidPatch
+			}
+			delete(n, key)
+			return n, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(n[:i], n[i+1:]...), nil
+		}
+		return nil, errInvalidPatch
+	})
+}
+
+func replaceValue(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateIn(doc, tokens, func(container interface{}, key string) (interface{}, error) {
+		switch n := container.(type) {
+		case map[string]interface{}:
+			if _, ok := n[key]; !ok {
+				return nil, errInvalidPatch
+			}
+			n[key] = value
+			return n, nil
+		case []interface{}:
+			i, err := arrayIndex(key, len(n), false)
+			if err != nil {
+				return nil, err
+			}
+			n[i] = value
+			return n, nil
+		}
+		return nil, errInvalidPatch
+	})
+}
+
+func deepCopy(v interface{}) interface{} {
+	switch n := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for k, child := range n {
+			m[k] = deepCopy(child)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(n))
+		for i, child := range n {
+			s[i] = deepCopy(child)
+		}
+		return s
+	}
+	return v
+}
+
+func jsonEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case map[string]interface{}:
+		y, ok := b.(map[string]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !jsonEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		y, ok := b.([]interface{})
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !jsonEqual(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case json.Number:
+		y, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		fx, okx := new(big.Float).SetString(x.String())
+		fy, oky := new(big.Float).SetString(y.String())
+		return okx && oky && fx.Cmp(fy) == 0
+	}
+	return a == b
+}
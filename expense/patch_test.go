@@ -0,0 +1,65 @@
+//go:build unit
+
+package expense
+
+import (
+	"testing"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyPatch(t *testing.T) {
+	seed := Expense{
+		ID:       1,
+		Title:    "strawberry smoothie",
+		Amount:   money.FromInt(79),
+		Currency: "THB",
+		Note:     "night market promotion discount 10 bath",
+		Tags:     []string{"food", "beverage"},
+	}
+	tests := []struct {
+		testname    string
+		contentType string
+		patch       string
+		want        Expense
+		wantErr     error
+	}{
+		{"Merge Patch change only amount", MIMEMergePatch, `{"amount": 89}`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(89), Currency: "THB", Note: seed.Note, Tags: seed.Tags}, nil},
+		{"Merge Patch with charset parameter replace tags", MIMEMergePatch + "; charset=utf-8", `{"tags": ["gadget"]}`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(79), Currency: "THB", Note: seed.Note, Tags: []string{"gadget"}}, nil},
+		{"Merge Patch null removes note", MIMEMergePatch, `{"note": null}`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(79), Currency: "THB", Tags: seed.Tags}, nil},
+		{"JSON Patch replace amount", MIMEJSONPatch, `[{"op": "replace", "path": "/amount", "value": 89}]`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(89), Currency: "THB", Note: seed.Note, Tags: seed.Tags}, nil},
+		{"JSON Patch add tag at end", MIMEJSONPatch, `[{"op": "add", "path": "/tags/-", "value": "night"}]`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(79), Currency: "THB", Note: seed.Note, Tags: []string{"food", "beverage", "night"}}, nil},
+		{"JSON Patch add tag at index", MIMEJSONPatch, `[{"op": "add", "path": "/tags/0", "value": "night"}]`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(79), Currency: "THB", Note: seed.Note, Tags: []string{"night", "food", "beverage"}}, nil},
+		{"JSON Patch remove tag", MIMEJSONPatch, `[{"op": "remove", "path": "/tags/0"}]`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(79), Currency: "THB", Note: seed.Note, Tags: []string{"beverage"}}, nil},
+		{"JSON Patch test then copy", MIMEJSONPatch, `[{"op": "test", "path": "/amount", "value": 79.0}, {"op": "copy", "from": "/title", "path": "/note"}]`,
+			Expense{ID: 1, Title: seed.Title, Amount: money.FromInt(79), Currency: "THB", Note: seed.Title, Tags: seed.Tags}, nil},
+		{"JSON Patch move tag into title", MIMEJSONPatch, `[{"op": "move", "from": "/tags/1", "path": "/title"}]`,
+			Expense{ID: 1, Title: "beverage", Amount: money.FromInt(79), Currency: "THB", Note: seed.Note, Tags: []string{"food"}}, nil},
+		{"JSON Patch failed test", MIMEJSONPatch, `[{"op": "test", "path": "/amount", "value": 80}]`, Expense{}, errPatchTestFailed},
+		{"JSON Patch remove missing index", MIMEJSONPatch, `[{"op": "remove", "path": "/tags/5"}]`, Expense{}, errInvalidPatch},
+		{"JSON Patch unknown operation", MIMEJSONPatch, `[{"op": "explode", "path": "/tags"}]`, Expense{}, errInvalidPatch},
+		{"JSON Patch add without value", MIMEJSONPatch, `[{"op": "add", "path": "/note"}]`, Expense{}, errInvalidPatch},
+		{"JSON Patch wrong amount type", MIMEJSONPatch, `[{"op": "replace", "path": "/amount", "value": "lots"}]`, Expense{}, errInvalidPatch},
+		{"Plain JSON is not a patch", "application/json", `{"amount": 89}`, Expense{}, errUnsupportedPatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			got, err := applyPatch(tt.contentType, seed, []byte(tt.patch))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
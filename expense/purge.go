@@ -0,0 +1,66 @@
+package expense
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Temwalker/assessment/blob"
+)
+
+const (
+	DefaultPurgeRetention = 30 * 24 * time.Hour
+	DefaultPurgeInterval  = time.Hour
+)
+
+// Purger permanently removes expenses that have stayed soft-deleted for
+// longer than Retention, checking every Interval until its context ends.
+// The files of their attachments are deleted from Blobs once the expenses
+// are gone.
+type Purger struct {
+	Storage   ExpenseStore
+	Blobs     blob.Store
+	Retention time.Duration
+	Interval  time.Duration
+}
+
+func NewPurger(s ExpenseStore, blobs blob.Store, retention time.Duration) Purger {
+	return Purger{
+		Storage:   s,
+		Blobs:     blobs,
+		Retention: retention,
+		Interval:  DefaultPurgeInterval,
+	}
+}
+
+func (p Purger) PurgeOnce(ctx context.Context, now time.Time) (int64, error) {
+	purged, keys, err := p.Storage.PurgeDeletedExpenses(ctx, now.Add(-p.Retention))
+	if p.Blobs != nil {
+		// a file left behind only wastes space, so one that can't be deleted
+		// does not fail the purge
+		for _, key := range keys {
+			if err := p.Blobs.Delete(ctx, key); err != nil {
+				log.Println("Can't delete attachment file : ", key, err)
+			}
+		}
+	}
+	return purged, err
+}
+
+func (p Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		purged, err := p.PurgeOnce(ctx, time.Now())
+		if err != nil {
+			log.Println("Can't purge deleted expenses : ", err)
+		} else if purged > 0 {
+			log.Println("Purged deleted expenses : ", purged)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package expense
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+// ExchangeRate is the price in Quote of one unit of Base, effective from
+// Date, a plain UTC date, until the next rate of the same pair.
+type ExchangeRate struct {
+	Base  string     `json:"base"`
+	Quote string     `json:"quote"`
+	Date  string     `json:"date"`
+	Rate  money.Rate `json:"rate"`
+}
+
+// RateStore keeps the exchange rates shared by everyone in a tenant.
+type RateStore interface {
+	// SaveRates stores every rate, replacing the one of the same pair and
+	// date, or on error none of them.
+	SaveRates(ctx context.Context, rates []ExchangeRate) error
+	// SelectRates lists the rates ordered by base, quote and date, only
+	// those into or out of currency unless it is empty.
+	SelectRates(ctx context.Context, currency string) ([]ExchangeRate, error)
+}
+
+// Conversion is an amount in the reporting currency at the rate effective
+// on the day it was spent. When there is no such rate, or the converted
+// amount is out of range, MissingRate is set and Amount and Rate are left
+// out.
+type Conversion struct {
+	Currency    string        `json:"currency"`
+	Amount      *money.Amount `json:"amount,omitempty"`
+	Rate        *money.Rate   `json:"rate,omitempty"`
+	RateDate    string        `json:"rate_date,omitempty"`
+	MissingRate bool          `json:"missing_rate,omitempty"`
+}
+
+// rateColumns are the CSV columns a rates file must have, in any order.
+var rateColumns = []string{"base", "quote", "date", "rate"}
+
+// checkRate normalizes r and returns why it is invalid, or "".
+func checkRate(r *ExchangeRate) string {
+	r.Base = strings.ToUpper(strings.TrimSpace(r.Base))
+	r.Quote = strings.ToUpper(strings.TrimSpace(r.Quote))
+	if !money.IsCurrency(r.Base) || !money.IsCurrency(r.Quote) || r.Base == r.Quote {
+		return "Invalid currency pair"
+	}
+	date, ok := parseDateParam(strings.TrimSpace(r.Date))
+	if !ok || date == nil {
+		return "Invalid date"
+	}
+	r.Date = date.UTC().Format("2006-01-02")
+	if r.Rate.IsZero() {
+		return "Invalid rate"
+	}
+	return ""
+}
+
+// readRates parses a JSON array of rates or, when it does not start with
+// "[", a CSV file with a header naming rateColumns. A non-empty message
+// rejects the rates as a whole; err is only set when r could not be read.
+func readRates(r io.Reader) ([]ExchangeRate, string, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err == io.EOF {
+			return nil, "No rates", nil
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		switch b {
+		case ' ', '\t', '\r', '\n', 0xef, 0xbb, 0xbf: // whitespace or a UTF-8 BOM
+			continue
+		}
+		br.UnreadByte()
+		if b == '[' {
+			return readRatesJSON(br)
+		}
+		return readRatesCSV(br)
+	}
+}
+
+func readRatesJSON(r io.Reader) ([]ExchangeRate, string, error) {
+	rates := []ExchangeRate{}
+	err := json.NewDecoder(r).Decode(&rates)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "Invalid request body", nil
+	}
+	for i := range rates {
+		if msg := checkRate(&rates[i]); msg != "" {
+			return nil, fmt.Sprintf("%s in entry %d", msg, i+1), nil
+		}
+	}
+	return rates, "", nil
+}
+
+func readRatesCSV(r io.Reader) ([]ExchangeRate, string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if isReadError(err) {
+		return nil, "", err
+	}
+	if err != nil {
+		return nil, "Invalid CSV header", nil
+	}
+	byName := map[string]int{}
+	for i, name := range header {
+		byName[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range rateColumns {
+		if _, ok := byName[name]; !ok {
+			return nil, "Missing column " + name, nil
+		}
+	}
+	rates := []ExchangeRate{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if isReadError(err) {
+			return nil, "", err
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, "Invalid CSV row on line " + strconv.Itoa(parseErr.StartLine), nil
+		}
+		value := func(name string) string {
+			if i := byName[name]; i < len(record) {
+				return record[i]
+			}
+			return ""
+		}
+		line, _ := reader.FieldPos(0)
+		rate := ExchangeRate{Base: value("base"), Quote: value("quote"), Date: value("date")}
+		msg := "Invalid rate"
+		if rate.Rate, err = money.ParseRate(value("rate")); err == nil {
+			msg = checkRate(&rate)
+		}
+		if msg != "" {
+			return nil, msg + " on line " + strconv.Itoa(line), nil
+		}
+		rates = append(rates, rate)
+	}
+	return rates, "", nil
+}
+
+// ReadRatesFile loads the rates in a JSON or CSV file, such as the one
+// named by EXCHANGE_RATES.
+func ReadRatesFile(path string) ([]ExchangeRate, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rates, msg, err := readRates(f)
+	if err == nil && msg != "" {
+		err = errors.New(msg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("exchange rates %s: %w", path, err)
+	}
+	return rates, nil
+}
+
+// rateTable converts into one reporting currency. Each currency's rates
+// into it are ordered by date, falling back to the inverse of the rate out
+// of it on days with no direct rate.
+type rateTable struct {
+	currency string
+	rates    map[string][]ExchangeRate
+}
+
+func newRateTable(currency string, rates []ExchangeRate) rateTable {
+	byDate := map[string]map[string]money.Rate{}
+	add := func(from, date string, rate money.Rate, direct bool) {
+		if byDate[from] == nil {
+			byDate[from] = map[string]money.Rate{}
+		}
+		if _, ok := byDate[from][date]; direct || !ok {
+			byDate[from][date] = rate
+		}
+	}
+	for _, r := range rates {
+		if r.Quote == currency {
+			add(r.Base, r.Date, r.Rate, true)
+		} else if r.Base == currency {
+			add(r.Quote, r.Date, r.Rate.Inverse(), false)
+		}
+	}
+	t := rateTable{currency: currency, rates: map[string][]ExchangeRate{}}
+	for from, dates := range byDate {
+		for date, rate := range dates {
+			t.rates[from] = append(t.rates[from], ExchangeRate{Base: from, Quote: currency, Date: date, Rate: rate})
+		}
+		sort.Slice(t.rates[from], func(i, j int) bool {
+			return t.rates[from][i].Date < t.rates[from][j].Date
+		})
+	}
+	return t
+}
+
+// convert converts ex at the latest rate dated on or before the UTC day it
+// was spent.
+func (t rateTable) convert(ex Expense) *Conversion {
+	c := &Conversion{Currency: t.currency}
+	if ex.Currency == t.currency {
+		rate, amount := money.MustParseRate("1"), ex.Amount
+		c.Amount, c.Rate = &amount, &rate
+		return c
+	}
+	rates := t.rates[ex.Currency]
+	day := ex.SpentAt.UTC().Format("2006-01-02")
+	i := sort.Search(len(rates), func(i int) bool { return rates[i].Date > day })
+	if i == 0 {
+		c.MissingRate = true
+		return c
+	}
+	r := rates[i-1]
+	digits, _ := money.MinorUnits(t.currency)
+	amount, ok := ex.Amount.Convert(r.Rate, digits)
+	if !ok {
+		// too large to report in the currency, so it is no better off than
+		// one without a rate
+		c.MissingRate = true
+		return c
+	}
+	c.Amount, c.Rate, c.RateDate = &amount, &r.Rate, r.Date
+	return c
+}
+
+// reported is the amount and currency ex counts for in a summary: its
+// conversion when it has one, else its own.
+func reported(ex Expense) (money.Amount, string) {
+	if ex.Converted != nil && ex.Converted.Amount != nil {
+		return *ex.Converted.Amount, ex.Converted.Currency
+	}
+	return ex.Amount, ex.Currency
+}
+
+func missingRate(ex Expense) bool {
+	return ex.Converted != nil && ex.Converted.MissingRate
+}
+
+// getReportCurrency reads ?report_currency=, falling back to
+// h.ReportingCurrency. An empty currency asks for no conversion.
+func (h Handler) getReportCurrency(c echo.Context) (string, bool, error) {
+	currency := strings.ToUpper(strings.TrimSpace(c.QueryParam("report_currency")))
+	if currency == "" {
+		currency = h.ReportingCurrency
+	}
+	if currency != "" && !money.IsCurrency(currency) {
+		return "", true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid report_currency"})
+	}
+	return currency, false, nil
+}
+
+// convertExpenses sets Converted on every expense at the rates in s unless
+// currency is empty.
+func convertExpenses(ctx context.Context, s RateStore, currency string, expenses []Expense) error {
+	if currency == "" || len(expenses) == 0 {
+		return nil
+	}
+	rates, err := s.SelectRates(ctx, currency)
+	if err != nil {
+		return err
+	}
+	t := newRateTable(currency, rates)
+	for i := range expenses {
+		expenses[i].Converted = t.convert(expenses[i])
+	}
+	return nil
+}
+
+// SaveRatesHandler stores a JSON array of rates or a CSV file, given as the
+// request body or as the multipart "file" field. Every rate is saved, or
+// none is when any of them is invalid.
+func (h Handler) SaveRatesHandler(c echo.Context) error {
+	body, ifErr, respErr := importBody(c)
+	if ifErr {
+		return respErr
+	}
+	defer body.Close()
+	rates, msg, err := readRates(body)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Msg: "Import is too large"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	if msg != "" {
+		return c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	if err := h.Rates.SaveRates(c.Request().Context(), rates); err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusCreated, rates)
+}
+
+// GetRatesHandler lists the stored rates, optionally only those into or out
+// of ?currency=.
+func (h Handler) GetRatesHandler(c echo.Context) error {
+	currency := strings.ToUpper(strings.TrimSpace(c.QueryParam("currency")))
+	if currency != "" && !money.IsCurrency(currency) {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid currency"})
+	}
+	rates, err := h.Rates.SelectRates(c.Request().Context(), currency)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, rates)
+}
@@ -0,0 +1,204 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRates(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{Storage: store, Transactions: store, Rates: store}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	call := func(method, target, contentType, body string, handler echo.HandlerFunc) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Add(echo.HeaderContentType, contentType)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), alice))
+		rec := httptest.NewRecorder()
+		assert.NoError(t, handler(e.NewContext(req, rec)))
+		return rec
+	}
+
+	t.Run("Save Rates Return HTTP Status Created", func(t *testing.T) {
+		rec := call(http.MethodPost, "/rates", echo.MIMEApplicationJSON,
+			`[{"base":"usd","quote":"THB","date":"2023-01-01","rate":"35"},{"base":"USD","quote":"THB","date":"2023-01-10","rate":36}]`, h.SaveRatesHandler)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		rec = call(http.MethodPost, "/rates", "text/csv", "\ufeffDate,Base,Quote,Rate\n2023-01-01,THB,JPY,4\n2023-01-01,USD,JPY,140\n", h.SaveRatesHandler)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		rec = call(http.MethodGet, "/rates?currency=jpy", "", "", h.GetRatesHandler)
+		assert.JSONEq(t, `[
+			{"base":"THB","quote":"JPY","date":"2023-01-01","rate":4},
+			{"base":"USD","quote":"JPY","date":"2023-01-01","rate":140}
+		]`, rec.Body.String())
+	})
+
+	t.Run("Invalid Rates Return HTTP Status Bad Request", func(t *testing.T) {
+		for body, want := range map[string]string{
+			`[{"base":"USD","quote":"USD","date":"2023-01-01","rate":"35"}]`:       "Invalid currency pair in entry 1",
+			`[{"base":"USD","quote":"THB","date":"2023-01-01"}]`:                   "Invalid rate in entry 1",
+			`[{"base":"USD","quote":"THB","date":"2023-01-01","rate":-1}]`:         "Invalid request body",
+			"base,quote,date,rate\nUSD,THB,2023-01-01,35\nUSD,THB,01/02/2023,35\n": "Invalid date on line 3",
+			"base,quote,rate\nUSD,THB,35\n":                                        "Missing column date",
+			"":                                                                     "No rates",
+		} {
+			rec := call(http.MethodPost, "/rates", "text/csv", body, h.SaveRatesHandler)
+			got := Err{}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+			assert.Equal(t, want, got.Msg, body)
+		}
+		rec := call(http.MethodGet, "/rates?currency=XYZ", "", "", h.GetRatesHandler)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	for _, ex := range []string{
+		`{"title":"taxi","amount":"10","currency":"USD","note":"n","tags":["travel"],"spent_at":"2023-01-05T10:00:00Z"}`,
+		`{"title":"hotel","amount":"10","currency":"USD","note":"n","tags":["travel"],"spent_at":"2023-01-12T10:00:00Z"}`,
+		`{"title":"ramen","amount":"1001","currency":"JPY","note":"n","tags":["food"],"spent_at":"2023-01-05T10:00:00Z"}`,
+		`{"title":"museum","amount":"5","currency":"EUR","note":"n","tags":["travel"],"spent_at":"2023-01-05T10:00:00Z"}`,
+		`{"title":"lunch","amount":"100","currency":"THB","note":"n","tags":["food"],"spent_at":"2022-12-31T10:00:00Z"}`,
+	} {
+		assert.Equal(t, http.StatusCreated, call(http.MethodPost, "/expenses", echo.MIMEApplicationJSON, ex, h.CreateExpenseHandler).Code)
+	}
+	amount := func(s string) *money.Amount {
+		a := money.MustParse(s)
+		return &a
+	}
+	rate := func(s string) *money.Rate {
+		r := money.MustParseRate(s)
+		return &r
+	}
+
+	t.Run("List Expenses converted to the reporting currency", func(t *testing.T) {
+		rec := call(http.MethodGet, "/expenses?report_currency=thb&sort=id", "", "", h.GetAllExpensesHandler)
+		expenses := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &expenses)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		got := []Conversion{}
+		for _, ex := range expenses {
+			got = append(got, *ex.Converted)
+		}
+		assert.Equal(t, []Conversion{
+			{Currency: "THB", Amount: amount("350"), Rate: rate("35"), RateDate: "2023-01-01"},
+			{Currency: "THB", Amount: amount("360"), Rate: rate("36"), RateDate: "2023-01-10"},
+			{Currency: "THB", Amount: amount("250.25"), Rate: rate("0.25"), RateDate: "2023-01-01"},
+			{Currency: "THB", MissingRate: true},
+			{Currency: "THB", Amount: amount("100"), Rate: rate("1")},
+		}, got)
+
+		rec = call(http.MethodGet, "/expenses?report_currency=XYZ", "", "", h.GetAllExpensesHandler)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		rec = call(http.MethodGet, "/expenses", "", "", h.GetAllExpensesHandler)
+		assert.NotContains(t, rec.Body.String(), "converted")
+	})
+
+	t.Run("Summary adds up converted amounts and flags missing rates", func(t *testing.T) {
+		h := h
+		h.ReportingCurrency = "THB"
+		rec := call(http.MethodGet, "/expenses/summary?group_by=tag", "", "", h.SummarizeExpensesHandler)
+		got := Summary{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "THB", got.ReportCurrency)
+		assert.Equal(t, []SummaryGroup{
+			{Key: "food", Currency: "THB", Count: 2, Total: *amount("350.25"), Average: *amount("175.125"), Min: *amount("100"), Max: *amount("250.25")},
+			{Key: "travel", Currency: "EUR", Count: 1, Total: *amount("5"), Average: *amount("5"), Min: *amount("5"), Max: *amount("5"), MissingRate: true},
+			{Key: "travel", Currency: "THB", Count: 2, Total: *amount("710"), Average: *amount("355"), Min: *amount("350"), Max: *amount("360")},
+		}, got.Groups)
+	})
+
+	t.Run("Flow converts income and expenses", func(t *testing.T) {
+		rec := call(http.MethodPost, "/transactions", echo.MIMEApplicationJSON,
+			`{"kind":"income","title":"fee","amount":"100","currency":"USD","note":"n","tags":["work"],"spent_at":"2023-01-20T00:00:00Z"}`, h.CreateTransactionHandler)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		rec = call(http.MethodGet, "/transactions/flow?report_currency=JPY&spent_from=2023-01-01", "", "", h.GetFlowHandler)
+		got := Flow{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, []FlowGroup{
+			{Key: "2023-01", Currency: "EUR", Expenses: *amount("5"), Net: *amount("-5"), MissingRate: true},
+			{Key: "2023-01", Currency: "JPY", Income: *amount("14000"), Expenses: *amount("3801"), Net: *amount("10199")},
+		}, got.Groups)
+	})
+}
+
+func TestReadRatesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	assert.NoError(t, os.WriteFile(path, []byte(` [{"base":"EUR","quote":"THB","date":"2023-01-01T00:00:00Z","rate":"37.5"}]`), 0o600))
+	rates, err := ReadRatesFile(path)
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ExchangeRate{{Base: "EUR", Quote: "THB", Date: "2023-01-01", Rate: money.MustParseRate("37.5")}}, rates)
+	}
+	assert.NoError(t, os.WriteFile(path, []byte("base,quote,date,rate\nEUR,THB,2023-01-01,abc\n"), 0o600))
+	_, err = ReadRatesFile(path)
+	assert.EqualError(t, err, "exchange rates "+path+": Invalid rate on line 2")
+}
+
+func TestPostgresRates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	usd := money.MustParseRate("35.125")
+
+	mock.ExpectBegin()
+	mock.ExpectPrepare("INSERT INTO exchange_rates \\(base,quote,rate_date,rate\\) VALUES \\(\\$1,\\$2,\\$3,\\$4\\)\\s+ON CONFLICT \\(tenant_id,base,quote,rate_date\\) DO UPDATE").
+		ExpectExec().WithArgs("USD", "THB", "2023-01-01", usd).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, s.SaveRates(context.Background(), []ExchangeRate{{Base: "USD", Quote: "THB", Date: "2023-01-01", Rate: usd}}))
+
+	mock.ExpectQuery("SELECT base,quote,rate_date,rate FROM exchange_rates WHERE base=\\$1 OR quote=\\$1 ORDER BY base,quote,rate_date").
+		WithArgs("THB").
+		WillReturnRows(sqlmock.NewRows([]string{"base", "quote", "rate_date", "rate"}).
+			AddRow("USD", "THB", time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), "35.12500000"))
+	rates, err := s.SelectRates(context.Background(), "THB")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []ExchangeRate{{Base: "USD", Quote: "THB", Date: "2023-01-01", Rate: usd}}, rates)
+	}
+
+	mock.ExpectQuery("SELECT currency,currency,COUNT\\(\\*\\),(.+),bool_or\\(missing_rate\\) FROM \\(SELECT (.+) FROM expenses LEFT JOIN LATERAL (.+) GROUP BY currency,currency").
+		WithArgs("THB").
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "currency", "count", "sum", "avg", "min", "max", "bool_or"}).
+			AddRow("EUR", "EUR", 1, "5", "5", "5", "5", true).
+			AddRow("THB", "THB", 2, "710.00", "355.0000", "350.00", "360.00", false))
+	groups, err := s.SummarizeExpenses(context.Background(), ExpenseFilter{}, GroupByCurrency, "THB")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []SummaryGroup{
+			{Key: "EUR", Currency: "EUR", Count: 1, Total: money.FromInt(5), Average: money.FromInt(5), Min: money.FromInt(5), Max: money.FromInt(5), MissingRate: true},
+			{Key: "THB", Currency: "THB", Count: 2, Total: money.FromInt(710), Average: money.FromInt(355), Min: money.FromInt(350), Max: money.FromInt(360)},
+		}, groups)
+	}
+
+	// a conversion too large for an Amount is left unconverted like one
+	// without a rate
+	mock.ExpectQuery("SELECT currency,currency,(.+) FROM \\(SELECT spent_at,tags,kind,COALESCE\\(converted,amount\\) AS amount,(.+)" +
+		"LATERAL \\(SELECT CASE WHEN abs\\(ROUND\\(amount\\*rate.rate,2\\)\\)<=922337203685477.5807 THEN ROUND\\(amount\\*rate.rate,2\\) END AS converted\\) AS conversion").
+		WithArgs("THB").
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "currency", "count", "sum", "avg", "min", "max", "bool_or"}).
+			AddRow("USD", "USD", 1, "900000000000000", "900000000000000", "900000000000000", "900000000000000", true))
+	groups, err = s.SummarizeExpenses(context.Background(), ExpenseFilter{}, GroupByCurrency, "THB")
+	if assert.NoError(t, err) {
+		huge := money.FromInt(900000000000000)
+		assert.Equal(t, []SummaryGroup{
+			{Key: "USD", Currency: "USD", Count: 1, Total: huge, Average: huge, Min: huge, Max: huge, MissingRate: true},
+		}, groups)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
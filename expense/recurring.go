@@ -0,0 +1,294 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyYearly  = "yearly"
+)
+
+const (
+	DefaultSchedulerInterval = time.Minute
+	// MaxCatchUp caps the occurrences one template materializes per run, so
+	// a long outage is caught up over several runs.
+	MaxCatchUp = 100
+)
+
+// RecurringExpense is a template materialized into an expense at StartAt
+// and then every Interval days, weeks, months or years up to Until. Monthly
+// and yearly occurrences falling on a day their month lacks, such as the
+// 31st, land on its last day instead. Occurrences counts the ones already
+// due and NextAt is the next one, or null once the schedule has ended.
+type RecurringExpense struct {
+	ID          int          `json:"id"`
+	OwnerID     string       `json:"owner_id"`
+	Title       string       `json:"title"`
+	Amount      money.Amount `json:"amount"`
+	Currency    string       `json:"currency"`
+	Note        string       `json:"note"`
+	Tags        []string     `json:"tags"`
+	Frequency   string       `json:"frequency"`
+	Interval    int          `json:"interval"`
+	StartAt     time.Time    `json:"start_at"`
+	Until       *time.Time   `json:"until"`
+	Occurrences int          `json:"occurrences"`
+	NextAt      *time.Time   `json:"next_at"`
+	CreatedAt   time.Time    `json:"created_at"`
+	UpdatedAt   time.Time    `json:"updated_at"`
+}
+
+type RecurringStore interface {
+	InsertRecurring(ctx context.Context, r *RecurringExpense) error
+	SelectRecurringByID(ctx context.Context, id int, r *RecurringExpense) error
+	SelectRecurring(ctx context.Context) ([]RecurringExpense, error)
+	// UpdateRecurringByID saves r including its Occurrences and NextAt.
+	UpdateRecurringByID(ctx context.Context, id int, r *RecurringExpense) error
+	DeleteRecurringByID(ctx context.Context, id int) error
+	// MaterializeRecurring inserts every occurrence due at now as an expense
+	// of the template's owner, across all owners and tenants, and returns
+	// how many it inserted. An occurrence is never inserted twice.
+	MaterializeRecurring(ctx context.Context, now time.Time) (int64, error)
+}
+
+func isFrequency(frequency string) bool {
+	switch frequency {
+	case FrequencyDaily, FrequencyWeekly, FrequencyMonthly, FrequencyYearly:
+		return true
+	}
+	return false
+}
+
+// addMonths moves t by n months, keeping its day unless the target month is
+// shorter.
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	day := t.Day()
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return first.AddDate(0, 0, day-1)
+}
+
+// occurrence is the n-th occurrence, counting from 0 at StartAt. It is
+// computed from StartAt rather than the previous one so that a short month
+// does not shift the ones after it.
+func (r RecurringExpense) occurrence(n int) time.Time {
+	start := r.StartAt.UTC()
+	step := n * r.Interval
+	switch r.Frequency {
+	case FrequencyDaily:
+		return start.AddDate(0, 0, step)
+	case FrequencyWeekly:
+		return start.AddDate(0, 0, 7*step)
+	case FrequencyYearly:
+		return addMonths(start, 12*step)
+	}
+	return addMonths(start, step)
+}
+
+// seek makes occurrence n the next one, ending the schedule if it is past
+// Until.
+func (r *RecurringExpense) seek(n int) {
+	r.Occurrences = n
+	next := r.occurrence(n)
+	if r.Until != nil && next.After(*r.Until) {
+		r.NextAt = nil
+		return
+	}
+	r.NextAt = &next
+}
+
+// due advances r past the occurrences due at now, at most MaxCatchUp of
+// them, and returns their times.
+func (r *RecurringExpense) due(now time.Time) []time.Time {
+	times := []time.Time{}
+	for r.NextAt != nil && !r.NextAt.After(now) && len(times) < MaxCatchUp {
+		times = append(times, *r.NextAt)
+		r.seek(r.Occurrences + 1)
+	}
+	return times
+}
+
+// reschedule resumes the edited schedule r at its first occurrence not
+// before after, so occurrences already materialized are not repeated.
+func (r *RecurringExpense) reschedule(after time.Time) {
+	n := 0
+	for r.occurrence(n).Before(after) {
+		n++
+	}
+	r.seek(n)
+}
+
+// occurrenceExpense is the expense materialized from r at spentAt.
+func (r RecurringExpense) occurrenceExpense(spentAt time.Time) Expense {
+	return Expense{
+		OwnerID:  r.OwnerID,
+		Title:    r.Title,
+		Amount:   r.Amount,
+		Currency: r.Currency,
+		Note:     r.Note,
+		Tags:     append([]string{}, r.Tags...),
+		SpentAt:  spentAt,
+		Status:   StatusDraft,
+	}
+}
+
+// Scheduler materializes due recurring expenses every Interval until its
+// context ends.
+type Scheduler struct {
+	Storage  RecurringStore
+	Interval time.Duration
+}
+
+func NewScheduler(s RecurringStore) Scheduler {
+	return Scheduler{
+		Storage:  s,
+		Interval: DefaultSchedulerInterval,
+	}
+}
+
+func (s Scheduler) RunOnce(ctx context.Context, now time.Time) (int64, error) {
+	return s.Storage.MaterializeRecurring(ctx, now)
+}
+
+func (s Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		created, err := s.RunOnce(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			log.Println("Can't materialize recurring expenses : ", err)
+		} else if created > 0 {
+			log.Println("Materialized recurring expenses : ", created)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func bindRecurring(c echo.Context, r *RecurringExpense) (bool, error) {
+	if err := c.Bind(r); err != nil {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	invalid := func(msg string) (bool, error) {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	r.Tags = parseTags(r.Tags)
+	template := Expense{Title: r.Title, Amount: r.Amount, Currency: r.Currency, Note: r.Note, Tags: r.Tags}
+	if msg := checkExpense(&template); msg != "" {
+		return invalid(msg)
+	}
+	r.Currency = template.Currency
+	r.Frequency = strings.ToLower(r.Frequency)
+	if !isFrequency(r.Frequency) {
+		return invalid("Invalid frequency")
+	}
+	if r.Interval == 0 {
+		r.Interval = 1
+	}
+	if r.Interval < 0 {
+		return invalid("Invalid interval")
+	}
+	if r.StartAt.IsZero() {
+		r.StartAt = time.Now()
+	}
+	r.StartAt = r.StartAt.UTC().Truncate(time.Second)
+	if r.Until != nil && r.Until.Before(r.StartAt) {
+		return invalid("Invalid until")
+	}
+	return false, nil
+}
+
+func returnRecurring(err error, c echo.Context, status int, r RecurringExpense) error {
+	if err != nil && err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Recurring expense not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(status, r)
+}
+
+// CreateRecurringHandler saves a template. A StartAt in the past is caught
+// up by the scheduler.
+func (h Handler) CreateRecurringHandler(c echo.Context) error {
+	r := RecurringExpense{}
+	if ifErr, respErr := bindRecurring(c, &r); ifErr {
+		return respErr
+	}
+	r.seek(0)
+	err := h.Recurring.InsertRecurring(c.Request().Context(), &r)
+	return returnRecurring(err, c, http.StatusCreated, r)
+}
+
+func (h Handler) GetRecurringHandler(c echo.Context) error {
+	templates, err := h.Recurring.SelectRecurring(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, templates)
+}
+
+func (h Handler) GetRecurringByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	r := RecurringExpense{}
+	err := h.Recurring.SelectRecurringByID(c.Request().Context(), intVar, &r)
+	return returnRecurring(err, c, http.StatusOK, r)
+}
+
+// UpdateRecurringByIDHandler replaces a template. Its schedule resumes where
+// the old one was due next, or now if it had ended.
+func (h Handler) UpdateRecurringByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	r := RecurringExpense{}
+	if ifErr, respErr := bindRecurring(c, &r); ifErr {
+		return respErr
+	}
+	ctx := c.Request().Context()
+	old := RecurringExpense{}
+	if err := h.Recurring.SelectRecurringByID(ctx, intVar, &old); err != nil {
+		return returnRecurring(err, c, 0, old)
+	}
+	after := time.Now()
+	if old.NextAt != nil {
+		after = *old.NextAt
+	}
+	r.reschedule(after)
+	err := h.Recurring.UpdateRecurringByID(ctx, intVar, &r)
+	return returnRecurring(err, c, http.StatusOK, r)
+}
+
+// DeleteRecurringByIDHandler stops a schedule. Expenses it already created
+// are kept.
+func (h Handler) DeleteRecurringByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	err := h.Recurring.DeleteRecurringByID(c.Request().Context(), intVar)
+	if err != nil {
+		return returnRecurring(err, c, 0, RecurringExpense{})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,217 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 0, 0, 0, time.UTC)
+}
+
+func TestRecurringOccurrences(t *testing.T) {
+	until := date(2023, 6, 1)
+	tests := []struct {
+		name string
+		r    RecurringExpense
+		want []time.Time
+	}{
+		{"Daily every 3 days", RecurringExpense{Frequency: FrequencyDaily, Interval: 3, StartAt: date(2023, 1, 30)},
+			[]time.Time{date(2023, 1, 30), date(2023, 2, 2), date(2023, 2, 5), date(2023, 2, 8)}},
+		{"Weekly every other week", RecurringExpense{Frequency: FrequencyWeekly, Interval: 2, StartAt: date(2023, 1, 2)},
+			[]time.Time{date(2023, 1, 2), date(2023, 1, 16), date(2023, 1, 30), date(2023, 2, 13)}},
+		{"Monthly on the 31st keeps to month ends", RecurringExpense{Frequency: FrequencyMonthly, Interval: 1, StartAt: date(2023, 1, 31)},
+			[]time.Time{date(2023, 1, 31), date(2023, 2, 28), date(2023, 3, 31), date(2023, 4, 30)}},
+		{"Yearly on a leap day", RecurringExpense{Frequency: FrequencyYearly, Interval: 1, StartAt: date(2024, 2, 29)},
+			[]time.Time{date(2024, 2, 29), date(2025, 2, 28), date(2026, 2, 28), date(2027, 2, 28)}},
+		{"Monthly until June", RecurringExpense{Frequency: FrequencyMonthly, Interval: 2, StartAt: date(2023, 1, 15), Until: &until},
+			[]time.Time{date(2023, 1, 15), date(2023, 3, 15), date(2023, 5, 15)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := tt.r
+			r.seek(0)
+			got := r.due(date(2030, 1, 1))
+			if len(got) > 4 {
+				got = got[:4]
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRecurringCatchUpIsCapped(t *testing.T) {
+	r := RecurringExpense{Frequency: FrequencyDaily, Interval: 1, StartAt: date(2020, 1, 1)}
+	r.seek(0)
+	assert.Equal(t, MaxCatchUp, len(r.due(date(2023, 1, 1))))
+	assert.Equal(t, MaxCatchUp, r.Occurrences)
+	assert.Equal(t, date(2020, 1, 1).AddDate(0, 0, MaxCatchUp), *r.NextAt)
+}
+
+func TestRecurringExpenses(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{Storage: store, Recurring: store}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	bob := auth.Principal{Subject: "bob", Roles: []string{auth.RoleEditor}}
+	call := func(p auth.Principal, method, body string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/recurring-expenses", bytes.NewBufferString(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, handler(c))
+		return rec
+	}
+	expenses := func() []Expense {
+		got := []Expense{}
+		store.SelectExpenses(context.Background(), ExpenseQuery{}, func(ex Expense) error {
+			got = append(got, ex)
+			return nil
+		})
+		return got
+	}
+
+	r := RecurringExpense{}
+	t.Run("Create Recurring Expense Return HTTP Status Created", func(t *testing.T) {
+		body := `{"title":"rent","amount":"12000","currency":"thb","note":"flat","tags":["home"],"frequency":"Monthly","start_at":"2023-01-31T09:00:00Z","until":"2023-04-30T09:00:00Z"}`
+		rec := call(alice, http.MethodPost, body, h.CreateRecurringHandler, "")
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		json.Unmarshal(rec.Body.Bytes(), &r)
+		assert.Equal(t, "alice", r.OwnerID)
+		assert.Equal(t, FrequencyMonthly, r.Frequency)
+		assert.Equal(t, 1, r.Interval)
+		assert.Equal(t, "THB", r.Currency)
+		if assert.NotNil(t, r.NextAt) {
+			assert.Equal(t, date(2023, 1, 31), r.NextAt.UTC())
+		}
+	})
+	id := strconv.Itoa(r.ID)
+
+	t.Run("Invalid Recurring Expense Return HTTP Status Bad Request", func(t *testing.T) {
+		for body, want := range map[string]string{
+			`{"title":"rent","amount":"1","currency":"THB","note":"n","frequency":"monthly"}`:                                                                                "Invalid request body",
+			`{"title":"rent","amount":"1","currency":"THB","note":"n","tags":["home"],"frequency":"hourly"}`:                                                                 "Invalid frequency",
+			`{"title":"rent","amount":"1","currency":"THB","note":"n","tags":["home"],"frequency":"daily","interval":-1}`:                                                    "Invalid interval",
+			`{"title":"rent","amount":"1","currency":"THB","note":"n","tags":["home"],"frequency":"daily","start_at":"2023-02-01T00:00:00Z","until":"2023-01-01T00:00:00Z"}`: "Invalid until",
+		} {
+			rec := call(alice, http.MethodPost, body, h.CreateRecurringHandler, "")
+			got := Err{}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+			assert.Equal(t, want, got.Msg, body)
+		}
+	})
+
+	t.Run("Scheduler materializes every occurrence once", func(t *testing.T) {
+		s := NewScheduler(store)
+		created, err := s.RunOnce(context.Background(), date(2023, 3, 1))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), created)
+		created, _ = s.RunOnce(context.Background(), date(2023, 3, 1))
+		assert.Equal(t, int64(0), created)
+		created, _ = s.RunOnce(context.Background(), date(2024, 1, 1))
+		assert.Equal(t, int64(2), created)
+
+		got := expenses()
+		if assert.Equal(t, 4, len(got)) {
+			assert.Equal(t, "alice", got[0].OwnerID)
+			assert.Equal(t, "rent", got[0].Title)
+			assert.Equal(t, money.MustParse("12000"), got[0].Amount)
+			assert.Equal(t, StatusDraft, got[0].Status)
+			assert.Equal(t, date(2023, 2, 28), got[1].SpentAt)
+			assert.Equal(t, date(2023, 4, 30), got[3].SpentAt)
+		}
+		rec := call(alice, http.MethodGet, "", h.GetRecurringByIDHandler, id)
+		got1 := RecurringExpense{}
+		json.Unmarshal(rec.Body.Bytes(), &got1)
+		assert.Equal(t, 4, got1.Occurrences)
+		assert.Nil(t, got1.NextAt)
+	})
+
+	t.Run("Recurring Expenses of other owners Return HTTP Status Not Found", func(t *testing.T) {
+		rec := call(bob, http.MethodGet, "", h.GetRecurringByIDHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = call(bob, http.MethodGet, "", h.GetRecurringHandler, "")
+		assert.JSONEq(t, "[]", rec.Body.String())
+	})
+
+	t.Run("Update resumes the schedule without repeating occurrences", func(t *testing.T) {
+		body := `{"title":"rent","amount":"13000","currency":"THB","note":"flat","tags":["home"],"frequency":"monthly","start_at":"2023-01-31T09:00:00Z"}`
+		rec := call(alice, http.MethodPut, body, h.UpdateRecurringByIDHandler, id)
+		got := RecurringExpense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.NotNil(t, got.NextAt) {
+			assert.False(t, got.NextAt.Before(time.Now().Add(-time.Minute)))
+		}
+	})
+
+	t.Run("Delete Recurring Expense keeps its expenses", func(t *testing.T) {
+		rec := call(alice, http.MethodDelete, "", h.DeleteRecurringByIDHandler, id)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		rec = call(alice, http.MethodDelete, "", h.DeleteRecurringByIDHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, 4, len(expenses()))
+	})
+}
+
+func TestSchedulerStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Scheduler{Storage: NewMemoryStore(), Interval: time.Hour}.Run(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+}
+
+func TestPostgresMaterializeRecurring(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	now := date(2023, 3, 1)
+	next := date(2023, 1, 31)
+	insert := "INSERT INTO expenses \\(title,amount,note,tags,currency,spent_at,owner_id,tenant_id,recurring_id\\) SELECT (.+) FROM recurring_expenses WHERE id=\\$1 ON CONFLICT \\(recurring_id,spent_at\\) WHERE recurring_id IS NOT NULL DO NOTHING"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM recurring_expenses WHERE next_at <= \\$1 ORDER BY next_at, id LIMIT \\$2 FOR UPDATE SKIP LOCKED").
+		WithArgs(now, recurringBatchSize).
+		WillReturnRows(sqlmock.NewRows(strings.Split(recurringColumns, ",")).
+			AddRow(7, "alice", "rent", "12000", "flat", "{home}", "THB", FrequencyMonthly, 1, next, nil, 0, next, testTime, testTime))
+	mock.ExpectExec(insert).WithArgs(7, date(2023, 1, 31)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(insert).WithArgs(7, date(2023, 2, 28)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE recurring_expenses SET occurrences=\\$2 , next_at=\\$3 WHERE id=\\$1").
+		WithArgs(7, 2, date(2023, 3, 31)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	created, err := s.MaterializeRecurring(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), created, "an occurrence another replica inserted is not counted")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,133 @@
+package expense
+
+import (
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	highlightStart = "<mark>"
+	highlightStop  = "</mark>"
+	// ts_headline marks matches with these control characters instead, so
+	// the headline can be escaped before they become highlightStart and
+	// highlightStop.
+	headlineStart = "\x02"
+	headlineStop  = "\x03"
+)
+
+var headlineMarks = strings.NewReplacer(headlineStart, highlightStart, headlineStop, highlightStop)
+
+// markHeadline HTML-escapes a ts_headline result and turns its match
+// markers into highlightStart and highlightStop.
+func markHeadline(headline string) string {
+	return headlineMarks.Replace(html.EscapeString(headline))
+}
+
+type SearchHighlight struct {
+	Title string `json:"title"`
+	Note  string `json:"note"`
+}
+
+type SearchResult struct {
+	Expense
+	Rank      float64         `json:"rank"`
+	Highlight SearchHighlight `json:"highlight"`
+}
+
+func isSearchSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// searchTerms splits a free-text query into lower-cased words. Everything
+// but letters and digits is dropped, so the terms are safe to splice into a
+// tsquery.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), isSearchSeparator)
+}
+
+// prefixTSQuery matches any term as a word prefix, letting ts_rank order
+// rows that match more of the terms first.
+func prefixTSQuery(terms []string) string {
+	parts := make([]string, len(terms))
+	for i, t := range terms {
+		parts[i] = t + ":*"
+	}
+	return strings.Join(parts, " | ")
+}
+
+func matchesTerm(word string, terms []string) bool {
+	word = strings.ToLower(word)
+	for _, t := range terms {
+		if strings.HasPrefix(word, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// highlightText HTML-escapes text and wraps every word that starts with one
+// of the terms in the markers markHeadline uses.
+func highlightText(text string, terms []string) (string, int) {
+	var b strings.Builder
+	hits := 0
+	word := []rune{}
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if matchesTerm(string(word), terms) {
+			hits++
+			b.WriteString(highlightStart + html.EscapeString(string(word)) + highlightStop)
+		} else {
+			b.WriteString(html.EscapeString(string(word)))
+		}
+		word = word[:0]
+	}
+	for _, r := range text {
+		if isSearchSeparator(r) {
+			flush()
+			b.WriteString(html.EscapeString(string(r)))
+			continue
+		}
+		word = append(word, r)
+	}
+	flush()
+	return b.String(), hits
+}
+
+func getSearchLimit(c echo.Context) (int, bool, error) {
+	limit := c.QueryParam("limit")
+	if limit == "" {
+		return DefaultPageLimit, false, nil
+	}
+	n, err := strconv.Atoi(limit)
+	if err != nil || n < 1 || n > MaxPageLimit {
+		return 0, true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid limit"})
+	}
+	return n, false, nil
+}
+
+func (h Handler) SearchExpensesHandler(c echo.Context) error {
+	terms := searchTerms(c.QueryParam("q"))
+	if len(terms) == 0 {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid search query"})
+	}
+	limit, ifErr, respErr := getSearchLimit(c)
+	if ifErr {
+		return respErr
+	}
+	results := []SearchResult{}
+	err := h.Storage.SearchExpenses(c.Request().Context(), terms, limit, func(r SearchResult) error {
+		results = append(results, r)
+		return nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, results)
+}
@@ -0,0 +1,333 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	SplitEqual   = "equal"
+	SplitPercent = "percent"
+	SplitExact   = "exact"
+)
+
+var hundredPercent = money.FromInt(100)
+
+// Share is what one participant owes of a split expense. Percent is only
+// set for percentage splits.
+type Share struct {
+	Participant string        `json:"participant"`
+	Percent     *money.Amount `json:"percent,omitempty"`
+	Amount      money.Amount  `json:"amount"`
+}
+
+// Split divides an expense between participants. Payer is the expense
+// owner, who paid it, so every other participant owes the payer their
+// share. The shares add up to the expense amount.
+type Split struct {
+	ExpenseID int     `json:"expense_id"`
+	Payer     string  `json:"payer"`
+	Currency  string  `json:"currency"`
+	Method    string  `json:"method"`
+	Shares    []Share `json:"shares"`
+}
+
+type SplitStore interface {
+	// ReplaceSplit replaces the shares of an expense that is not locked.
+	ReplaceSplit(ctx context.Context, s *Split) error
+	SelectSplit(ctx context.Context, expenseID int, s *Split) error
+	DeleteSplit(ctx context.Context, expenseID int) error
+	// SelectSplits returns the splits of the active, not rejected expenses
+	// the caller paid or shares in, or of every expense for admins and
+	// approvers.
+	SelectSplits(ctx context.Context) ([]Split, error)
+}
+
+// Balance is what a participant is owed in Currency, or owes when Net is
+// negative.
+type Balance struct {
+	Participant string       `json:"participant"`
+	Currency    string       `json:"currency"`
+	Net         money.Amount `json:"net"`
+}
+
+type Debt struct {
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Currency string       `json:"currency"`
+	Amount   money.Amount `json:"amount"`
+}
+
+type Balances struct {
+	Balances []Balance `json:"balances"`
+	Debts    []Debt    `json:"debts"`
+}
+
+// checkSplit fills in the share amounts of s for ex and returns why s is
+// invalid, or "" when it is valid.
+func checkSplit(s *Split, ex Expense) string {
+	s.Method = strings.ToLower(s.Method)
+	seen := map[string]bool{}
+	for i := range s.Shares {
+		p := strings.TrimSpace(s.Shares[i].Participant)
+		if p == "" || seen[p] {
+			return "Invalid participants"
+		}
+		seen[p] = true
+		s.Shares[i].Participant = p
+	}
+	if len(s.Shares) == 0 {
+		return "Invalid participants"
+	}
+	digits, _ := money.MinorUnits(ex.Currency)
+	switch s.Method {
+	case SplitEqual:
+		weights := make([]int64, len(s.Shares))
+		for i := range s.Shares {
+			weights[i] = 1
+			s.Shares[i].Percent = nil
+		}
+		allocate(s.Shares, ex.Amount.Allocate(weights, digits))
+	case SplitPercent:
+		weights := make([]int64, len(s.Shares))
+		total := money.Amount{}
+		for i, share := range s.Shares {
+			if share.Percent == nil || share.Percent.Sign() <= 0 {
+				return "Invalid percent"
+			}
+			weights[i] = share.Percent.Units()
+			total = total.Add(*share.Percent)
+		}
+		if total.Cmp(hundredPercent) != 0 {
+			return "Percentages must sum to 100"
+		}
+		allocate(s.Shares, ex.Amount.Allocate(weights, digits))
+	case SplitExact:
+		total := money.Amount{}
+		for i, share := range s.Shares {
+			if share.Amount.Sign() < 0 || !share.Amount.FitsCurrency(ex.Currency) {
+				return "Invalid share amount"
+			}
+			s.Shares[i].Percent = nil
+			total = total.Add(share.Amount)
+		}
+		if total.Cmp(ex.Amount) != 0 {
+			return "Shares must sum to the amount"
+		}
+	default:
+		return "Invalid split method"
+	}
+	s.ExpenseID, s.Payer, s.Currency = ex.ID, ex.OwnerID, ex.Currency
+	return ""
+}
+
+func allocate(shares []Share, amounts []money.Amount) {
+	for i := range shares {
+		shares[i].Amount = amounts[i]
+	}
+}
+
+// total is what the shares of s add up to.
+func (s Split) total() money.Amount {
+	total := money.Amount{}
+	for _, share := range s.Shares {
+		total = total.Add(share.Amount)
+	}
+	return total
+}
+
+func (s Split) hasParticipant(participant string) bool {
+	for _, share := range s.Shares {
+		if share.Participant == participant {
+			return true
+		}
+	}
+	return false
+}
+
+// splitConflict answers 409 when ex would no longer match the split of the
+// expense it replaces, since the shares must add up to its amount.
+func (h Handler) splitConflict(c echo.Context, id int, ex Expense) (bool, error) {
+	if h.Splits == nil {
+		return false, nil
+	}
+	s := Split{}
+	err := h.Splits.SelectSplit(c.Request().Context(), id, &s)
+	if err != nil && err.Error() == sql.ErrNoRows.Error() {
+		return false, nil
+	}
+	if err != nil {
+		return true, c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	if s.Currency != ex.Currency || s.total().Cmp(ex.Amount) != 0 {
+		return true, c.JSON(http.StatusConflict, Err{Msg: "Expense is split, update its split first"})
+	}
+	return false, nil
+}
+
+// settle nets what every participant paid against what they owe, per
+// currency, and simplifies the debts by repeatedly settling the largest
+// debtor against the largest creditor, which needs at most one payment
+// fewer than there are participants.
+func settle(splits []Split) Balances {
+	type key struct{ participant, currency string }
+	nets := map[key]money.Amount{}
+	for _, s := range splits {
+		for _, share := range s.Shares {
+			if share.Participant == s.Payer {
+				continue
+			}
+			nets[key{s.Payer, s.Currency}] = nets[key{s.Payer, s.Currency}].Add(share.Amount)
+			nets[key{share.Participant, s.Currency}] = nets[key{share.Participant, s.Currency}].Sub(share.Amount)
+		}
+	}
+	result := Balances{Balances: []Balance{}, Debts: []Debt{}}
+	for k, net := range nets {
+		if !net.IsZero() {
+			result.Balances = append(result.Balances, Balance{Participant: k.participant, Currency: k.currency, Net: net})
+		}
+	}
+	sort.Slice(result.Balances, func(i, j int) bool {
+		a, b := result.Balances[i], result.Balances[j]
+		if a.Currency != b.Currency {
+			return a.Currency < b.Currency
+		}
+		if c := a.Net.Cmp(b.Net); c != 0 {
+			return c > 0
+		}
+		return a.Participant < b.Participant
+	})
+
+	byCurrency := map[string][]Balance{}
+	currencies := []string{}
+	for _, b := range result.Balances {
+		if _, ok := byCurrency[b.Currency]; !ok {
+			currencies = append(currencies, b.Currency)
+		}
+		byCurrency[b.Currency] = append(byCurrency[b.Currency], b)
+	}
+	for _, currency := range currencies {
+		creditors, debtors := []Balance{}, []Balance{}
+		for _, b := range byCurrency[currency] {
+			if b.Net.Sign() > 0 {
+				creditors = append(creditors, b)
+			} else {
+				debtors = append(debtors, Balance{Participant: b.Participant, Currency: currency, Net: b.Net.Neg()})
+			}
+		}
+		sort.SliceStable(debtors, func(i, j int) bool {
+			return debtors[i].Net.Cmp(debtors[j].Net) > 0
+		})
+		for i, j := 0, 0; i < len(debtors) && j < len(creditors); {
+			amount := debtors[i].Net
+			if creditors[j].Net.Cmp(amount) < 0 {
+				amount = creditors[j].Net
+			}
+			result.Debts = append(result.Debts, Debt{From: debtors[i].Participant, To: creditors[j].Participant, Currency: currency, Amount: amount})
+			debtors[i].Net = debtors[i].Net.Sub(amount)
+			creditors[j].Net = creditors[j].Net.Sub(amount)
+			if debtors[i].Net.IsZero() {
+				i++
+			}
+			if creditors[j].Net.IsZero() {
+				j++
+			}
+		}
+	}
+	return result
+}
+
+func returnSplitError(err error, c echo.Context) error {
+	if errors.Is(err, ErrExpenseLocked) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	if err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Split not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+}
+
+// SplitExpenseHandler replaces the split of an expense. Equal and
+// percentage shares are rounded to the currency's minor unit, with the
+// rounding remainder going to the first participants.
+func (h Handler) SplitExpenseHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ex := Expense{}
+	if ifErr, respErr := h.visibleExpense(c, intVar, &ex); ifErr {
+		return respErr
+	}
+	if isLocked(ex.Status) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	s := Split{}
+	if err := c.Bind(&s); err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	if msg := checkSplit(&s, ex); msg != "" {
+		return c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	if err := h.Splits.ReplaceSplit(c.Request().Context(), &s); err != nil {
+		return returnSplitError(err, c)
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+func (h Handler) GetSplitHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	s := Split{}
+	if err := h.Splits.SelectSplit(c.Request().Context(), intVar, &s); err != nil {
+		return returnSplitError(err, c)
+	}
+	return c.JSON(http.StatusOK, s)
+}
+
+func (h Handler) DeleteSplitHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ex := Expense{}
+	if ifErr, respErr := h.visibleExpense(c, intVar, &ex); ifErr {
+		return respErr
+	}
+	if isLocked(ex.Status) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	if err := h.Splits.DeleteSplit(c.Request().Context(), intVar); err != nil {
+		return returnSplitError(err, c)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetBalancesHandler reports who owes whom across the split expenses the
+// caller can see, optionally in one ?currency=.
+func (h Handler) GetBalancesHandler(c echo.Context) error {
+	splits, err := h.Splits.SelectSplits(c.Request().Context())
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	if currency := c.QueryParam("currency"); currency != "" {
+		currency = money.NormalizeCurrency(currency)
+		matching := []Split{}
+		for _, s := range splits {
+			if s.Currency == currency {
+				matching = append(matching, s)
+			}
+		}
+		splits = matching
+	}
+	return c.JSON(http.StatusOK, settle(splits))
+}
@@ -0,0 +1,226 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func percent(s string) *money.Amount {
+	p := money.MustParse(s)
+	return &p
+}
+
+func TestCheckSplit(t *testing.T) {
+	ex := Expense{ID: 1, OwnerID: "alice", Amount: money.MustParse("100"), Currency: "THB"}
+	tests := []struct {
+		name  string
+		split Split
+		want  []string
+		msg   string
+	}{
+		{"Equal shares keep the remainder first", Split{Method: "Equal", Shares: []Share{{Participant: "alice"}, {Participant: " bob "}, {Participant: "carol"}}},
+			[]string{"33.34", "33.33", "33.33"}, ""},
+		{"Percent shares", Split{Method: SplitPercent, Shares: []Share{{Participant: "alice", Percent: percent("50")}, {Participant: "bob", Percent: percent("33.3333")}, {Participant: "carol", Percent: percent("16.6667")}}},
+			[]string{"50", "33.33", "16.67"}, ""},
+		{"Exact shares", Split{Method: SplitExact, Shares: []Share{{Participant: "alice", Amount: money.MustParse("70.5")}, {Participant: "bob", Amount: money.MustParse("29.5")}}},
+			[]string{"70.5", "29.5"}, ""},
+		{"Percentages off 100", Split{Method: SplitPercent, Shares: []Share{{Participant: "alice", Percent: percent("50")}, {Participant: "bob", Percent: percent("40")}}},
+			nil, "Percentages must sum to 100"},
+		{"Missing percent", Split{Method: SplitPercent, Shares: []Share{{Participant: "alice"}}}, nil, "Invalid percent"},
+		{"Exact shares off the amount", Split{Method: SplitExact, Shares: []Share{{Participant: "alice", Amount: money.MustParse("70")}, {Participant: "bob", Amount: money.MustParse("20")}}},
+			nil, "Shares must sum to the amount"},
+		{"Exact share too precise", Split{Method: SplitExact, Shares: []Share{{Participant: "alice", Amount: money.MustParse("99.995")}, {Participant: "bob", Amount: money.MustParse("0.005")}}},
+			nil, "Invalid share amount"},
+		{"Duplicate participant", Split{Method: SplitEqual, Shares: []Share{{Participant: "bob"}, {Participant: "bob"}}}, nil, "Invalid participants"},
+		{"No participants", Split{Method: SplitEqual}, nil, "Invalid participants"},
+		{"Unknown method", Split{Method: "weights", Shares: []Share{{Participant: "bob"}}}, nil, "Invalid split method"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.split
+			msg := checkSplit(&s, ex)
+			assert.Equal(t, tt.msg, msg)
+			if msg != "" {
+				return
+			}
+			got := []string{}
+			for _, share := range s.Shares {
+				got = append(got, share.Amount.String())
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, "alice", s.Payer)
+			assert.Equal(t, "THB", s.Currency)
+		})
+	}
+}
+
+func TestSettle(t *testing.T) {
+	equal := func(id int, payer, currency string, shares ...string) Split {
+		s := Split{ExpenseID: id, Payer: payer, Currency: currency, Method: SplitEqual}
+		for i := 0; i < len(shares); i += 2 {
+			s.Shares = append(s.Shares, Share{Participant: shares[i], Amount: money.MustParse(shares[i+1])})
+		}
+		return s
+	}
+	got := settle([]Split{
+		equal(1, "alice", "THB", "alice", "30", "bob", "30", "carol", "30"),
+		equal(2, "bob", "THB", "bob", "30", "carol", "30"),
+		equal(3, "carol", "THB", "dave", "10"),
+		equal(4, "bob", "USD", "alice", "5"),
+	})
+	assert.Equal(t, []Balance{
+		{Participant: "alice", Currency: "THB", Net: money.MustParse("60")},
+		{Participant: "dave", Currency: "THB", Net: money.MustParse("-10")},
+		{Participant: "carol", Currency: "THB", Net: money.MustParse("-50")},
+		{Participant: "bob", Currency: "USD", Net: money.MustParse("5")},
+		{Participant: "alice", Currency: "USD", Net: money.MustParse("-5")},
+	}, got.Balances)
+	assert.Equal(t, []Debt{
+		{From: "carol", To: "alice", Currency: "THB", Amount: money.MustParse("50")},
+		{From: "dave", To: "alice", Currency: "THB", Amount: money.MustParse("10")},
+		{From: "alice", To: "bob", Currency: "USD", Amount: money.MustParse("5")},
+	}, got.Debts)
+
+	empty := settle(nil)
+	assert.Equal(t, []Balance{}, empty.Balances)
+	assert.Equal(t, []Debt{}, empty.Debts)
+}
+
+func TestSplitExpenses(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{Storage: store, Splits: store}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	bob := auth.Principal{Subject: "bob", Roles: []string{auth.RoleEditor}}
+	call := func(p auth.Principal, method, target, body string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), p))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, handler(c))
+		return rec
+	}
+	message := func(rec *httptest.ResponseRecorder) string {
+		got := Err{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		return got.Msg
+	}
+
+	ex := Expense{Title: "dinner", Amount: money.MustParse("90"), Note: "team", Tags: []string{"food"}, Currency: "THB"}
+	store.InsertExpense(auth.WithPrincipal(context.Background(), alice), &ex)
+	id := strconv.Itoa(ex.ID)
+
+	t.Run("Split Expense Return HTTP Status OK", func(t *testing.T) {
+		rec := call(alice, http.MethodPut, "/", `{"method":"equal","shares":[{"participant":"alice"},{"participant":"bob"},{"participant":"carol"}]}`, h.SplitExpenseHandler, id)
+		got := Split{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, ex.ID, got.ExpenseID)
+		assert.Equal(t, "alice", got.Payer)
+		if assert.Equal(t, 3, len(got.Shares)) {
+			assert.Equal(t, money.MustParse("30"), got.Shares[1].Amount)
+		}
+
+		rec = call(alice, http.MethodGet, "/", "", h.GetSplitHandler, id)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Invalid Split Return HTTP Status Bad Request", func(t *testing.T) {
+		rec := call(alice, http.MethodPut, "/", `{"method":"exact","shares":[{"participant":"bob","amount":"10"}]}`, h.SplitExpenseHandler, id)
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, "Shares must sum to the amount", message(rec))
+	})
+
+	t.Run("Changing the amount of a split expense Return HTTP Status Conflict", func(t *testing.T) {
+		rec := call(alice, http.MethodPut, "/", `{"title":"dinner","amount":"120","note":"team","tags":["food"],"currency":"THB"}`, h.UpdateExpenseByIDHandler, id)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Equal(t, "Expense is split, update its split first", message(rec))
+
+		rec = call(alice, http.MethodPut, "/", `{"title":"team dinner","amount":"90","note":"team","tags":["food"],"currency":"THB"}`, h.UpdateExpenseByIDHandler, id)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Get Balances Return who owes whom", func(t *testing.T) {
+		rec := call(bob, http.MethodGet, "/balances", "", h.GetBalancesHandler, "")
+		got := Balances{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, []Debt{
+			{From: "bob", To: "alice", Currency: "THB", Amount: money.MustParse("30")},
+			{From: "carol", To: "alice", Currency: "THB", Amount: money.MustParse("30")},
+		}, got.Debts)
+
+		rec = call(auth.Principal{Subject: "dave"}, http.MethodGet, "/balances", "", h.GetBalancesHandler, "")
+		assert.JSONEq(t, `{"balances":[],"debts":[]}`, rec.Body.String())
+		rec = call(bob, http.MethodGet, "/balances?currency=usd", "", h.GetBalancesHandler, "")
+		assert.JSONEq(t, `{"balances":[],"debts":[]}`, rec.Body.String())
+	})
+
+	t.Run("Split of other owners Return HTTP Status Not Found", func(t *testing.T) {
+		rec := call(bob, http.MethodGet, "/", "", h.GetSplitHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = call(bob, http.MethodPut, "/", `{"method":"equal","shares":[{"participant":"bob"}]}`, h.SplitExpenseHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("Delete Split Return HTTP Status No Content", func(t *testing.T) {
+		rec := call(alice, http.MethodDelete, "/", "", h.DeleteSplitHandler, id)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		rec = call(alice, http.MethodDelete, "/", "", h.DeleteSplitHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, "Split not found", message(rec))
+	})
+}
+
+func TestPostgresSplits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "alice"})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT owner_id,currency FROM expenses WHERE id=\\$1 AND deleted_at IS NULL AND status NOT IN \\('approved','reimbursed'\\) AND owner_id=\\$2 AND kind='expense' FOR UPDATE").
+		WithArgs(1, "alice").WillReturnRows(sqlmock.NewRows([]string{"owner_id", "currency"}).AddRow("alice", "THB"))
+	mock.ExpectExec("DELETE FROM expense_shares WHERE expense_id=\\$1").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("INSERT INTO expense_shares").WithArgs(1, "alice", SplitPercent, "60", "54", 0).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO expense_shares").WithArgs(1, "bob", SplitPercent, "40", "36", 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	split := Split{ExpenseID: 1, Method: SplitPercent, Shares: []Share{
+		{Participant: "alice", Percent: percent("60"), Amount: money.MustParse("54")},
+		{Participant: "bob", Percent: percent("40"), Amount: money.MustParse("36")},
+	}}
+	assert.NoError(t, s.ReplaceSplit(ctx, &split))
+
+	mock.ExpectQuery("SELECT (.+) FROM expense_shares s JOIN expenses e ON e.id=s.expense_id WHERE e.deleted_at IS NULL AND e.status <> 'rejected' AND \\(e.owner_id=\\$1 OR s.expense_id IN \\(SELECT expense_id FROM expense_shares WHERE participant=\\$1\\)\\) ORDER BY s.expense_id, s.position").
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"expense_id", "owner_id", "currency", "method", "participant", "percent", "amount"}).
+			AddRow(1, "alice", "THB", SplitExact, "alice", nil, "50").
+			AddRow(1, "alice", "THB", SplitExact, "bob", nil, "40").
+			AddRow(2, "bob", "THB", SplitEqual, "alice", nil, "10"))
+	got, err := s.SelectSplits(ctx)
+	if assert.NoError(t, err) && assert.Equal(t, 2, len(got)) {
+		assert.Equal(t, 2, len(got[0].Shares))
+		assert.Nil(t, got[0].Shares[1].Percent)
+		assert.Equal(t, "bob", got[1].Payer)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,162 @@
+package expense
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Temwalker/assessment/statement"
+	"github.com/labstack/echo/v4"
+)
+
+// DefaultStatementTag tags imported transactions that carry no category.
+const DefaultStatementTag = "bank"
+
+const (
+	EntryNew       = "new"
+	EntryDuplicate = "duplicate"
+	EntryCredit    = "credit"
+	EntryInvalid   = "invalid"
+)
+
+// StatementEntry is a statement transaction mapped onto an expense. Only
+// new entries are imported: duplicates were imported before, credits are
+// money coming in rather than spent, and invalid ones fail validation.
+type StatementEntry struct {
+	FITID   string  `json:"fitid"`
+	Status  string  `json:"status"`
+	Message string  `json:"message,omitempty"`
+	Expense Expense `json:"expense"`
+}
+
+type StatementReport struct {
+	DryRun   bool             `json:"dry_run"`
+	Format   string           `json:"format"`
+	Imported int              `json:"imported"`
+	Entries  []StatementEntry `json:"entries"`
+}
+
+// statementExpense maps a debit onto an expense: title from the payee (or
+// memo), note from the memo (or payee) and the amount as spent.
+func statementExpense(t statement.Transaction, tags []string, currency string) Expense {
+	ex := Expense{
+		Title:    t.Payee,
+		Note:     t.Memo,
+		Amount:   t.Amount.Neg(),
+		Currency: t.Currency,
+		SpentAt:  t.Posted,
+		Tags:     tags,
+	}
+	if ex.Title == "" {
+		ex.Title = t.Memo
+	}
+	if ex.Note == "" {
+		ex.Note = t.Payee
+	}
+	if ex.Currency == "" {
+		ex.Currency = currency
+	}
+	if len(ex.Tags) == 0 && t.Category != "" {
+		ex.Tags = []string{t.Category}
+	}
+	if len(ex.Tags) == 0 {
+		ex.Tags = []string{DefaultStatementTag}
+	}
+	return ex
+}
+
+// statementEntries classifies every transaction, checking fitids against
+// the ones known to the store and earlier in the same file.
+func statementEntries(txns []statement.Transaction, known map[string]bool, tags []string, currency string) []StatementEntry {
+	entries := make([]StatementEntry, 0, len(txns))
+	seen := map[string]bool{}
+	for _, t := range txns {
+		e := StatementEntry{FITID: t.FITID, Status: EntryNew, Expense: statementExpense(t, tags, currency)}
+		switch {
+		case known[t.FITID] || seen[t.FITID]:
+			e.Status = EntryDuplicate
+		case t.Amount.Sign() >= 0:
+			e.Status = EntryCredit
+		default:
+			if checkEmptyField(e.Expense) {
+				e.Status, e.Message = EntryInvalid, "Missing payee or memo"
+			} else if msg := checkExpense(&e.Expense); msg != "" {
+				e.Status, e.Message = EntryInvalid, msg
+			}
+		}
+		seen[t.FITID] = true
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// ImportStatementHandler imports the debits of an OFX, QFX or QIF bank
+// statement, skipping transactions imported before. With dry_run=true it
+// returns the preview of what would be imported.
+func (h Handler) ImportStatementHandler(c echo.Context) error {
+	format := strings.ToLower(c.QueryParam("format"))
+	switch format {
+	case "", statement.FormatOFX, "qfx", statement.FormatQIF:
+	default:
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid format"})
+	}
+	dryRun, ifErr, respErr := getDryRunParam(c)
+	if ifErr {
+		return respErr
+	}
+	body, ifErr, respErr := importBody(c)
+	if ifErr {
+		return respErr
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return c.JSON(http.StatusRequestEntityTooLarge, Err{Msg: "Import is too large"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	if format == "" {
+		format = statement.Detect(data)
+	}
+	txns, err := statement.Parse(data, format, statement.Options{DayFirst: c.QueryParam("date_order") == "dmy"})
+	if errors.Is(err, statement.ErrUnknownFormat) {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Unknown statement format"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid statement: " + err.Error()})
+	}
+
+	ctx := c.Request().Context()
+	fitids := make([]string, len(txns))
+	for i, t := range txns {
+		fitids[i] = t.FITID
+	}
+	known, err := h.Storage.SelectStatementIDs(ctx, fitids)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	report := StatementReport{
+		DryRun:  dryRun,
+		Format:  format,
+		Entries: statementEntries(txns, known, parseTags(c.QueryParams()["tag"]), c.QueryParam("currency")),
+	}
+	if dryRun {
+		return c.JSON(http.StatusOK, report)
+	}
+	entries := []StatementEntry{}
+	for _, e := range report.Entries {
+		if e.Status == EntryInvalid {
+			return c.JSON(http.StatusUnprocessableEntity, report)
+		}
+		if e.Status == EntryNew {
+			entries = append(entries, e)
+		}
+	}
+	if report.Imported, err = h.Storage.ImportStatement(ctx, entries); err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusCreated, report)
+}
@@ -0,0 +1,133 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+const bankOFX = `OFXHEADER:100
+DATA:OFXSGML
+
+<OFX><BANKMSGSRSV1><STMTTRNRS><STMTRS><CURDEF>THB<BANKTRANLIST>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20230115<TRNAMT>-120.50<FITID>T1<NAME>Grab<MEMO>Taxi to client</STMTTRN>
+<STMTTRN><TRNTYPE>CREDIT<DTPOSTED>20230120<TRNAMT>30000<FITID>T2<NAME>Salary</STMTTRN>
+<STMTTRN><TRNTYPE>DEBIT<DTPOSTED>20230121<TRNAMT>-80<FITID>T3<NAME>Coffee</STMTTRN>
+</BANKTRANLIST></STMTRS></STMTTRNRS></BANKMSGSRSV1></OFX>
+`
+
+func TestImportStatement(t *testing.T) {
+	e := echo.New()
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	call := func(h Handler, query, body string) (*httptest.ResponseRecorder, StatementReport) {
+		req := httptest.NewRequest(http.MethodPost, "/expenses/import/statement"+query, bytes.NewBufferString(body))
+		req.Header.Add(echo.HeaderContentType, "application/x-ofx")
+		req = req.WithContext(auth.WithPrincipal(req.Context(), alice))
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.ImportStatementHandler(e.NewContext(req, rec)))
+		report := StatementReport{}
+		json.Unmarshal(rec.Body.Bytes(), &report)
+		return rec, report
+	}
+	statuses := func(report StatementReport) []string {
+		got := []string{}
+		for _, e := range report.Entries {
+			got = append(got, e.FITID+" "+e.Status)
+		}
+		return got
+	}
+	store := NewMemoryStore()
+	h := Handler{Storage: store}
+
+	t.Run("Preview maps debits without importing", func(t *testing.T) {
+		rec, report := call(h, "?dry_run=true&tag=travel", bankOFX)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "ofx", report.Format)
+		assert.Equal(t, []string{"T1 new", "T2 credit", "T3 new"}, statuses(report))
+		assert.Equal(t, "Grab", report.Entries[0].Expense.Title)
+		assert.Equal(t, "Taxi to client", report.Entries[0].Expense.Note)
+		assert.Equal(t, money.MustParse("120.50"), report.Entries[0].Expense.Amount)
+		assert.Equal(t, []string{"travel"}, report.Entries[0].Expense.Tags)
+		assert.Equal(t, "Coffee", report.Entries[2].Expense.Note)
+		assert.Equal(t, 0, report.Imported)
+	})
+
+	t.Run("Commit imports new debits once", func(t *testing.T) {
+		rec, report := call(h, "", bankOFX)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, 2, report.Imported)
+
+		_, report = call(h, "?dry_run=true", bankOFX)
+		assert.Equal(t, []string{"T1 duplicate", "T2 credit", "T3 duplicate"}, statuses(report))
+		rec, report = call(h, "", bankOFX)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, 0, report.Imported)
+
+		got := []Expense{}
+		store.SelectExpenses(context.Background(), ExpenseQuery{}, func(ex Expense) error {
+			got = append(got, ex)
+			return nil
+		})
+		if assert.Equal(t, 2, len(got)) {
+			assert.Equal(t, "alice", got[0].OwnerID)
+			assert.Equal(t, []string{DefaultStatementTag}, got[0].Tags)
+			assert.Equal(t, "2023-01-15", got[0].SpentAt.Format("2006-01-02"))
+		}
+	})
+
+	t.Run("Invalid transactions Return HTTP Status Unprocessable Entity", func(t *testing.T) {
+		qif := "!Type:Bank\nD1/15/2023\nT-10\nPShop\n^\nD1/16/2023\nT-5\n^\n"
+		rec, report := call(Handler{Storage: NewMemoryStore()}, "?currency=XYZ", qif)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+		if assert.Equal(t, 2, len(report.Entries)) {
+			assert.Equal(t, "Invalid currency", report.Entries[0].Message)
+			assert.Equal(t, "Missing payee or memo", report.Entries[1].Message)
+		}
+	})
+
+	t.Run("Bad files Return HTTP Status Bad Request", func(t *testing.T) {
+		for _, tt := range []struct{ query, body, want string }{
+			{"", "title,amount\n", "Unknown statement format"},
+			{"?format=csv", bankOFX, "Invalid format"},
+			{"?format=qif", "!Type:Bank\nD1/2/2023\n", "Invalid statement: qif: record at line 2: missing ^ terminator"},
+		} {
+			rec, _ := call(h, tt.query, tt.body)
+			got := Err{}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Equal(t, tt.want, got.Msg)
+		}
+	})
+}
+
+func TestPostgresStatementIDs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	ctx := auth.WithPrincipal(context.Background(), auth.Principal{Subject: "alice"})
+
+	mock.ExpectQuery("SELECT fitid FROM expenses WHERE owner_id=\\$1 AND fitid = ANY\\(\\$2\\)").
+		WithArgs("alice", pq.Array([]string{"T1", "T2"})).
+		WillReturnRows(sqlmock.NewRows([]string{"fitid"}).AddRow("T2"))
+
+	known, err := s.SelectStatementIDs(ctx, []string{"T1", "T2"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]bool{"T2": true}, known)
+	}
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
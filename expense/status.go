@@ -0,0 +1,61 @@
+package expense
+
+import "errors"
+
+const (
+	StatusDraft      = "draft"
+	StatusSubmitted  = "submitted"
+	StatusApproved   = "approved"
+	StatusRejected   = "rejected"
+	StatusReimbursed = "reimbursed"
+)
+
+var (
+	ErrIllegalTransition = errors.New("illegal status transition")
+	// ErrExpenseLocked means the expense was approved and can no longer be
+	// edited.
+	ErrExpenseLocked = errors.New("expense is approved")
+)
+
+// Transition moves an expense whose status is one of From to To. Reason is
+// kept with the new status and cleared by the next transition.
+type Transition struct {
+	Action string
+	From   []string
+	To     string
+	Reason string
+}
+
+var transitions = map[string]Transition{
+	"submit":    {Action: "submit", From: []string{StatusDraft, StatusRejected}, To: StatusSubmitted},
+	"approve":   {Action: "approve", From: []string{StatusSubmitted}, To: StatusApproved},
+	"reject":    {Action: "reject", From: []string{StatusSubmitted}, To: StatusRejected},
+	"reimburse": {Action: "reimburse", From: []string{StatusApproved}, To: StatusReimbursed},
+}
+
+func NewTransition(action, reason string) (Transition, bool) {
+	t, ok := transitions[action]
+	t.Reason = reason
+	return t, ok
+}
+
+func (t Transition) Allows(status string) bool {
+	for _, from := range t.From {
+		if from == status {
+			return true
+		}
+	}
+	return false
+}
+
+func isLocked(status string) bool {
+	return status == StatusApproved || status == StatusReimbursed
+}
+
+func isStatus(status string) bool {
+	switch status {
+	case StatusDraft, StatusSubmitted, StatusApproved, StatusRejected, StatusReimbursed:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+//go:build unit
+
+package expense
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransitions(t *testing.T) {
+	tests := []struct {
+		action string
+		from   string
+		want   bool
+	}{
+		{"submit", StatusDraft, true},
+		{"submit", StatusRejected, true},
+		{"submit", StatusApproved, false},
+		{"approve", StatusSubmitted, true},
+		{"approve", StatusDraft, false},
+		{"approve", StatusRejected, false},
+		{"reject", StatusSubmitted, true},
+		{"reject", StatusApproved, false},
+		{"reimburse", StatusApproved, true},
+		{"reimburse", StatusSubmitted, false},
+		{"reimburse", StatusReimbursed, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.action+" from "+tt.from, func(t *testing.T) {
+			tr, ok := NewTransition(tt.action, "")
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, tr.Allows(tt.from))
+		})
+	}
+
+	_, ok := NewTransition("archive", "")
+	assert.False(t, ok)
+}
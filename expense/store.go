@@ -0,0 +1,43 @@
+package expense
+
+import (
+	"context"
+	"time"
+)
+
+// ExpenseQuery selects a page of filtered expenses in Sort order, with id
+// as the final tie-breaker. AfterID is the last row of the previous page and
+// a zero Limit selects every remaining row.
+type ExpenseQuery struct {
+	Filter  ExpenseFilter
+	Sort    []SortField
+	AfterID int
+	Limit   int
+}
+
+type ExpenseStore interface {
+	InsertExpense(ctx context.Context, ex *Expense) error
+	// ImportExpenses inserts every expense or, on error, none of them.
+	ImportExpenses(ctx context.Context, expenses []Expense) error
+	// ImportStatement inserts the entries whose FITID the owner has not
+	// imported before and returns how many it inserted.
+	ImportStatement(ctx context.Context, entries []StatementEntry) (int, error)
+	SelectStatementIDs(ctx context.Context, fitids []string) (map[string]bool, error)
+	SelectExpenseByID(ctx context.Context, rowId int, ex *Expense) error
+	UpdateExpenseByID(ctx context.Context, rowId int, ex *Expense) error
+	SelectExpenses(ctx context.Context, q ExpenseQuery, each func(Expense) error) error
+	// SummarizeExpenses aggregates the expenses matching f, grouped by one
+	// of the GroupBy values and by currency. Unless currency is empty the
+	// amounts are first converted into it at the stored exchange rates.
+	SummarizeExpenses(ctx context.Context, f ExpenseFilter, groupBy, currency string) ([]SummaryGroup, error)
+	SearchExpenses(ctx context.Context, terms []string, limit int, each func(SearchResult) error) error
+	DeleteExpenseByID(ctx context.Context, rowId int) error
+	RestoreExpenseByID(ctx context.Context, rowId int, ex *Expense) error
+	TransitionExpenseByID(ctx context.Context, rowId int, t Transition, ex *Expense) error
+	// PurgeDeletedExpenses permanently removes the expenses deleted before
+	// deletedBefore with their attachments, returning how many expenses it
+	// removed and the storage keys of the attachments, whose blobs the
+	// caller deletes.
+	PurgeDeletedExpenses(ctx context.Context, deletedBefore time.Time) (int64, []string, error)
+	Close() error
+}
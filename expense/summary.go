@@ -0,0 +1,129 @@
+package expense
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	GroupByTag      = "tag"
+	GroupByDay      = "day"
+	GroupByWeek     = "week"
+	GroupByMonth    = "month"
+	GroupByCurrency = "currency"
+)
+
+var groupByValues = map[string]bool{
+	GroupByTag:      true,
+	GroupByDay:      true,
+	GroupByWeek:     true,
+	GroupByMonth:    true,
+	GroupByCurrency: true,
+}
+
+// SummaryGroup aggregates the expenses of one group in one currency, since
+// amounts in different currencies can not be added up. Average is rounded
+// to money.Scale fraction digits. When converting to a reporting currency,
+// expenses with no rate stay in their own currency in groups flagged with
+// MissingRate.
+type SummaryGroup struct {
+	Key         string       `json:"key"`
+	Currency    string       `json:"currency"`
+	Count       int          `json:"count"`
+	Total       money.Amount `json:"total"`
+	Average     money.Amount `json:"average"`
+	Min         money.Amount `json:"min"`
+	Max         money.Amount `json:"max"`
+	MissingRate bool         `json:"missing_rate,omitempty"`
+}
+
+type Summary struct {
+	GroupBy        string         `json:"group_by"`
+	ReportCurrency string         `json:"report_currency,omitempty"`
+	Groups         []SummaryGroup `json:"groups"`
+}
+
+// summaryKeys are the groups ex falls in: one per tag, or the UTC day, the
+// Monday starting its week or the month it was spent in, or its currency.
+func summaryKeys(ex Expense, groupBy string) []string {
+	spent := ex.SpentAt.UTC()
+	switch groupBy {
+	case GroupByTag:
+		return ex.Tags
+	case GroupByDay:
+		return []string{spent.Format("2006-01-02")}
+	case GroupByWeek:
+		monday := spent.AddDate(0, 0, -(int(spent.Weekday())+6)%7)
+		return []string{monday.Format("2006-01-02")}
+	case GroupByMonth:
+		return []string{spent.Format("2006-01")}
+	}
+	return []string{ex.Currency}
+}
+
+func (h Handler) SummarizeExpensesHandler(c echo.Context) error {
+	groupBy := c.QueryParam("group_by")
+	if groupBy == "" {
+		groupBy = GroupByCurrency
+	}
+	if !groupByValues[groupBy] {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid group_by"})
+	}
+	filter, _, ifErr, respErr := getFilterParams(c)
+	if ifErr {
+		return respErr
+	}
+	currency, ifErr, respErr := h.getReportCurrency(c)
+	if ifErr {
+		return respErr
+	}
+	groups, err := h.Storage.SummarizeExpenses(c.Request().Context(), filter, groupBy, currency)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, Summary{GroupBy: groupBy, ReportCurrency: currency, Groups: groups})
+}
+
+// summarize is the in-memory equivalent of the Postgres aggregates,
+// returning groups ordered by key and currency. Converted expenses count in
+// their reporting currency.
+func summarize(expenses []Expense, groupBy string) []SummaryGroup {
+	type groupKey struct{ key, currency string }
+	index := map[groupKey]int{}
+	groups := []SummaryGroup{}
+	for _, ex := range expenses {
+		amount, currency := reported(ex)
+		for _, key := range summaryKeys(ex, groupBy) {
+			k := groupKey{key, currency}
+			i, ok := index[k]
+			if !ok {
+				i = len(groups)
+				index[k] = i
+				groups = append(groups, SummaryGroup{Key: key, Currency: currency, Min: amount, Max: amount})
+			}
+			g := &groups[i]
+			g.Count++
+			g.Total = g.Total.Add(amount)
+			g.MissingRate = g.MissingRate || missingRate(ex)
+			if amount.Cmp(g.Min) < 0 {
+				g.Min = amount
+			}
+			if amount.Cmp(g.Max) > 0 {
+				g.Max = amount
+			}
+		}
+	}
+	for i := range groups {
+		groups[i].Average = groups[i].Total.Div(int64(groups[i].Count))
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Key != groups[j].Key {
+			return groups[i].Key < groups[j].Key
+		}
+		return groups[i].Currency < groups[j].Currency
+	})
+	return groups
+}
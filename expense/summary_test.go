@@ -0,0 +1,82 @@
+//go:build unit
+
+package expense
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeExpenses(t *testing.T) {
+	store := NewMemoryStore()
+	for _, ex := range []Expense{
+		{Title: "taxi", Amount: money.MustParse("100"), Note: "n", Tags: []string{"travel", "client"}, Currency: "THB", SpentAt: time.Date(2023, 1, 2, 10, 0, 0, 0, time.UTC)},
+		{Title: "train", Amount: money.MustParse("50.5"), Note: "n", Tags: []string{"travel"}, Currency: "THB", SpentAt: time.Date(2023, 1, 8, 23, 0, 0, 0, time.UTC)},
+		{Title: "lunch", Amount: money.MustParse("20"), Note: "n", Tags: []string{"food"}, Currency: "THB", SpentAt: time.Date(2023, 1, 9, 12, 0, 0, 0, time.UTC)},
+		{Title: "coffee", Amount: money.MustParse("4.25"), Note: "n", Tags: []string{"food"}, Currency: "USD", SpentAt: time.Date(2023, 2, 1, 8, 0, 0, 0, time.UTC)},
+	} {
+		ex := ex
+		store.InsertExpense(context.Background(), &ex)
+	}
+	h := Handler{Storage: store}
+	call := func(query string) (*httptest.ResponseRecorder, Summary) {
+		req := httptest.NewRequest(http.MethodGet, "/expenses/summary"+query, nil)
+		rec := httptest.NewRecorder()
+		assert.NoError(t, h.SummarizeExpensesHandler(echo.New().NewContext(req, rec)))
+		got := Summary{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		return rec, got
+	}
+	group := func(key, currency string, count int, total, average, min, max string) SummaryGroup {
+		return SummaryGroup{Key: key, Currency: currency, Count: count, Total: money.MustParse(total),
+			Average: money.MustParse(average), Min: money.MustParse(min), Max: money.MustParse(max)}
+	}
+
+	tests := []struct {
+		query string
+		want  Summary
+	}{
+		{"", Summary{GroupBy: GroupByCurrency, Groups: []SummaryGroup{
+			group("THB", "THB", 3, "170.5", "56.8333", "20", "100"),
+			group("USD", "USD", 1, "4.25", "4.25", "4.25", "4.25"),
+		}}},
+		{"?group_by=tag", Summary{GroupBy: GroupByTag, Groups: []SummaryGroup{
+			group("client", "THB", 1, "100", "100", "100", "100"),
+			group("food", "THB", 1, "20", "20", "20", "20"),
+			group("food", "USD", 1, "4.25", "4.25", "4.25", "4.25"),
+			group("travel", "THB", 2, "150.5", "75.25", "50.5", "100"),
+		}}},
+		{"?group_by=week&tag=travel&tag=food", Summary{GroupBy: GroupByWeek, Groups: []SummaryGroup{
+			group("2023-01-02", "THB", 2, "150.5", "75.25", "50.5", "100"),
+			group("2023-01-09", "THB", 1, "20", "20", "20", "20"),
+			group("2023-01-30", "USD", 1, "4.25", "4.25", "4.25", "4.25"),
+		}}},
+		{"?group_by=month&min_amount=10", Summary{GroupBy: GroupByMonth, Groups: []SummaryGroup{
+			group("2023-01", "THB", 3, "170.5", "56.8333", "20", "100"),
+		}}},
+		{"?group_by=day&q=lunch", Summary{GroupBy: GroupByDay, Groups: []SummaryGroup{
+			group("2023-01-09", "THB", 1, "20", "20", "20", "20"),
+		}}},
+		{"?group_by=day&q=nothing", Summary{GroupBy: GroupByDay, Groups: []SummaryGroup{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec, got := call(tt.query)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("Invalid group_by Return HTTP Status Bad Request", func(t *testing.T) {
+		rec, _ := call("?group_by=year")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+}
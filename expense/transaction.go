@@ -0,0 +1,320 @@
+package expense
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	KindExpense  = "expense"
+	KindIncome   = "income"
+	KindTransfer = "transfer"
+)
+
+var transactionKinds = []string{KindExpense, KindIncome, KindTransfer}
+
+// flowKinds are the kinds that move money in or out; transfers only move
+// it between the owner's accounts.
+var flowKinds = []string{KindExpense, KindIncome}
+
+func isKind(kind string) bool {
+	for _, k := range transactionKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// TransactionStore keeps expenses, income and transfers. Transactions are
+// expenses with their Kind set, so an expense keeps its status workflow
+// whichever API created it.
+type TransactionStore interface {
+	InsertTransaction(ctx context.Context, t *Expense) error
+	SelectTransactionByID(ctx context.Context, id int, t *Expense) error
+	// UpdateTransactionByID keeps the kind of the transaction and, like
+	// UpdateExpenseByID, returns ErrExpenseLocked for an approved expense.
+	UpdateTransactionByID(ctx context.Context, id int, t *Expense) error
+	DeleteTransactionByID(ctx context.Context, id int) error
+	// SelectTransactions is SelectExpenses for every kind in q.Filter.Kinds.
+	SelectTransactions(ctx context.Context, q ExpenseQuery, each func(Expense) error) error
+	// SummarizeFlow adds up the income and expenses matching f, grouped by
+	// one of the GroupBy values and by currency, converting into currency
+	// like SummarizeExpenses.
+	SummarizeFlow(ctx context.Context, f ExpenseFilter, groupBy, currency string) ([]FlowGroup, error)
+}
+
+// FlowGroup is the money that came in and went out in one group and
+// currency. Net is Income less Expenses. MissingRate is set like on a
+// SummaryGroup.
+type FlowGroup struct {
+	Key         string       `json:"key"`
+	Currency    string       `json:"currency"`
+	Income      money.Amount `json:"income"`
+	Expenses    money.Amount `json:"expenses"`
+	Net         money.Amount `json:"net"`
+	MissingRate bool         `json:"missing_rate,omitempty"`
+}
+
+type Flow struct {
+	GroupBy        string      `json:"group_by"`
+	ReportCurrency string      `json:"report_currency,omitempty"`
+	Groups         []FlowGroup `json:"groups"`
+}
+
+type TransactionPage struct {
+	Transactions []Expense `json:"transactions"`
+	NextCursor   string    `json:"next_cursor,omitempty"`
+}
+
+// flow is the in-memory equivalent of the Postgres aggregates, returning
+// groups ordered by key and currency.
+func flow(transactions []Expense, groupBy string) []FlowGroup {
+	type groupKey struct{ key, currency string }
+	index := map[groupKey]int{}
+	groups := []FlowGroup{}
+	for _, t := range transactions {
+		amount, currency := reported(t)
+		for _, key := range summaryKeys(t, groupBy) {
+			k := groupKey{key, currency}
+			i, ok := index[k]
+			if !ok {
+				i = len(groups)
+				index[k] = i
+				groups = append(groups, FlowGroup{Key: key, Currency: currency})
+			}
+			g := &groups[i]
+			g.MissingRate = g.MissingRate || missingRate(t)
+			if t.Kind == KindIncome {
+				g.Income = g.Income.Add(amount)
+			} else {
+				g.Expenses = g.Expenses.Add(amount)
+			}
+		}
+	}
+	for i := range groups {
+		groups[i].Net = groups[i].Income.Sub(groups[i].Expenses)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Key != groups[j].Key {
+			return groups[i].Key < groups[j].Key
+		}
+		return groups[i].Currency < groups[j].Currency
+	})
+	return groups
+}
+
+// getKindParams reads ?kind=, which may be repeated or comma separated, and
+// defaults to every kind.
+func getKindParams(c echo.Context) ([]string, bool) {
+	kinds := parseTags(c.QueryParams()["kind"])
+	if len(kinds) == 0 {
+		return transactionKinds, true
+	}
+	for i, kind := range kinds {
+		kinds[i] = strings.ToLower(kind)
+		if !isKind(kinds[i]) {
+			return nil, false
+		}
+	}
+	return kinds, true
+}
+
+// bindTransaction binds and validates a transaction whose kind defaults to
+// kind.
+func bindTransaction(c echo.Context, t *Expense, kind string) (bool, error) {
+	if err := c.Bind(t); err != nil {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: "Invalid request body"})
+	}
+	invalid := func(msg string) (bool, error) {
+		return true, c.JSON(http.StatusBadRequest, Err{Msg: msg})
+	}
+	t.Kind = strings.ToLower(t.Kind)
+	if t.Kind == "" {
+		t.Kind = kind
+	}
+	if !isKind(t.Kind) {
+		return invalid("Invalid kind")
+	}
+	if msg := checkExpense(t); msg != "" {
+		return invalid(msg)
+	}
+	if t.Kind != KindTransfer && t.ToAccountID != nil {
+		return invalid("Only transfers have a to_account_id")
+	}
+	if t.Kind == KindTransfer && (t.AccountID == nil || t.ToAccountID == nil || *t.AccountID == *t.ToAccountID) {
+		return invalid("Invalid transfer accounts")
+	}
+	return false, nil
+}
+
+func returnTransaction(err error, c echo.Context, status int, t Expense) error {
+	if errors.Is(err, ErrExpenseLocked) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be edited"})
+	}
+	if err != nil && err.Error() == sql.ErrNoRows.Error() {
+		return c.JSON(http.StatusNotFound, Err{Msg: "Transaction not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(status, t)
+}
+
+// CreateTransactionHandler records an expense, which is the default kind,
+// an income or a transfer.
+func (h Handler) CreateTransactionHandler(c echo.Context) error {
+	t := Expense{}
+	if ifErr, respErr := bindTransaction(c, &t, KindExpense); ifErr {
+		return respErr
+	}
+	if ifErr, respErr := h.checkAccount(c, t); ifErr {
+		return respErr
+	}
+	err := h.Transactions.InsertTransaction(c.Request().Context(), &t)
+	if err == nil && t.Kind == KindExpense {
+		h.checkBudgets(c, t)
+	}
+	return returnTransaction(err, c, http.StatusCreated, t)
+}
+
+func (h Handler) GetTransactionByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	t := Expense{}
+	err := h.Transactions.SelectTransactionByID(c.Request().Context(), intVar, &t)
+	return returnTransaction(err, c, http.StatusOK, t)
+}
+
+// UpdateTransactionByIDHandler replaces a transaction, which keeps its kind.
+// Expenses follow the same rules as on PUT /expenses/:id.
+func (h Handler) UpdateTransactionByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	ctx := c.Request().Context()
+	old := Expense{}
+	if err := h.Transactions.SelectTransactionByID(ctx, intVar, &old); err != nil {
+		return returnTransaction(err, c, 0, old)
+	}
+	t := Expense{}
+	if ifErr, respErr := bindTransaction(c, &t, old.Kind); ifErr {
+		return respErr
+	}
+	if t.Kind != old.Kind {
+		return c.JSON(http.StatusConflict, Err{Msg: "Transaction kind can not be changed"})
+	}
+	if t.Kind == KindExpense {
+		if ifErr, respErr := h.splitConflict(c, intVar, t); ifErr {
+			return respErr
+		}
+	}
+	if ifErr, respErr := h.checkAccount(c, t); ifErr {
+		return respErr
+	}
+	err := h.Transactions.UpdateTransactionByID(ctx, intVar, &t)
+	if err == nil && t.Kind == KindExpense {
+		h.checkBudgets(c, t)
+	}
+	return returnTransaction(err, c, http.StatusOK, t)
+}
+
+func (h Handler) DeleteTransactionByIDHandler(c echo.Context) error {
+	intVar, ifErr, respErr := getIDParam(c)
+	if ifErr {
+		return respErr
+	}
+	err := h.Transactions.DeleteTransactionByID(c.Request().Context(), intVar)
+	if errors.Is(err, ErrExpenseLocked) {
+		return c.JSON(http.StatusConflict, Err{Msg: "Approved expense can not be deleted"})
+	}
+	if err != nil {
+		return returnTransaction(err, c, 0, Expense{})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// GetTransactionsHandler lists transactions like GET /expenses lists
+// expenses, optionally only those of one or more ?kind=.
+func (h Handler) GetTransactionsHandler(c echo.Context) error {
+	q, paged, ifErr, respErr := getListParams(c)
+	if ifErr {
+		return respErr
+	}
+	var ok bool
+	if q.Filter.Kinds, ok = getKindParams(c); !ok {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid kind"})
+	}
+	currency, ifErr, respErr := h.getReportCurrency(c)
+	if ifErr {
+		return respErr
+	}
+	limit := q.Limit
+	if paged {
+		q.Limit++
+	}
+	ctx := c.Request().Context()
+	transactions := []Expense{}
+	err := h.Transactions.SelectTransactions(ctx, q, func(t Expense) error {
+		transactions = append(transactions, t)
+		return nil
+	})
+	if errors.Is(err, errInvalidCursor) {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid cursor"})
+	}
+	if err == nil {
+		err = convertExpenses(ctx, h.Rates, currency, transactions)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	if !paged {
+		return c.JSON(http.StatusOK, transactions)
+	}
+	page := TransactionPage{Transactions: transactions}
+	if len(transactions) > limit {
+		page.Transactions = transactions[:limit]
+		page.NextCursor = encodeCursor(expenseCursor{ID: page.Transactions[limit-1].ID, Sort: sortKey(q.Sort)})
+		c.Response().Header().Set("Link", nextPageLink(c, page.NextCursor))
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// GetFlowHandler reports the net flow, income less expenses, of the
+// transactions matching the GET /transactions filters, grouped like
+// GET /expenses/summary but by month unless ?group_by= says otherwise.
+// Transfers between accounts are left out since they net to zero. Like the
+// expense summary it converts to a reporting currency when asked to.
+func (h Handler) GetFlowHandler(c echo.Context) error {
+	groupBy := c.QueryParam("group_by")
+	if groupBy == "" {
+		groupBy = GroupByMonth
+	}
+	if !groupByValues[groupBy] {
+		return c.JSON(http.StatusBadRequest, Err{Msg: "Invalid group_by"})
+	}
+	filter, _, ifErr, respErr := getFilterParams(c)
+	if ifErr {
+		return respErr
+	}
+	currency, ifErr, respErr := h.getReportCurrency(c)
+	if ifErr {
+		return respErr
+	}
+	filter.Kinds = flowKinds
+	groups, err := h.Transactions.SummarizeFlow(c.Request().Context(), filter, groupBy, currency)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, Err{Msg: "Internal error"})
+	}
+	return c.JSON(http.StatusOK, Flow{GroupBy: groupBy, ReportCurrency: currency, Groups: groups})
+}
@@ -0,0 +1,197 @@
+//go:build unit
+
+package expense
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/Temwalker/assessment/money"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactions(t *testing.T) {
+	e := echo.New()
+	store := NewMemoryStore()
+	h := Handler{Storage: store, Accounts: store, Transactions: store}
+	alice := auth.Principal{Subject: "alice", Roles: []string{auth.RoleEditor}}
+	call := func(method, target, body string, handler echo.HandlerFunc, id string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, bytes.NewBufferString(body))
+		req.Header.Add(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		req = req.WithContext(auth.WithPrincipal(req.Context(), alice))
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+		assert.NoError(t, handler(c))
+		return rec
+	}
+	account := func(opening string) Account {
+		rec := call(http.MethodPost, "/accounts", `{"name":"bank","type":"bank","currency":"THB","opening_balance":"`+opening+`"}`, h.CreateAccountHandler, "")
+		a := Account{}
+		json.Unmarshal(rec.Body.Bytes(), &a)
+		return a
+	}
+	balance := func(a Account) money.Amount {
+		got := Account{}
+		id := strconv.Itoa(a.ID)
+		json.Unmarshal(call(http.MethodGet, "/accounts/"+id, "", h.GetAccountByIDHandler, id).Body.Bytes(), &got)
+		return got.Balance
+	}
+	create := func(body string) (*httptest.ResponseRecorder, Expense) {
+		rec := call(http.MethodPost, "/transactions", body, h.CreateTransactionHandler, "")
+		got := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		return rec, got
+	}
+	main, savings := account("1000"), account("0")
+
+	var income, transfer Expense
+	t.Run("Create Transactions of every kind Return HTTP Status Created", func(t *testing.T) {
+		rec, got := create(fmt.Sprintf(`{"kind":"Income","title":"salary","amount":"500","currency":"THB","note":"jan","tags":["work"],"spent_at":"2023-01-25T00:00:00Z","account_id":%d}`, main.ID))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, KindIncome, got.Kind)
+		income = got
+
+		rec, got = create(fmt.Sprintf(`{"title":"rent","amount":"200","currency":"THB","note":"jan","tags":["home"],"spent_at":"2023-01-05T00:00:00Z","account_id":%d}`, main.ID))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, KindExpense, got.Kind)
+		assert.Equal(t, StatusDraft, got.Status)
+
+		rec, transfer = create(fmt.Sprintf(`{"kind":"transfer","title":"save","amount":"300","currency":"THB","note":"jan","tags":["savings"],"spent_at":"2023-02-01T00:00:00Z","account_id":%d,"to_account_id":%d}`, main.ID, savings.ID))
+		assert.Equal(t, http.StatusCreated, rec.Code)
+
+		assert.Equal(t, money.FromInt(1000), balance(main))
+		assert.Equal(t, money.FromInt(300), balance(savings))
+	})
+
+	t.Run("Invalid Transaction Return HTTP Status Bad Request", func(t *testing.T) {
+		for body, want := range map[string]string{
+			`{"kind":"refund","title":"x","amount":"1","currency":"THB","note":"n","tags":["t"]}`:                                                                        "Invalid kind",
+			fmt.Sprintf(`{"kind":"transfer","title":"x","amount":"1","currency":"THB","note":"n","tags":["t"],"account_id":%d,"to_account_id":%d}`, main.ID, main.ID):    "Invalid transfer accounts",
+			fmt.Sprintf(`{"kind":"income","title":"x","amount":"1","currency":"THB","note":"n","tags":["t"],"to_account_id":%d}`, main.ID):                               "Only transfers have a to_account_id",
+			fmt.Sprintf(`{"kind":"transfer","title":"x","amount":"1","currency":"USD","note":"n","tags":["t"],"account_id":%d,"to_account_id":%d}`, main.ID, savings.ID): "Account currency does not match",
+		} {
+			rec, _ := create(body)
+			got := Err{}
+			json.Unmarshal(rec.Body.Bytes(), &got)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+			assert.Equal(t, want, got.Msg, body)
+		}
+	})
+
+	t.Run("Expenses API only sees expenses", func(t *testing.T) {
+		rec := call(http.MethodGet, "/expenses", "", h.GetAllExpensesHandler, "")
+		expenses := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &expenses)
+		if assert.Equal(t, 1, len(expenses)) {
+			assert.Equal(t, "rent", expenses[0].Title)
+			assert.Equal(t, "", expenses[0].Kind)
+		}
+		id := strconv.Itoa(income.ID)
+		rec = call(http.MethodGet, "/expenses/"+id, "", h.GetExpenseByIdHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		rec = call(http.MethodDelete, "/expenses/"+id, "", h.DeleteExpenseByIDHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+
+		rec = call(http.MethodPost, "/expenses", `{"kind":"income","title":"taxi","amount":"10","currency":"THB","note":"n","tags":["travel"]}`, h.CreateExpenseHandler, "")
+		got := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "", got.Kind)
+	})
+
+	t.Run("Get Transactions filters by kind", func(t *testing.T) {
+		rec := call(http.MethodGet, "/transactions", "", h.GetTransactionsHandler, "")
+		all := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &all)
+		assert.Equal(t, 4, len(all))
+
+		rec = call(http.MethodGet, "/transactions?kind=income,transfer&account_id="+strconv.Itoa(savings.ID), "", h.GetTransactionsHandler, "")
+		got := []Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		if assert.Equal(t, 1, len(got)) {
+			assert.Equal(t, transfer.ID, got[0].ID)
+		}
+
+		rec = call(http.MethodGet, "/transactions?kind=refund", "", h.GetTransactionsHandler, "")
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	})
+
+	t.Run("Flow nets income against expenses and leaves out transfers", func(t *testing.T) {
+		rec := call(http.MethodGet, "/transactions/flow?spent_to=2023-03-01", "", h.GetFlowHandler, "")
+		got := Flow{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, GroupByMonth, got.GroupBy)
+		assert.Equal(t, []FlowGroup{
+			{Key: "2023-01", Currency: "THB", Income: money.FromInt(500), Expenses: money.FromInt(200), Net: money.FromInt(300)},
+		}, got.Groups)
+	})
+
+	t.Run("Update Transaction keeps its kind", func(t *testing.T) {
+		id := strconv.Itoa(income.ID)
+		body := fmt.Sprintf(`{"title":"salary","amount":"600","currency":"THB","note":"jan","tags":["work"],"account_id":%d}`, main.ID)
+		rec := call(http.MethodPut, "/transactions/"+id, body, h.UpdateTransactionByIDHandler, id)
+		got := Expense{}
+		json.Unmarshal(rec.Body.Bytes(), &got)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, KindIncome, got.Kind)
+		assert.Equal(t, money.FromInt(1100), balance(main))
+
+		rec = call(http.MethodPut, "/transactions/"+id, `{"kind":"expense","title":"salary","amount":"600","currency":"THB","note":"jan","tags":["work"]}`, h.UpdateTransactionByIDHandler, id)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Delete approved Transaction Return HTTP Status Conflict", func(t *testing.T) {
+		_, rent := create(`{"title":"rent","amount":"200","currency":"THB","note":"feb","tags":["home"],"spent_at":"2023-02-05T00:00:00Z"}`)
+		for _, action := range []string{"submit", "approve"} {
+			tr, _ := NewTransition(action, "")
+			store.TransitionExpenseByID(context.Background(), rent.ID, tr, &Expense{})
+		}
+		id := strconv.Itoa(rent.ID)
+		rec := call(http.MethodDelete, "/transactions/"+id, "", h.DeleteTransactionByIDHandler, id)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		rec = call(http.MethodDelete, "/expenses/"+id, "", h.DeleteExpenseByIDHandler, id)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	})
+
+	t.Run("Delete Transaction Return HTTP Status No Content", func(t *testing.T) {
+		id := strconv.Itoa(transfer.ID)
+		rec := call(http.MethodDelete, "/transactions/"+id, "", h.DeleteTransactionByIDHandler, id)
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, money.Amount{}, balance(savings))
+		rec = call(http.MethodGet, "/transactions/"+id, "", h.GetTransactionByIDHandler, id)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestPostgresInsertTransaction(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	s := NewPostgresStore(&database.DB{Database: db})
+	from, to := 1, 2
+	tr := Expense{Kind: KindTransfer, Title: "save", Amount: money.FromInt(300), Note: "n", Tags: []string{"savings"}, Currency: "THB", AccountID: &from, ToAccountID: &to}
+
+	mock.ExpectQuery("INSERT INTO expenses \\(title,amount,note,tags,currency,spent_at,owner_id,account_id,kind,to_account_id\\)").
+		WithArgs("save", tr.Amount, "n", pq.Array(&tr.Tags), "THB", nil, "default", &from, KindTransfer, &to).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "spent_at", "created_at", "updated_at"}).AddRow(9, testTime, testTime, testTime))
+
+	assert.NoError(t, s.InsertTransaction(context.Background(), &tr))
+	assert.Equal(t, 9, tr.ID)
+	assert.Equal(t, StatusDraft, tr.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
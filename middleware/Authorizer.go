@@ -3,18 +3,74 @@ package middleware
 import (
 	"net/http"
 
+	"github.com/Temwalker/assessment/auth"
 	"github.com/labstack/echo/v4"
 )
 
+// PrincipalKey is the echo.Context key holding the authenticated
+// auth.Principal.
+const PrincipalKey = "principal"
+
+// errMsg matches the error body the expense handlers return.
+type errMsg struct {
+	Msg string `json:"message"`
+}
+
+// Authenticate rejects requests a does not accept and otherwise stores the
+// principal in the echo context and in the request context.
+func Authenticate(a auth.Authenticator) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			p, err := a.Authenticate(c.Request())
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, "")
+			}
+			c.Set(PrincipalKey, p)
+			c.SetRequest(c.Request().WithContext(auth.WithPrincipal(c.Request().Context(), p)))
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+			return nil
+		}
+	}
+}
+
+// Authorizer accepts only the legacy shared key.
 func Authorizer(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		if c.Request().Header.Get(echo.HeaderAuthorization) != "November 10, 2009" {
-			return c.JSON(http.StatusUnauthorized, "")
+	return Authenticate(auth.LegacyAPIKeys())(next)
+}
+
+func Principal(c echo.Context) (auth.Principal, bool) {
+	p, ok := c.Get(PrincipalKey).(auth.Principal)
+	return p, ok
+}
+
+// Authorize enforces p on every route registered with the echo instance,
+// denying routes p does not list. Requests no route matches are left for echo
+// to answer with 404 or 405. It must run after Authenticate.
+func Authorize(p *auth.Policy) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			method := c.Request().Method
+			required, listed := p.Required(method, c.Path())
+			if !listed && !routed(c, method) {
+				return next(c)
+			}
+			principal, _ := Principal(c)
+			if !listed || !p.Allows(principal, required) {
+				return c.JSON(http.StatusForbidden, errMsg{Msg: "Permission denied"})
+			}
+			return next(c)
 		}
+	}
+}
 
-		if err := next(c); err != nil {
-			c.Error(err)
+func routed(c echo.Context, method string) bool {
+	for _, r := range c.Echo().Routes() {
+		if r.Method == method && r.Path == c.Path() {
+			return true
 		}
-		return nil
 	}
+	return false
 }
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/Temwalker/assessment/auth"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 )
@@ -48,3 +49,87 @@ func TestAuthorizer(t *testing.T) {
 		assert.Equal(t, http.StatusUnauthorized, rec.Code)
 	})
 }
+
+func TestAuthenticate(t *testing.T) {
+	keys, _ := auth.NewAPIKeys([]auth.APIKey{{Subject: "reporting", SHA256: auth.HashAPIKey("s3cret"), Roles: []string{"admin"}}})
+	want := auth.Principal{Subject: "reporting", Roles: []string{"admin"}, Method: auth.MethodAPIKey}
+
+	t.Run("Authenticated principal is available to handlers", func(t *testing.T) {
+		e := echo.New()
+		e.Use(Authenticate(keys))
+		e.GET("/", func(c echo.Context) error {
+			p, ok := Principal(c)
+			assert.True(t, ok)
+			assert.Equal(t, want, p)
+			p, ok = auth.PrincipalFrom(c.Request().Context())
+			assert.True(t, ok)
+			assert.Equal(t, want, p)
+			return c.String(http.StatusOK, "")
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Add(echo.HeaderAuthorization, "ApiKey s3cret")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("Missing credentials Return HTTP StatusUnauthorized", func(t *testing.T) {
+		e := echo.New()
+		e.Use(Authenticate(keys))
+		e.GET("/", func(c echo.Context) error {
+			return c.String(http.StatusOK, "")
+		})
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	keys, _ := auth.NewAPIKeys([]auth.APIKey{
+		{Subject: "viewer", SHA256: auth.HashAPIKey("viewer"), Roles: []string{auth.RoleViewer}},
+		{Subject: "editor", SHA256: auth.HashAPIKey("editor"), Roles: []string{auth.RoleEditor}},
+	})
+	newServer := func() *echo.Echo {
+		e := echo.New()
+		e.Use(Authenticate(keys))
+		e.Use(Authorize(auth.DefaultPolicy()))
+		ok := func(c echo.Context) error {
+			return c.String(http.StatusOK, "")
+		}
+		e.GET("/expenses/:id", ok)
+		e.PUT("/expenses/:id", ok)
+		e.GET("/unlisted", ok)
+		e.POST("/rates", ok)
+		return e
+	}
+
+	tests := []struct {
+		testname string
+		key      string
+		method   string
+		target   string
+		wantCode int
+	}{
+		{"Viewer reads Return HTTP StatusOK", "viewer", http.MethodGet, "/expenses/1", http.StatusOK},
+		{"Viewer updates Return HTTP StatusForbidden", "viewer", http.MethodPut, "/expenses/1", http.StatusForbidden},
+		{"Editor updates Return HTTP StatusOK", "editor", http.MethodPut, "/expenses/1", http.StatusOK},
+		{"Editor saves rates Return HTTP StatusForbidden", "editor", http.MethodPost, "/rates", http.StatusForbidden},
+		{"Route missing from policy Return HTTP StatusForbidden", "editor", http.MethodGet, "/unlisted", http.StatusForbidden},
+		{"Unknown path Return HTTP StatusNotFound", "viewer", http.MethodGet, "/nowhere", http.StatusNotFound},
+		{"Unregistered method Return HTTP StatusMethodNotAllowed", "editor", http.MethodDelete, "/expenses/1", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			req.Header.Add(echo.HeaderAuthorization, "ApiKey "+tt.key)
+			rec := httptest.NewRecorder()
+			newServer().ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusForbidden {
+				assert.JSONEq(t, `{"message": "Permission denied"}`, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	TenantHeader = "X-Tenant-ID"
+	// TenantKey is the echo.Context key holding the resolved tenant.
+	TenantKey = "tenant"
+)
+
+// Tenant resolves the request's tenant from the principal, then from the
+// X-Tenant-ID header, then falls back to database.DefaultTenant, and stores
+// it in the request context for the database package. It must run after
+// Authenticate. A principal bound to a tenant can not switch to another one,
+// and only an unbound admin may pick a tenant with the header; anyone else
+// unbound stays in the default tenant.
+func Tenant(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		header := c.Request().Header.Get(TenantHeader)
+		tenant := database.DefaultTenant
+		p, _ := Principal(c)
+		switch {
+		case p.Tenant != "" && header != "" && header != p.Tenant:
+			return c.JSON(http.StatusForbidden, errMsg{Msg: "Tenant not allowed"})
+		case p.Tenant != "":
+			tenant = p.Tenant
+		case header != "" && !p.HasRole(auth.RoleAdmin):
+			return c.JSON(http.StatusForbidden, errMsg{Msg: "Tenant not allowed"})
+		case header != "":
+			tenant = header
+		}
+		if !database.ValidTenant(tenant) {
+			return c.JSON(http.StatusBadRequest, errMsg{Msg: "Invalid tenant"})
+		}
+		c.Set(TenantKey, tenant)
+		c.SetRequest(c.Request().WithContext(database.WithTenant(c.Request().Context(), tenant)))
+
+		if err := next(c); err != nil {
+			c.Error(err)
+		}
+		return nil
+	}
+}
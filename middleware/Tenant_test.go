@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/database"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenant(t *testing.T) {
+	keys, _ := auth.NewAPIKeys([]auth.APIKey{
+		{Subject: "acme-app", SHA256: auth.HashAPIKey("acme"), Tenant: "acme"},
+		{Subject: "ops", SHA256: auth.HashAPIKey("ops"), Roles: []string{auth.RoleAdmin}},
+		{Subject: "app", SHA256: auth.HashAPIKey("app"), Roles: []string{auth.RoleEditor}},
+	})
+
+	tests := []struct {
+		testname   string
+		key        string
+		header     string
+		wantCode   int
+		wantTenant string
+	}{
+		{"Bound principal uses its tenant", "acme", "", http.StatusOK, "acme"},
+		{"Bound principal may repeat its tenant", "acme", "acme", http.StatusOK, "acme"},
+		{"Bound principal can not switch tenant", "acme", "globex", http.StatusForbidden, ""},
+		{"Unbound admin picks tenant by header", "ops", "globex", http.StatusOK, "globex"},
+		{"Unbound admin falls back to default", "ops", "", http.StatusOK, database.DefaultTenant},
+		{"Unbound non-admin can not pick tenant", "app", "globex", http.StatusForbidden, ""},
+		{"Unbound non-admin uses default", "app", "", http.StatusOK, database.DefaultTenant},
+		{"Invalid tenant Return HTTP StatusBadRequest", "ops", "Globex!", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.testname, func(t *testing.T) {
+			e := echo.New()
+			e.Use(Authenticate(keys))
+			e.Use(Tenant)
+			e.GET("/", func(c echo.Context) error {
+				tenant, ok := database.TenantFrom(c.Request().Context())
+				assert.True(t, ok)
+				assert.Equal(t, tt.wantTenant, tenant)
+				assert.Equal(t, tt.wantTenant, c.Get(TenantKey))
+				return c.String(http.StatusOK, "")
+			})
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Add(echo.HeaderAuthorization, "ApiKey "+tt.key)
+			if tt.header != "" {
+				req.Header.Add(TenantHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package money
+
+import "strings"
+
+const DefaultCurrency = "THB"
+
+// minorUnits lists the ISO 4217 currencies accepted by the API with the
+// number of fraction digits each allows.
+var minorUnits = map[string]int{
+	"AUD": 2,
+	"BHD": 3,
+	"CAD": 2,
+	"CHF": 2,
+	"CNY": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"HKD": 2,
+	"IDR": 2,
+	"INR": 2,
+	"JPY": 0,
+	"KHR": 2,
+	"KRW": 0,
+	"KWD": 3,
+	"LAK": 2,
+	"MMK": 2,
+	"MYR": 2,
+	"NZD": 2,
+	"PHP": 2,
+	"SGD": 2,
+	"THB": 2,
+	"TWD": 2,
+	"USD": 2,
+	"VND": 0,
+}
+
+// NormalizeCurrency upper-cases code and falls back to DefaultCurrency when
+// it is empty.
+func NormalizeCurrency(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return DefaultCurrency
+	}
+	return code
+}
+
+func IsCurrency(code string) bool {
+	_, ok := minorUnits[code]
+	return ok
+}
+
+func MinorUnits(code string) (int, bool) {
+	digits, ok := minorUnits[code]
+	return digits, ok
+}
+
+// FitsCurrency reports whether a has no more fraction digits than the
+// currency allows.
+func (a Amount) FitsCurrency(code string) bool {
+	digits, ok := minorUnits[code]
+	return ok && a.FractionDigits() <= digits
+}
@@ -0,0 +1,251 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Scale is the number of fraction digits an Amount can hold. It matches the
+// NUMERIC(19,4) columns amounts are stored in.
+const Scale = 4
+
+const unitsPerWhole = 10000
+
+var ErrInvalidAmount = errors.New("invalid amount")
+
+// Amount is an exact decimal stored as a count of 10^-Scale units. It
+// marshals to a plain JSON number so clients keep sending and reading 79 or
+// 79.50 as before.
+type Amount struct {
+	units int64
+}
+
+func FromInt(n int64) Amount {
+	return Amount{units: n * unitsPerWhole}
+}
+
+// FromUnits builds an Amount from a count of 10^-Scale units.
+func FromUnits(units int64) Amount {
+	return Amount{units: units}
+}
+
+// Parse reads a decimal string such as "79", "-0.25" or "1e3" exactly and
+// rejects values with more than Scale fraction digits.
+func Parse(s string) (Amount, error) {
+	units, ok := parseUnits(s, unitsPerWhole)
+	if !ok {
+		return Amount{}, ErrInvalidAmount
+	}
+	return Amount{units: units}, nil
+}
+
+// parseUnits reads the decimal s as a whole number of 1/perWhole units.
+func parseUnits(s string, perWhole int64) (int64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.TrimLeft(s, "0123456789.+-eE") != "" {
+		return 0, false
+	}
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		// keep big.Rat from expanding absurd exponents
+		exp, err := strconv.Atoi(s[i+1:])
+		if err != nil || exp > 20 || exp < -20 {
+			return 0, false
+		}
+	}
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, false
+	}
+	r.Mul(r, big.NewRat(perWhole, 1))
+	if !r.IsInt() || !r.Num().IsInt64() {
+		return 0, false
+	}
+	return r.Num().Int64(), true
+}
+
+func MustParse(s string) Amount {
+	a, err := Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+func (a Amount) Units() int64 {
+	return a.units
+}
+
+func (a Amount) IsZero() bool {
+	return a.units == 0
+}
+
+func (a Amount) Sign() int {
+	switch {
+	case a.units < 0:
+		return -1
+	case a.units > 0:
+		return 1
+	}
+	return 0
+}
+
+func (a Amount) Cmp(b Amount) int {
+	switch {
+	case a.units < b.units:
+		return -1
+	case a.units > b.units:
+		return 1
+	}
+	return 0
+}
+
+func (a Amount) Add(b Amount) Amount {
+	return Amount{units: a.units + b.units}
+}
+
+func (a Amount) Sub(b Amount) Amount {
+	return Amount{units: a.units - b.units}
+}
+
+func (a Amount) Neg() Amount {
+	return Amount{units: -a.units}
+}
+
+// Div divides a by a positive n, rounding half away from zero like
+// Postgres' ROUND.
+func (a Amount) Div(n int64) Amount {
+	q, r := a.units/n, a.units%n
+	if r < 0 {
+		r = -r
+	}
+	if 2*r >= n {
+		if a.units < 0 {
+			q--
+		} else {
+			q++
+		}
+	}
+	return Amount{units: q}
+}
+
+// Allocate splits a into parts proportional to positive weights, each a
+// whole number of steps of digits fraction digits. The steps left over by
+// rounding down go to the parts with the largest remainders, earlier parts
+// first on ties, so the parts always add up to a when a has no more than
+// digits fraction digits.
+func (a Amount) Allocate(weights []int64, digits int) []Amount {
+	step := int64(1)
+	for i := digits; i < Scale; i++ {
+		step *= 10
+	}
+	units, sign := a.units, int64(1)
+	if units < 0 {
+		units, sign = -units, -1
+	}
+	steps := big.NewInt(units / step)
+	total := new(big.Int)
+	for _, w := range weights {
+		total.Add(total, big.NewInt(w))
+	}
+	parts := make([]Amount, len(weights))
+	remainders := make([]*big.Int, len(weights))
+	left := units / step
+	for i, w := range weights {
+		q, r := new(big.Int).QuoRem(new(big.Int).Mul(steps, big.NewInt(w)), total, new(big.Int))
+		parts[i] = Amount{units: q.Int64()}
+		remainders[i] = r
+		left -= q.Int64()
+	}
+	order := make([]int, len(weights))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return remainders[order[i]].Cmp(remainders[order[j]]) > 0
+	})
+	for i := int64(0); i < left; i++ {
+		parts[order[i]].units++
+	}
+	for i := range parts {
+		parts[i].units *= sign * step
+	}
+	return parts
+}
+
+// FractionDigits is the number of significant fraction digits, so 79.50
+// has one.
+func (a Amount) FractionDigits() int {
+	frac := a.units % unitsPerWhole
+	if frac == 0 {
+		return 0
+	}
+	digits := Scale
+	for frac%10 == 0 {
+		frac /= 10
+		digits--
+	}
+	return digits
+}
+
+func (a Amount) String() string {
+	units := a.units
+	sign := ""
+	if units < 0 {
+		sign = "-"
+		units = -units
+	}
+	whole := strconv.FormatInt(units/unitsPerWhole, 10)
+	frac := units % unitsPerWhole
+	if frac == 0 {
+		return sign + whole
+	}
+	return sign + whole + "." + strings.TrimRight(fmt.Sprintf("%04d", frac), "0")
+}
+
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.String()), nil
+}
+
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := Parse(s)
+	if err != nil {
+		return err
+	}
+	*a = parsed
+	return nil
+}
+
+func (a Amount) Value() (driver.Value, error) {
+	return a.String(), nil
+}
+
+func (a *Amount) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case nil:
+		*a = Amount{}
+	case []byte:
+		*a, err = Parse(string(v))
+	case string:
+		*a, err = Parse(v)
+	case int64:
+		*a = FromInt(v)
+	case float64:
+		*a, err = Parse(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		err = fmt.Errorf("money: cannot scan %T into Amount", src)
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+//go:build unit
+
+package money
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"79", "79", false},
+		{"79.50", "79.5", false},
+		{"0.1", "0.1", false},
+		{"-12.0001", "-12.0001", false},
+		{"1e3", "1000", false},
+		{"0.00001", "", true},
+		{"abc", "", true},
+		{"1/3", "", true},
+		{"0x10", "", true},
+		{"1e999999999", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got.String())
+			}
+		})
+	}
+}
+
+func TestAmountIsExact(t *testing.T) {
+	sum := MustParse("0.1").Add(MustParse("0.2"))
+	assert.Equal(t, MustParse("0.3"), sum)
+	assert.Equal(t, "0.3", sum.String())
+	assert.Equal(t, "-0.3", sum.Neg().String())
+	assert.Equal(t, 1, sum.Cmp(MustParse("0.29")))
+}
+
+func TestAmountDiv(t *testing.T) {
+	assert.Equal(t, MustParse("3.3333"), MustParse("10").Div(3))
+	assert.Equal(t, MustParse("6.6667"), MustParse("20").Div(3))
+	assert.Equal(t, MustParse("-6.6667"), MustParse("-20").Div(3))
+	assert.Equal(t, MustParse("0.0001"), MustParse("0.0001").Div(2))
+	assert.Equal(t, MustParse("-0.0001"), MustParse("-0.0001").Div(2))
+	assert.Equal(t, MustParse("2.5"), MustParse("5").Div(2))
+}
+
+func TestAmountAllocate(t *testing.T) {
+	tests := []struct {
+		amount  string
+		weights []int64
+		digits  int
+		want    []string
+	}{
+		{"100", []int64{1, 1, 1}, 2, []string{"33.34", "33.33", "33.33"}},
+		{"-100", []int64{1, 1, 1}, 2, []string{"-33.34", "-33.33", "-33.33"}},
+		{"1000", []int64{1, 1, 1}, 0, []string{"334", "333", "333"}},
+		{"10", []int64{500000, 250000, 250000}, 2, []string{"5", "2.5", "2.5"}},
+		{"0.05", []int64{333333, 333333, 333334}, 2, []string{"0.02", "0.01", "0.02"}},
+		{"0", []int64{1, 1}, 2, []string{"0", "0"}},
+	}
+	for _, tt := range tests {
+		got := []string{}
+		for _, part := range MustParse(tt.amount).Allocate(tt.weights, tt.digits) {
+			got = append(got, part.String())
+		}
+		assert.Equal(t, tt.want, got, tt.amount)
+	}
+}
+
+func TestAmountJSON(t *testing.T) {
+	var v struct {
+		Amount Amount `json:"amount"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"amount": 79.25}`), &v))
+	assert.Equal(t, MustParse("79.25"), v.Amount)
+	assert.NoError(t, json.Unmarshal([]byte(`{"amount": "89"}`), &v))
+	assert.Equal(t, FromInt(89), v.Amount)
+	assert.Error(t, json.Unmarshal([]byte(`{"amount": 1.23456}`), &v))
+	assert.Error(t, json.Unmarshal([]byte(`{"amount": true}`), &v))
+
+	data, err := json.Marshal(v)
+	if assert.NoError(t, err) {
+		assert.Equal(t, `{"amount":89}`, string(data))
+	}
+}
+
+func TestAmountScan(t *testing.T) {
+	var a Amount
+	assert.NoError(t, a.Scan([]byte("79.5000")))
+	assert.Equal(t, MustParse("79.5"), a)
+	assert.NoError(t, a.Scan(float64(89)))
+	assert.Equal(t, FromInt(89), a)
+	assert.NoError(t, a.Scan(int64(3)))
+	assert.Equal(t, FromInt(3), a)
+	assert.Error(t, a.Scan(true))
+
+	v, err := MustParse("12.3").Value()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "12.3", v)
+	}
+}
+
+func TestFitsCurrency(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     bool
+	}{
+		{"79.50", "THB", true},
+		{"79.505", "THB", false},
+		{"1000", "JPY", true},
+		{"1000.5", "JPY", false},
+		{"1.234", "BHD", true},
+		{"1", "XXX", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.amount+" "+tt.currency, func(t *testing.T) {
+			assert.Equal(t, tt.want, MustParse(tt.amount).FitsCurrency(tt.currency))
+		})
+	}
+	assert.Equal(t, "THB", NormalizeCurrency(""))
+	assert.Equal(t, "USD", NormalizeCurrency(" usd "))
+}
+
+func TestRate(t *testing.T) {
+	for _, s := range []string{"0", "-1", "0.000000001", "abc", ""} {
+		_, err := ParseRate(s)
+		assert.Error(t, err, s)
+	}
+	usd := MustParseRate("35.125")
+	assert.Equal(t, "35.125", usd.String())
+	assert.Equal(t, "0.02846975", usd.Inverse().String())
+	assert.Equal(t, "4", MustParseRate("0.25").Inverse().String())
+
+	convert := func(a Amount, r Rate, digits int) Amount {
+		converted, ok := a.Convert(r, digits)
+		assert.True(t, ok)
+		return converted
+	}
+	assert.Equal(t, MustParse("351.25"), convert(FromInt(10), usd, 2))
+	assert.Equal(t, MustParse("0.35"), convert(MustParse("0.01"), usd, 2))
+	assert.Equal(t, MustParse("-0.35"), convert(MustParse("-0.01"), usd, 2))
+	assert.Equal(t, FromInt(351), convert(FromInt(10), usd, 0))
+	assert.Equal(t, MustParse("0.2847"), convert(MustParse("10"), usd.Inverse(), 4))
+	_, ok := FromInt(100000000000000).Convert(MustParseRate("1000"), 2)
+	assert.False(t, ok)
+	_, ok = FromUnits(math.MinInt64).Convert(MustParseRate("1.5"), 4)
+	assert.False(t, ok)
+
+	var v struct {
+		Rate Rate `json:"rate"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(`{"rate": "0.00681234"}`), &v))
+	assert.Equal(t, MustParseRate("0.00681234"), v.Rate)
+	assert.Error(t, json.Unmarshal([]byte(`{"rate": 0}`), &v))
+	assert.NoError(t, v.Rate.Scan([]byte("35.12500000")))
+	assert.Equal(t, usd, v.Rate)
+}
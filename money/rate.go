@@ -0,0 +1,128 @@
+package money
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// RateScale is the number of fraction digits a Rate can hold. It matches the
+// NUMERIC(19,8) column rates are stored in.
+const RateScale = 8
+
+const rateUnitsPerWhole = 100000000
+
+var ErrInvalidRate = errors.New("invalid rate")
+
+// Rate is a positive exchange rate, the price of one unit of a currency in
+// another, stored as a count of 10^-RateScale units. The zero Rate is not a
+// valid rate and marks one that is missing.
+type Rate struct {
+	units int64
+}
+
+// ParseRate reads a positive decimal string exactly and rejects values with
+// more than RateScale fraction digits.
+func ParseRate(s string) (Rate, error) {
+	units, ok := parseUnits(s, rateUnitsPerWhole)
+	if !ok || units <= 0 {
+		return Rate{}, ErrInvalidRate
+	}
+	return Rate{units: units}, nil
+}
+
+func MustParseRate(s string) Rate {
+	r, err := ParseRate(s)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+func (r Rate) IsZero() bool {
+	return r.units == 0
+}
+
+// Inverse is the rate of the opposite direction, rounded half away from zero
+// to RateScale fraction digits.
+func (r Rate) Inverse() Rate {
+	if r.units == 0 {
+		return r
+	}
+	return Rate{units: (rateUnitsPerWhole*rateUnitsPerWhole + r.units/2) / r.units}
+}
+
+// MaxConverted is the largest magnitude Convert returns.
+var MaxConverted = Amount{units: math.MaxInt64}
+
+// Convert multiplies a by r and rounds the result half away from zero to
+// digits fraction digits, at most Scale. ok is false when the result is
+// larger than MaxConverted either way.
+func (a Amount) Convert(r Rate, digits int) (converted Amount, ok bool) {
+	divisor, step := big.NewInt(rateUnitsPerWhole), int64(1)
+	for i := digits; i < Scale; i++ {
+		step *= 10
+	}
+	divisor.Mul(divisor, big.NewInt(step))
+	product := new(big.Int).Mul(big.NewInt(a.units), big.NewInt(r.units))
+	q, rem := new(big.Int).QuoRem(product, divisor, new(big.Int))
+	if rem.Abs(rem).Lsh(rem, 1).Cmp(divisor) >= 0 {
+		q.Add(q, big.NewInt(int64(product.Sign())))
+	}
+	if q.CmpAbs(big.NewInt(math.MaxInt64/step)) > 0 {
+		return Amount{}, false
+	}
+	return Amount{units: q.Int64() * step}, true
+}
+
+func (r Rate) String() string {
+	whole := strconv.FormatInt(r.units/rateUnitsPerWhole, 10)
+	frac := r.units % rateUnitsPerWhole
+	if frac == 0 {
+		return whole
+	}
+	return whole + "." + strings.TrimRight(fmt.Sprintf("%08d", frac), "0")
+}
+
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+	parsed, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+	*r = parsed
+	return nil
+}
+
+func (r Rate) Value() (driver.Value, error) {
+	return r.String(), nil
+}
+
+func (r *Rate) Scan(src interface{}) error {
+	var err error
+	switch v := src.(type) {
+	case []byte:
+		*r, err = ParseRate(string(v))
+	case string:
+		*r, err = ParseRate(v)
+	case float64:
+		*r, err = ParseRate(strconv.FormatFloat(v, 'f', -1, 64))
+	default:
+		err = fmt.Errorf("money: cannot scan %T into Rate", src)
+	}
+	return err
+}
@@ -6,30 +6,170 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/Temwalker/assessment/auth"
+	"github.com/Temwalker/assessment/blob"
+	"github.com/Temwalker/assessment/database"
 	"github.com/Temwalker/assessment/expense"
 	customMiddleware "github.com/Temwalker/assessment/middleware"
+	"github.com/Temwalker/assessment/money"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
+// authenticator reads the config named by AUTH_CONFIG and falls back to the
+// legacy shared key when it is unset.
+func authenticator() (auth.Authenticator, error) {
+	path := os.Getenv("AUTH_CONFIG")
+	if path == "" {
+		return auth.LegacyAPIKeys(), nil
+	}
+	return auth.LoadConfig(path)
+}
+
+// policy reads the RBAC policy named by RBAC_POLICY and falls back to
+// auth.DefaultPolicy when it is unset.
+func policy() (*auth.Policy, error) {
+	path := os.Getenv("RBAC_POLICY")
+	if path == "" {
+		return auth.DefaultPolicy(), nil
+	}
+	return auth.LoadPolicy(path)
+}
+
 func setMiddleware(e *echo.Echo) {
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
-	e.Use(customMiddleware.Authorizer)
+	a, err := authenticator()
+	if err != nil {
+		e.Logger.Fatal("can't load auth config : ", err)
+	}
+	e.Use(customMiddleware.Authenticate(a))
+	p, err := policy()
+	if err != nil {
+		e.Logger.Fatal("can't load rbac policy : ", err)
+	}
+	e.Use(customMiddleware.Authorize(p))
+	e.Use(customMiddleware.Tenant)
+}
+
+// blobStore keeps attachments in the S3 bucket named by S3_BUCKET, or else
+// below ATTACHMENT_DIR.
+func blobStore() blob.Store {
+	bucket := os.Getenv("S3_BUCKET")
+	if bucket == "" {
+		dir := os.Getenv("ATTACHMENT_DIR")
+		if dir == "" {
+			dir = "attachments"
+		}
+		return blob.NewLocalStore(dir)
+	}
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	return &blob.S3Store{
+		Endpoint:  endpoint,
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: os.Getenv("S3_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		PathStyle: os.Getenv("S3_PATH_STYLE") == "true",
+	}
 }
 
 func setRoute(e *echo.Echo) expense.Handler {
 	h := expense.NewHandler()
+	h.Blobs = blobStore()
 	e.POST("/expenses", h.CreateExpenseHandler)
+	e.GET("/expenses/search", h.SearchExpensesHandler)
+	e.POST("/expenses/import", h.ImportExpensesHandler)
+	e.POST("/expenses/import/statement", h.ImportStatementHandler)
+	e.GET("/expenses/export", h.ExportExpensesHandler)
+	e.GET("/expenses/summary", h.SummarizeExpensesHandler)
 	e.GET("/expenses/:id", h.GetExpenseByIdHandler)
 	e.PUT("/expenses/:id", h.UpdateExpenseByIDHandler)
+	e.PATCH("/expenses/:id", h.PatchExpenseByIDHandler)
 	e.GET("/expenses", h.GetAllExpensesHandler)
+	e.DELETE("/expenses/:id", h.DeleteExpenseByIDHandler)
+	e.POST("/expenses/:id/restore", h.RestoreExpenseByIDHandler)
+	e.POST("/expenses/:id/submit", h.SubmitExpenseHandler)
+	e.POST("/expenses/:id/approve", h.ApproveExpenseHandler)
+	e.POST("/expenses/:id/reject", h.RejectExpenseHandler)
+	e.POST("/expenses/:id/reimburse", h.ReimburseExpenseHandler)
+	e.POST("/expenses/:id/attachments", h.CreateAttachmentHandler)
+	e.GET("/expenses/:id/attachments", h.GetAttachmentsHandler)
+	e.GET("/expenses/:id/attachments/:attachment_id", h.DownloadAttachmentHandler)
+	e.DELETE("/expenses/:id/attachments/:attachment_id", h.DeleteAttachmentHandler)
+	e.PUT("/expenses/:id/split", h.SplitExpenseHandler)
+	e.GET("/expenses/:id/split", h.GetSplitHandler)
+	e.DELETE("/expenses/:id/split", h.DeleteSplitHandler)
+	e.GET("/balances", h.GetBalancesHandler)
+	e.POST("/budgets", h.CreateBudgetHandler)
+	e.GET("/budgets", h.GetBudgetsHandler)
+	e.GET("/budgets/:id", h.GetBudgetByIDHandler)
+	e.PUT("/budgets/:id", h.UpdateBudgetByIDHandler)
+	e.DELETE("/budgets/:id", h.DeleteBudgetByIDHandler)
+	e.GET("/budgets/:id/status", h.GetBudgetStatusHandler)
+	e.POST("/recurring-expenses", h.CreateRecurringHandler)
+	e.GET("/recurring-expenses", h.GetRecurringHandler)
+	e.GET("/recurring-expenses/:id", h.GetRecurringByIDHandler)
+	e.PUT("/recurring-expenses/:id", h.UpdateRecurringByIDHandler)
+	e.DELETE("/recurring-expenses/:id", h.DeleteRecurringByIDHandler)
+	e.POST("/accounts", h.CreateAccountHandler)
+	e.GET("/accounts", h.GetAccountsHandler)
+	e.GET("/accounts/:id", h.GetAccountByIDHandler)
+	e.PUT("/accounts/:id", h.UpdateAccountByIDHandler)
+	e.DELETE("/accounts/:id", h.DeleteAccountByIDHandler)
+	e.GET("/accounts/:id/statement", h.GetAccountStatementHandler)
+	e.POST("/transactions", h.CreateTransactionHandler)
+	e.GET("/transactions", h.GetTransactionsHandler)
+	e.GET("/transactions/flow", h.GetFlowHandler)
+	e.GET("/transactions/:id", h.GetTransactionByIDHandler)
+	e.PUT("/transactions/:id", h.UpdateTransactionByIDHandler)
+	e.DELETE("/transactions/:id", h.DeleteTransactionByIDHandler)
+	e.POST("/rates", h.SaveRatesHandler)
+	e.GET("/rates", h.GetRatesHandler)
 	return h
 }
 
+// loadRates saves the rates in the file named by EXCHANGE_RATES, if any, and
+// sets the default reporting currency from REPORTING_CURRENCY. The rates go
+// into database.DefaultTenant only, whatever the isolation; other tenants
+// keep their own through POST /rates.
+func loadRates(h *expense.Handler) error {
+	h.ReportingCurrency = strings.ToUpper(os.Getenv("REPORTING_CURRENCY"))
+	if h.ReportingCurrency != "" && !money.IsCurrency(h.ReportingCurrency) {
+		return fmt.Errorf("unknown reporting currency %q", h.ReportingCurrency)
+	}
+	path := os.Getenv("EXCHANGE_RATES")
+	if path == "" {
+		return nil
+	}
+	rates, err := expense.ReadRatesFile(path)
+	if err != nil {
+		return err
+	}
+	return h.Rates.SaveRates(database.WithTenant(context.Background(), database.DefaultTenant), rates)
+}
+
+func purgeRetention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("PURGE_RETENTION"))
+	if err != nil {
+		return expense.DefaultPurgeRetention
+	}
+	return retention
+}
+
 func startServer(e *echo.Echo) {
 	fmt.Println("start at port:", os.Getenv("PORT"))
 	if err := e.Start(os.Getenv("PORT")); err != nil && err != http.ErrServerClosed {
@@ -37,23 +177,111 @@ func startServer(e *echo.Echo) {
 	}
 }
 
-func shutDownServer(e *echo.Echo) {
+// shutDownServer drains the server, then stops the background jobs and
+// waits for them so none is cut off with the database closed under it.
+func shutDownServer(e *echo.Echo, stopJobs func()) {
 	fmt.Println("shutting down...")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	if err := e.Shutdown(ctx); err != nil {
 		e.Logger.Fatal(err)
 	}
+	stopJobs()
+}
+
+func runMigrate(args []string) error {
+	db, err := database.GetDB()
+	if err != nil {
+		return err
+	}
+	defer db.CloseDB()
+	migrations, err := database.Migrations()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		return db.MigrateUp(ctx, migrations)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps: %s", args[1])
+			}
+		}
+		return db.MigrateDown(ctx, migrations, steps)
+	case "status":
+		status, err := db.MigrationStatus(ctx, migrations)
+		for _, m := range status {
+			state := "pending"
+			if m.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, state)
+		}
+		return err
+	case "tenant":
+		return runTenant(ctx, db, migrations, args[1:])
+	}
+	return fmt.Errorf("unknown migrate command: %s (want up, down [steps], status or tenant)", command)
+}
+
+func runTenant(ctx context.Context, db *database.DB, migrations []database.Migration, args []string) error {
+	if len(args) == 2 && args[0] == "add" {
+		return db.ProvisionTenant(ctx, args[1], migrations)
+	}
+	if len(args) == 1 && args[0] == "list" {
+		tenants, err := db.Tenants(ctx)
+		for _, tenant := range tenants {
+			fmt.Println(tenant)
+		}
+		return err
+	}
+	return fmt.Errorf("unknown tenant command (want add <name> or list)")
 }
 
 func main() {
+	if _, err := database.ParseIsolation(os.Getenv("TENANT_ISOLATION")); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil {
+			fmt.Println("migrate failed:", err)
+			os.Exit(1)
+		}
+		return
+	}
 	e := echo.New()
 	setMiddleware(e)
 	h := setRoute(e)
+	if err := loadRates(&h); err != nil {
+		e.Logger.Fatal("can't load exchange rates : ", err)
+	}
+	jobs, cancelJobs := context.WithCancel(context.Background())
+	var running sync.WaitGroup
+	for _, job := range []func(context.Context){
+		expense.NewPurger(h.Storage, h.Blobs, purgeRetention()).Run,
+		expense.NewScheduler(h.Recurring).Run,
+	} {
+		running.Add(1)
+		go func(job func(context.Context)) {
+			defer running.Done()
+			job(jobs)
+		}(job)
+	}
 	go startServer(e)
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 	defer h.Close()
-	shutDownServer(e)
+	shutDownServer(e, func() {
+		cancelJobs()
+		running.Wait()
+	})
 }
@@ -14,6 +14,7 @@ import (
 	"testing"
 
 	"github.com/Temwalker/assessment/expense"
+	"github.com/Temwalker/assessment/money"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -117,7 +118,7 @@ func TestServerGetExpenseByID(t *testing.T) {
 		want       interface{}
 	}{
 		{"Get Expense By ID Return HTTP OK and Query Expense", "November 10, 2009", strconv.Itoa(seed.ID), http.StatusOK, seed},
-		{"Get Expense By ID but not found Return HTTP Status Bad Request", "November 10, 2009", "0", http.StatusBadRequest, expense.Err{Msg: "Expense not found"}},
+		{"Get Expense By ID but not found Return HTTP Status Not Found", "November 10, 2009", "0", http.StatusNotFound, expense.Err{Msg: "Expense not found"}},
 		{"Get Expense By ID but Authorization failed Return HTTP Status Unauthorized", "HELLO", strconv.Itoa(seed.ID), http.StatusUnauthorized, ""},
 	}
 	for _, tt := range tests {
@@ -139,11 +140,16 @@ func TestServerUpdateExpenseByID(t *testing.T) {
 		t.Fatal("can't seed expense : ", err)
 	}
 	wantOK := expense.Expense{
-		ID:     seed.ID,
-		Title:  "apple smoothie",
-		Amount: 89,
-		Note:   "no discount",
-		Tags:   []string{"beverage"},
+		ID:        seed.ID,
+		Title:     "apple smoothie",
+		Amount:    money.FromInt(89),
+		Currency:  "THB",
+		Note:      "no discount",
+		Tags:      []string{"beverage"},
+		OwnerID:   seed.OwnerID,
+		SpentAt:   seed.SpentAt,
+		CreatedAt: seed.CreatedAt,
+		Status:    seed.Status,
 	}
 	tests := []struct {
 		testname   string
@@ -160,13 +166,13 @@ func TestServerUpdateExpenseByID(t *testing.T) {
 			"amount": 89,
 			"note": "no discount", 
 			"tags": ["beverage"]}`, http.StatusOK, wantOK},
-		{"Update Expense By ID but not found Return HTTP Status Bad Request", "November 10, 2009", "0",
+		{"Update Expense By ID but not found Return HTTP Status Not Found", "November 10, 2009", "0",
 			`{
 			"id": ` + strconv.Itoa(0) + `,
 			"title": "apple smoothie",
 			"amount": 89,
 			"note": "no discount", 
-			"tags": ["beverage"]}`, http.StatusBadRequest, expense.Err{Msg: "Expense not found"}},
+			"tags": ["beverage"]}`, http.StatusNotFound, expense.Err{Msg: "Expense not found"}},
 		{"Update Expense By ID but Authorization failed Return HTTP Status Unauthorized", "HELLO", strconv.Itoa(seed.ID),
 			`{
 			"id": ` + strconv.Itoa(seed.ID) + `,
@@ -180,6 +186,12 @@ func TestServerUpdateExpenseByID(t *testing.T) {
 			expected, _ := json.Marshal(tt.want)
 			res := request(http.MethodPut, uri("expenses", tt.id), tt.auth, bytes.NewBufferString(tt.testdata))
 			got, err := res.DecodeString()
+			if want, ok := tt.want.(expense.Expense); ok {
+				updated := expense.Expense{}
+				json.Unmarshal([]byte(got), &updated)
+				want.UpdatedAt = updated.UpdatedAt
+				expected, _ = json.Marshal(want)
+			}
 			if assert.NoError(t, err) {
 				assert.Equal(t, tt.httpStatus, res.StatusCode)
 				assert.Equal(t, string(expected), strings.TrimSpace(got))
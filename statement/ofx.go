@@ -0,0 +1,133 @@
+package statement
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseOFX reads the STMTTRN records of an OFX 1.x (SGML) or 2.x (XML)
+// file. SGML leaves leaf elements unclosed, so both variants are read as a
+// stream of tags, each leaf's value being the text that follows its tag.
+func ParseOFX(data []byte) ([]Transaction, error) {
+	start := bytes.Index(bytes.ToUpper(data), []byte("<OFX>"))
+	if start < 0 {
+		return nil, fmt.Errorf("ofx: missing <OFX> element")
+	}
+	data = data[start:]
+
+	txns := []Transaction{}
+	var current *Transaction
+	var posted, amount string
+	currency := ""
+	finish := func() error {
+		if current == nil {
+			return nil
+		}
+		t := current
+		current = nil
+		n := len(txns) + 1
+		if posted == "" || amount == "" {
+			return fmt.Errorf("ofx: transaction %d: missing DTPOSTED or TRNAMT", n)
+		}
+		var err error
+		if t.Posted, err = parseOFXDate(posted); err != nil {
+			return fmt.Errorf("ofx: transaction %d: invalid DTPOSTED %q", n, posted)
+		}
+		if t.Amount, err = parseAmount(amount); err != nil {
+			return fmt.Errorf("ofx: transaction %d: invalid TRNAMT %q", n, amount)
+		}
+		if t.Currency == "" {
+			t.Currency = currency
+		}
+		txns = append(txns, *t)
+		return nil
+	}
+
+	for len(data) > 0 {
+		open := bytes.IndexByte(data, '<')
+		if open < 0 {
+			break
+		}
+		end := bytes.IndexByte(data[open:], '>')
+		if end < 0 {
+			return nil, fmt.Errorf("ofx: unterminated tag")
+		}
+		tag := strings.ToUpper(strings.TrimSpace(string(data[open+1 : open+end])))
+		data = data[open+end+1:]
+		next := bytes.IndexByte(data, '<')
+		if next < 0 {
+			next = len(data)
+		}
+		value := strings.TrimSpace(html.UnescapeString(string(data[:next])))
+
+		switch tag {
+		case "STMTTRN":
+			if err := finish(); err != nil {
+				return nil, err
+			}
+			current = &Transaction{}
+			posted, amount = "", ""
+		case "/STMTTRN", "/BANKTRANLIST":
+			if err := finish(); err != nil {
+				return nil, err
+			}
+		case "CURDEF":
+			currency = value
+		}
+		if current == nil {
+			continue
+		}
+		switch tag {
+		case "FITID":
+			current.FITID = value
+		case "DTPOSTED":
+			posted = value
+		case "TRNAMT":
+			amount = value
+		case "NAME":
+			current.Payee = value
+		case "MEMO":
+			current.Memo = value
+		case "CURSYM":
+			current.Currency = value
+		}
+	}
+	if err := finish(); err != nil {
+		return nil, err
+	}
+	assignIDs("ofx-", txns)
+	return txns, nil
+}
+
+// parseOFXDate reads YYYYMMDD[HHMMSS[.XXX]][[offset:TZ]], where offset is
+// in hours, e.g. 20230115093000.000[-5:EST]. Dates without an offset are
+// GMT.
+func parseOFXDate(s string) (time.Time, error) {
+	loc := time.UTC
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		zone := strings.TrimSuffix(s[i+1:], "]")
+		s = s[:i]
+		name := ""
+		if j := strings.IndexByte(zone, ':'); j >= 0 {
+			zone, name = zone[:j], zone[j+1:]
+		}
+		hours, err := strconv.ParseFloat(zone, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		loc = time.FixedZone(name, int(hours*3600))
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		s = s[:i]
+	}
+	layouts := map[int]string{8: "20060102", 12: "200601021504", 14: "20060102150405"}
+	layout, ok := layouts[len(s)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return time.ParseInLocation(layout, s, loc)
+}
@@ -0,0 +1,117 @@
+package statement
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseQIF reads the bank and credit card records of a QIF file. Records
+// of other types, such as investment or memorized transactions, are
+// skipped.
+func ParseQIF(data []byte, opts Options) ([]Transaction, error) {
+	txns := []Transaction{}
+	scanner := bufio.NewScanner(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	line := 0
+	records := true
+	var current Transaction
+	var posted, amount string
+	start, empty := 0, true
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+		if text[0] == '!' {
+			header := strings.ToUpper(strings.TrimSpace(text))
+			if strings.HasPrefix(header, "!TYPE:") {
+				kind := strings.TrimPrefix(header, "!TYPE:")
+				records = kind == "BANK" || kind == "CASH" || kind == "CCARD" || kind == "OTH L" || kind == "OTH A"
+			}
+			continue
+		}
+		if empty {
+			start, empty = line, false
+		}
+		code, value := text[0], strings.TrimSpace(text[1:])
+		switch code {
+		case 'D':
+			posted = value
+		case 'T', 'U':
+			amount = value
+		case 'P':
+			current.Payee = value
+		case 'M':
+			current.Memo = value
+		case 'L':
+			current.Category = strings.Trim(value, "[]")
+		case '^':
+			if records {
+				if posted == "" || amount == "" {
+					return nil, fmt.Errorf("qif: record at line %d: missing date or amount", start)
+				}
+				var err error
+				if current.Posted, err = parseQIFDate(posted, opts.DayFirst); err != nil {
+					return nil, fmt.Errorf("qif: record at line %d: invalid date %q", start, posted)
+				}
+				if current.Amount, err = parseAmount(amount); err != nil {
+					return nil, fmt.Errorf("qif: record at line %d: invalid amount %q", start, amount)
+				}
+				txns = append(txns, current)
+			}
+			current, posted, amount, empty = Transaction{}, "", "", true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if !empty {
+		return nil, fmt.Errorf("qif: record at line %d: missing ^ terminator", start)
+	}
+	assignIDs("qif-", txns)
+	return txns, nil
+}
+
+// parseQIFDate reads the dates Quicken and banks write: 1/15/2023,
+// 01/15/23, 1/15'23 (an apostrophe marking 2000 and later), 1-15-2023 and
+// 2023-01-15.
+func parseQIFDate(s string, dayFirst bool) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, nil
+	}
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return r == '/' || r == '-' || r == '.' || r == '\'' || r == ' '
+	})
+	if len(parts) != 3 {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	nums := make([]int, 3)
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid date %q", s)
+		}
+		nums[i] = n
+	}
+	month, day, year := nums[0], nums[1], nums[2]
+	if dayFirst {
+		month, day = day, month
+	}
+	if len(parts[2]) <= 2 {
+		if strings.Contains(s, "'") || year < 70 {
+			year += 2000
+		} else {
+			year += 1900
+		}
+	}
+	t := time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	if t.Year() != year || t.Month() != time.Month(month) || t.Day() != day {
+		return time.Time{}, fmt.Errorf("invalid date %q", s)
+	}
+	return t, nil
+}
@@ -0,0 +1,99 @@
+// Package statement reads the transactions of bank statement exports in
+// OFX/QFX and QIF formats.
+package statement
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+)
+
+const (
+	FormatOFX = "ofx"
+	FormatQIF = "qif"
+)
+
+var ErrUnknownFormat = errors.New("statement: unknown format")
+
+// Transaction is one statement line. Amount is negative for money leaving
+// the account. FITID is the bank's transaction id or, when the file has none,
+// one derived from the transaction itself.
+type Transaction struct {
+	FITID    string
+	Posted   time.Time
+	Amount   money.Amount
+	Payee    string
+	Memo     string
+	Category string
+	Currency string
+}
+
+// Options tune how ambiguous files are read.
+type Options struct {
+	// DayFirst reads QIF dates such as 02/01/2023 as 2 January.
+	DayFirst bool
+}
+
+// Detect names the format of data, or returns "" when it is neither.
+func Detect(data []byte) string {
+	head := bytes.ToUpper(bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	switch {
+	case bytes.HasPrefix(head, []byte("OFXHEADER")), bytes.HasPrefix(head, []byte("<?XML")), bytes.HasPrefix(head, []byte("<OFX")):
+		return FormatOFX
+	case bytes.HasPrefix(head, []byte("!TYPE")), bytes.HasPrefix(head, []byte("!ACCOUNT")), bytes.HasPrefix(head, []byte("!OPTION")):
+		return FormatQIF
+	}
+	return ""
+}
+
+// Parse reads data as format, or as the detected format when format is "".
+// QFX is read as OFX.
+func Parse(data []byte, format string, opts Options) ([]Transaction, error) {
+	if format == "" {
+		format = Detect(data)
+	}
+	switch strings.ToLower(format) {
+	case FormatOFX, "qfx":
+		return ParseOFX(data)
+	case FormatQIF:
+		return ParseQIF(data, opts)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// parseAmount accepts thousands separators and a decimal comma, as some
+// banks write "-1,234.56" or "-12,34".
+func parseAmount(s string) (money.Amount, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, ",") && !strings.Contains(s, ".") && len(s)-strings.LastIndex(s, ",") != 4 {
+		s = strings.Replace(s, ",", ".", 1)
+	}
+	return money.Parse(strings.ReplaceAll(s, ",", ""))
+}
+
+// assignIDs derives an id for every transaction without one from its date,
+// amount and text. Identical transactions in one file are told apart by
+// their position among each other, so re-importing an overlapping statement
+// finds the same ids.
+func assignIDs(prefix string, txns []Transaction) {
+	seen := map[string]int{}
+	for i := range txns {
+		t := &txns[i]
+		if t.FITID != "" {
+			continue
+		}
+		key := strings.Join([]string{t.Posted.Format("2006-01-02"), t.Amount.String(), t.Payee, t.Memo}, "\x00")
+		seen[key]++
+		sum := sha256.Sum256([]byte(key + "\x00" + strconv.Itoa(seen[key])))
+		t.FITID = prefix + hex.EncodeToString(sum[:12])
+	}
+}
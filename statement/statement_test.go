@@ -0,0 +1,154 @@
+//go:build unit
+
+package statement
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Temwalker/assessment/money"
+	"github.com/stretchr/testify/assert"
+)
+
+const sgmlOFX = `OFXHEADER:100
+DATA:OFXSGML
+VERSION:102
+ENCODING:USASCII
+
+<OFX>
+<SIGNONMSGSRSV1><SONRS><STATUS><CODE>0<SEVERITY>INFO</STATUS><DTSERVER>20230201</SONRS></SIGNONMSGSRSV1>
+<BANKMSGSRSV1><STMTTRNRS><STMTRS>
+<CURDEF>THB
+<BANKTRANLIST>
+<DTSTART>20230101<DTEND>20230131
+<STMTTRN>
+<TRNTYPE>DEBIT
+<DTPOSTED>20230115093000.000[+7:ICT]
+<TRNAMT>-1,250.50
+<FITID>202301150001
+<NAME>Grab &amp; Go
+<MEMO>Taxi to client
+</STMTTRN>
+<STMTTRN>
+<TRNTYPE>CREDIT
+<DTPOSTED>20230120
+<TRNAMT>30000
+<FITID>202301200001
+<NAME>Salary
+<CURRENCY><CURRATE>1.0<CURSYM>USD</CURRENCY>
+</STMTTRN>
+</BANKTRANLIST>
+</STMTRS></STMTTRNRS></BANKMSGSRSV1>
+</OFX>
+`
+
+const xmlOFX = `<?xml version="1.0" encoding="UTF-8" standalone="no"?>
+<?OFX OFXHEADER="200" VERSION="211" SECURITY="NONE" OLDFILEUID="NONE" NEWFILEUID="NONE"?>
+<OFX>
+  <CREDITCARDMSGSRSV1><CCSTMTTRNRS><CCSTMTRS>
+    <CURDEF>EUR</CURDEF>
+    <BANKTRANLIST>
+      <STMTTRN>
+        <TRNTYPE>DEBIT</TRNTYPE>
+        <DTPOSTED>20230302</DTPOSTED>
+        <TRNAMT>-12,34</TRNAMT>
+        <FITID>cc-77</FITID>
+        <NAME>Caf&#233; Central</NAME>
+      </STMTTRN>
+    </BANKTRANLIST>
+  </CCSTMTRS></CCSTMTTRNRS></CREDITCARDMSGSRSV1>
+</OFX>`
+
+const qif = "!Type:Bank\r\n" +
+	"D1/15'23\r\nT-1,250.50\r\nPGrab\r\nMTaxi\r\nLTravel:Taxi\r\n^\r\n" +
+	"D01/16/2023\r\nT-60.00\r\nPCoffee\r\n^\r\n" +
+	"D01/16/2023\r\nT-60.00\r\nPCoffee\r\n^\r\n" +
+	"!Type:Invst\r\nD1/17/2023\r\nNBuy\r\nT-500\r\n^\r\n"
+
+func TestParseOFX(t *testing.T) {
+	for _, data := range []string{sgmlOFX, xmlOFX} {
+		assert.Equal(t, FormatOFX, Detect([]byte(data)))
+	}
+
+	got, err := Parse([]byte(sgmlOFX), "", Options{})
+	if assert.NoError(t, err) && assert.Equal(t, 2, len(got)) {
+		assert.Equal(t, Transaction{
+			FITID:    "202301150001",
+			Posted:   time.Date(2023, 1, 15, 2, 30, 0, 0, time.UTC),
+			Amount:   money.MustParse("-1250.50"),
+			Payee:    "Grab & Go",
+			Memo:     "Taxi to client",
+			Currency: "THB",
+		}, Transaction{got[0].FITID, got[0].Posted.UTC(), got[0].Amount, got[0].Payee, got[0].Memo, got[0].Category, got[0].Currency})
+		assert.Equal(t, "USD", got[1].Currency)
+		assert.Equal(t, money.MustParse("30000"), got[1].Amount)
+	}
+
+	got, err = Parse([]byte(xmlOFX), "qfx", Options{})
+	if assert.NoError(t, err) && assert.Equal(t, 1, len(got)) {
+		assert.Equal(t, "cc-77", got[0].FITID)
+		assert.Equal(t, "Café Central", got[0].Payee)
+		assert.Equal(t, money.MustParse("-12.34"), got[0].Amount)
+		assert.Equal(t, "EUR", got[0].Currency)
+		assert.Equal(t, time.Date(2023, 3, 2, 0, 0, 0, 0, time.UTC), got[0].Posted)
+	}
+
+	_, err = ParseOFX([]byte("<OFX><STMTTRN><DTPOSTED>2023<TRNAMT>-1</STMTTRN></OFX>"))
+	assert.EqualError(t, err, `ofx: transaction 1: invalid DTPOSTED "2023"`)
+	_, err = ParseOFX([]byte("not a statement"))
+	assert.Error(t, err)
+}
+
+func TestParseQIF(t *testing.T) {
+	assert.Equal(t, FormatQIF, Detect([]byte(qif)))
+
+	got, err := Parse([]byte(qif), "", Options{})
+	if assert.NoError(t, err) && assert.Equal(t, 3, len(got)) {
+		assert.Equal(t, time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC), got[0].Posted)
+		assert.Equal(t, money.MustParse("-1250.50"), got[0].Amount)
+		assert.Equal(t, "Grab", got[0].Payee)
+		assert.Equal(t, "Taxi", got[0].Memo)
+		assert.Equal(t, "Travel:Taxi", got[0].Category)
+		assert.NotEqual(t, got[1].FITID, got[2].FITID, "identical transactions keep distinct ids")
+	}
+	again, _ := Parse([]byte(qif), "", Options{})
+	if assert.Equal(t, 3, len(again)) {
+		assert.Equal(t, got[2].FITID, again[2].FITID, "ids are stable across imports")
+	}
+
+	_, err = ParseQIF([]byte("!Type:Bank\nD13/45/2023\nT-1\n^\n"), Options{})
+	assert.EqualError(t, err, `qif: record at line 2: invalid date "13/45/2023"`)
+	_, err = ParseQIF([]byte("!Type:Bank\nD1/2/2023\nT-1\n"), Options{})
+	assert.EqualError(t, err, "qif: record at line 2: missing ^ terminator")
+}
+
+func TestParseQIFDate(t *testing.T) {
+	tests := []struct {
+		in       string
+		dayFirst bool
+		want     time.Time
+	}{
+		{"1/15/2023", false, time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"01/15/99", false, time.Date(1999, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{" 1/ 5'04", false, time.Date(2004, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"2023-01-15", false, time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{"15.01.2023", true, time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseQIFDate(tt.in, tt.dayFirst)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	for in, want := range map[string]string{"-1,250.50": "-1250.5", "-12,34": "-12.34", "1,234": "1234", "7": "7"} {
+		got, err := parseAmount(in)
+		if assert.NoError(t, err, in) {
+			assert.Equal(t, want, got.String(), in)
+		}
+	}
+}